@@ -0,0 +1,270 @@
+package rozer
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"strconv"
+)
+
+// Batch holds a set of object rows by column: one Column for each name found
+// in the rows, sharing one index from names to columns. Reading a field of
+// every row finds its column once rather than looking the name up in each
+// row.
+type Batch struct {
+	names   map[string]int
+	columns []*Column
+	len     int
+}
+
+// Column holds the values of one field for every row of a Batch. If every
+// row that has the field has a value of the same type, integer (int or
+// int64), number (float64), string or boolean, Type is that type and the
+// values are in the slice for it. Otherwise Type is 0 and the values are in
+// Values. A json.Number is an integer if it is written as one and fits in an
+// int64, and a number if a float64 holds it without losing digits; any other
+// json.Number, such as an integer too large for an int64, leaves the column
+// untyped and is kept as it is. As JSON does not tell integers and numbers
+// apart, a column whose integers are all json.Numbers and that also has
+// numbers is typed as numbers if a float64 holds each of those integers too.
+type Column struct {
+	Name    string
+	Type    Type
+	Ints    []int64
+	Floats  []float64
+	Strings []string
+	Bools   []bool
+	Values  []any
+	// Missing marks the rows without the field, or is nil if every row has
+	// it. The typed slices hold the zero value for those rows.
+	Missing []bool
+}
+
+// NewBatch returns a batch of rows, which must be objects. The columns are in
+// the order their names are first found. Only the last value of a name given
+// to several values with Add, and no value without a name, is kept.
+func NewBatch(rows []*Roze) (*Batch, error) {
+	b := &Batch{names: map[string]int{}, len: len(rows)}
+
+	var values [][]any
+	for i, r := range rows {
+		if r == nil || r.IsArray() {
+			return nil, fmt.Errorf("row %d is not an object", i)
+		}
+		names, named := r.nameAt()
+		for p, name := range names {
+			if !named[p] || r.names[name] != p {
+				continue
+			}
+			c, ok := b.names[name]
+			if !ok {
+				c = len(b.columns)
+				b.names[name] = c
+				b.columns = append(b.columns, &Column{Name: name})
+				column := make([]any, len(rows))
+				for j := range column {
+					column[j] = absent{}
+				}
+				values = append(values, column)
+			}
+			values[c][i] = r.data[p]
+		}
+	}
+
+	for c, column := range b.columns {
+		column.fill(values[c])
+	}
+	return b, nil
+}
+
+// absent marks the rows without a field while a Batch is made.
+type absent struct{}
+
+// fill sets the values of the column, typed if they share a type.
+func (c *Column) fill(values []any) {
+	c.Type = 0
+	first := true
+	// widen is whether every integer so far is a json.Number that a float64
+	// holds exactly.
+	widen := true
+	for i, value := range values {
+		if value == (absent{}) {
+			if c.Missing == nil {
+				c.Missing = make([]bool, len(values))
+			}
+			c.Missing[i] = true
+			values[i] = nil
+			continue
+		}
+		t := columnType(value)
+		if t == TypeInteger {
+			n, ok := value.(json.Number)
+			if !ok {
+				widen = false
+			} else if _, exact := exactFloat(n); !exact {
+				widen = false
+			}
+		}
+		switch {
+		case first:
+			c.Type, first = t, false
+		case t == c.Type:
+		case widen && (t == TypeNumber && c.Type == TypeInteger || t == TypeInteger && c.Type == TypeNumber):
+			c.Type = TypeNumber
+		default:
+			c.Type = 0
+		}
+	}
+
+	n := len(values)
+	switch c.Type {
+	case TypeInteger:
+		c.Ints = make([]int64, n)
+	case TypeNumber:
+		c.Floats = make([]float64, n)
+	case TypeString:
+		c.Strings = make([]string, n)
+	case TypeBool:
+		c.Bools = make([]bool, n)
+	default:
+		c.Values = values
+		return
+	}
+	for i, value := range values {
+		switch v := value.(type) {
+		case int:
+			c.Ints[i] = int64(v)
+		case int64:
+			c.Ints[i] = v
+		case float64:
+			c.Floats[i] = v
+		case json.Number:
+			if c.Type == TypeInteger {
+				c.Ints[i], _ = v.Int64()
+			} else {
+				c.Floats[i], _ = v.Float64()
+			}
+		case string:
+			c.Strings[i] = v
+		case bool:
+			c.Bools[i] = v
+		}
+	}
+}
+
+// columnType returns the type of a value that can be held in a typed
+// column, or 0.
+func columnType(value any) Type {
+	switch v := value.(type) {
+	case int, int64:
+		return TypeInteger
+	case float64:
+		return TypeNumber
+	case json.Number:
+		if _, err := v.Int64(); err == nil && IsInteger(v) {
+			return TypeInteger
+		}
+		if _, exact := exactFloat(v); exact {
+			return TypeNumber
+		}
+		return 0
+	case string:
+		return TypeString
+	case bool:
+		return TypeBool
+	default:
+		return 0
+	}
+}
+
+// exactFloat returns n as a float64, and whether that is written back as the
+// same number, so that holding n as a float64 loses no digits.
+func exactFloat(n json.Number) (float64, bool) {
+	f, err := n.Float64()
+	if err != nil {
+		return 0, false
+	}
+	want, ok := new(big.Rat).SetString(n.String())
+	if !ok {
+		return 0, false
+	}
+	got, _ := new(big.Rat).SetString(strconv.FormatFloat(f, 'g', -1, 64))
+	return f, want.Cmp(got) == 0
+}
+
+// Len returns the number of rows.
+func (b *Batch) Len() int {
+	return b.len
+}
+
+// Names returns the names of the columns, in order.
+func (b *Batch) Names() []string {
+	names := make([]string, len(b.columns))
+	for i, c := range b.columns {
+		names[i] = c.Name
+	}
+	return names
+}
+
+// Index returns the index of the column with the name, and whether there is
+// one.
+func (b *Batch) Index(name string) (int, bool) {
+	i, ok := b.names[name]
+	return i, ok
+}
+
+// Column returns column i.
+func (b *Batch) Column(i int) *Column {
+	return b.columns[i]
+}
+
+// Value returns the value of column c in row i, and whether the row has one.
+func (b *Batch) Value(i, c int) (any, bool) {
+	return b.columns[c].Value(i)
+}
+
+// Value returns the value of the column in row i, and whether the row has
+// one.
+func (c *Column) Value(i int) (any, bool) {
+	if c.Missing != nil && c.Missing[i] {
+		return nil, false
+	}
+	switch c.Type {
+	case TypeInteger:
+		return c.Ints[i], true
+	case TypeNumber:
+		return c.Floats[i], true
+	case TypeString:
+		return c.Strings[i], true
+	case TypeBool:
+		return c.Bools[i], true
+	default:
+		return c.Values[i], true
+	}
+}
+
+// Row returns row i as a new Roze, with its values in column order. Integers
+// in typed columns are int64, and nested Roze values are shared with the
+// batch.
+func (b *Batch) Row(i int) *Roze {
+	r := &Roze{
+		data:  make([]any, 0, len(b.columns)),
+		names: make(map[string]int, len(b.columns)),
+	}
+	for _, c := range b.columns {
+		if value, ok := c.Value(i); ok {
+			r.names[c.Name] = len(r.data)
+			r.data = append(r.data, value)
+		}
+	}
+	return r
+}
+
+// Rows returns every row, as Row does.
+func (b *Batch) Rows() []*Roze {
+	rows := make([]*Roze, b.len)
+	for i := range rows {
+		rows[i] = b.Row(i)
+	}
+	return rows
+}
@@ -0,0 +1,166 @@
+package rozer
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestBatch(t *testing.T) {
+	in := rows(t,
+		`{"id": 1, "name": "one", "price": 1.5, "tags": ["a"]}`,
+		`{"name": "two", "id": 2, "price": 2.5, "note": null}`,
+		`{"id": 3, "price": 3, "flag": true}`,
+	)
+	b, err := NewBatch(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b.Len() != 3 || fmt.Sprint(b.Names()) != "[id name price tags note flag]" {
+		t.Errorf("got %d rows of %v", b.Len(), b.Names())
+	}
+
+	for _, tt := range []struct {
+		name    string
+		typ     Type
+		missing string
+	}{
+		{"id", TypeInteger, "[]"},
+		{"name", TypeString, "[false false true]"},
+		{"price", TypeNumber, "[]"},
+		{"tags", 0, "[false true true]"},
+		{"note", 0, "[true false true]"},
+		{"flag", TypeBool, "[true true false]"},
+	} {
+		i, ok := b.Index(tt.name)
+		if !ok {
+			t.Fatalf("no column %s", tt.name)
+		}
+		c := b.Column(i)
+		if c.Type != tt.typ || fmt.Sprint(c.Missing) != tt.missing {
+			t.Errorf("%s: got %s, missing %v", tt.name, c.Type, c.Missing)
+		}
+	}
+
+	i, _ := b.Index("price")
+	if got := b.Column(i).Floats; fmt.Sprint(got) != "[1.5 2.5 3]" {
+		t.Errorf("got prices %v", got)
+	}
+	if v, ok := b.Value(1, i); !ok || v != 2.5 {
+		t.Errorf("got %v, %v", v, ok)
+	}
+
+	var got []string
+	for _, r := range b.Rows() {
+		got = append(got, marshal(t, r))
+	}
+	want := `[{"id":1,"name":"one","price":1.5,"tags":["a"]} {"id":2,"name":"two","price":2.5,"note":null} {"id":3,"price":3,"flag":true}]`
+	if fmt.Sprint(got) != want {
+		t.Errorf("got  %v\nwant %s", got, want)
+	}
+
+	if _, err := NewBatch([]*Roze{New().Append(1)}); err == nil {
+		t.Errorf("made a batch of an array")
+	}
+}
+
+func TestBatchOfJSONNumbers(t *testing.T) {
+	in := rows(t,
+		`{"int": 1, "mixed": 1, "big": 1, "huge": 1e400, "native": 1}`,
+		`{"int": -2, "mixed": 2.5, "big": 18446744073709551616, "huge": 2, "native": 2.5}`,
+	)
+	in[0].Put("native", int64(1))
+
+	b, err := NewBatch(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		name   string
+		typ    Type
+		values string
+	}{
+		{"int", TypeInteger, "[1 -2]"},
+		{"mixed", TypeNumber, "[1 2.5]"},
+		{"big", 0, "[1 18446744073709551616]"},
+		{"huge", 0, "[1e400 2]"},
+		{"native", 0, "[1 2.5]"},
+	} {
+		i, _ := b.Index(tt.name)
+		c := b.Column(i)
+		var values any = c.Values
+		switch c.Type {
+		case TypeInteger:
+			values = c.Ints
+		case TypeNumber:
+			values = c.Floats
+		}
+		if c.Type != tt.typ || fmt.Sprint(values) != tt.values {
+			t.Errorf("%s: got %s %v, want %s %s", tt.name, c.Type, values, tt.typ, tt.values)
+		}
+	}
+}
+
+func TestBatchKeepsJSONNumbers(t *testing.T) {
+	lines := []string{
+		`{"id":12345678901234567890,"n":9007199254740993,"x":0.1}`,
+		`{"id":1,"n":1.5,"x":1e-7}`,
+		`{"id":2,"n":2,"x":2.50}`,
+	}
+	b, err := NewBatch(rows(t, lines...))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// columns that would lose digits as float64s are left untyped, and the
+	// others hold the same numbers.
+	for name, typ := range map[string]Type{"id": 0, "n": 0, "x": TypeNumber} {
+		i, _ := b.Index(name)
+		if c := b.Column(i); c.Type != typ {
+			t.Errorf("%s: got %s, want %s", name, c.Type, typ)
+		}
+	}
+	want := []string{
+		lines[0],
+		lines[1],
+		`{"id":2,"n":2,"x":2.5}`,
+	}
+	for i, r := range b.Rows() {
+		if got := marshal(t, r); got != want[i] {
+			t.Errorf("row %d: got %s, want %s", i, got, want[i])
+		}
+	}
+}
+
+func BenchmarkSumField(b *testing.B) {
+	var rows []*Roze
+	for i := range 10000 {
+		r := NewObject()
+		for f := range 30 {
+			r.Put(fmt.Sprintf("field%d", f), int64(i*f))
+		}
+		rows = append(rows, r)
+	}
+
+	b.Run("rows", func(b *testing.B) {
+		for range b.N {
+			var sum int64
+			for _, r := range rows {
+				sum += r.Get("field17").(int64)
+			}
+		}
+	})
+
+	batch, err := NewBatch(rows)
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.Run("batch", func(b *testing.B) {
+		for range b.N {
+			var sum int64
+			i, _ := batch.Index("field17")
+			for _, v := range batch.Column(i).Ints {
+				sum += v
+			}
+		}
+	})
+}
@@ -0,0 +1,376 @@
+package rozer
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+)
+
+// Format is a binary encoding of a Roze. Both formats keep the order of
+// names, integers apart from floats, and nested Roze values, and are more
+// compact and faster to read than JSON.
+//
+// An object is written as a map with its names in order, a name given to
+// several values with Add once for each, and an array as an array. In a mixed
+// Roze each value without a name is written with its position, an integer,
+// as its key, so it is read back without a name.
+//
+// Integers are read as int64, or *big.Int if they do not fit, and floats as
+// float64. A json.Number is written as an integer or a float, and a
+// *big.Float as a float64.
+type Format int
+
+const (
+	// MessagePack is https://msgpack.org. Integers must fit in an int64 or a
+	// uint64.
+	MessagePack Format = iota
+	// CBOR is RFC 8949. Integers beyond 64 bits are written as bignums.
+	CBOR
+)
+
+func (f Format) String() string {
+	switch f {
+	case MessagePack:
+		return "msgpack"
+	case CBOR:
+		return "cbor"
+	default:
+		return fmt.Sprintf("Format(%d)", int(f))
+	}
+}
+
+// maxDepth is how deeply values may be nested when reading.
+const maxDepth = 10000
+
+// Marshal returns the encoding of r.
+func (f Format) Marshal(r *Roze) ([]byte, error) {
+	w := f.writer()
+	err := writeValue(w, r)
+	if err != nil {
+		return nil, err
+	}
+	return w.bytes(), nil
+}
+
+// Unmarshal decodes a Roze encoded as a map or an array. It is an error for
+// data to hold anything more.
+func (f Format) Unmarshal(data []byte) (*Roze, error) {
+	d := f.NewDecoder(bytes.NewReader(data))
+	r, err := d.Decode()
+	if err == io.EOF {
+		return nil, io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		return nil, err
+	}
+	if _, err := d.in.ReadByte(); err != io.EOF {
+		return nil, fmt.Errorf("%s: data after the value", f)
+	}
+	return r, nil
+}
+
+// Encoder writes a sequence of Roze values to a stream.
+type Encoder struct {
+	w      io.Writer
+	format Format
+}
+
+// NewEncoder returns an encoder that writes to w.
+func (f Format) NewEncoder(w io.Writer) *Encoder {
+	return &Encoder{w, f}
+}
+
+// Encode writes the encoding of r.
+func (e *Encoder) Encode(r *Roze) error {
+	data, err := e.format.Marshal(r)
+	if err != nil {
+		return err
+	}
+	_, err = e.w.Write(data)
+	return err
+}
+
+// Decoder reads a sequence of Roze values, as written by an Encoder, from a
+// stream.
+type Decoder struct {
+	in      byteReader
+	format  Format
+	layouts *Layouts
+}
+
+type byteReader interface {
+	io.Reader
+	io.ByteScanner
+}
+
+// NewDecoder returns a decoder that reads from r. It reads ahead, so nothing
+// else should read from r.
+func (f Format) NewDecoder(r io.Reader) *Decoder {
+	in, ok := r.(byteReader)
+	if !ok {
+		in = bufio.NewReader(r)
+	}
+	return &Decoder{in: in, format: f}
+}
+
+// UseLayouts makes the decoder share the names of the maps it reads through
+// layouts.
+func (d *Decoder) UseLayouts(layouts *Layouts) {
+	d.layouts = layouts
+}
+
+// Decode reads the next Roze. It returns io.EOF after the last one.
+func (d *Decoder) Decode() (*Roze, error) {
+	value, err := readValue(d.format.reader(d.in), d.layouts, 0)
+	if err != nil {
+		return nil, err
+	}
+	r, ok := value.(*Roze)
+	if !ok {
+		return nil, fmt.Errorf("%s: expecting a map or an array, got %T", d.format, value)
+	}
+	return r, nil
+}
+
+// valueWriter writes the parts of values in a format.
+type valueWriter interface {
+	null()
+	boolean(b bool)
+	integer(i int64)
+	unsigned(u uint64)
+	float(f float64)
+	text(s string)
+	array(n int)
+	object(n int)
+	// bigInt writes an integer that does not fit in an int64 or a uint64.
+	bigInt(i *big.Int) error
+	bytes() []byte
+}
+
+// valueReader reads the parts of values in a format.
+type valueReader interface {
+	// next reads a value other than an array or map, or the start of one.
+	next() (item, error)
+	// end reports whether an array or map of unknown length has ended.
+	end() (bool, error)
+}
+
+type itemKind int
+
+const (
+	itemValue itemKind = iota
+	itemArray
+	itemMap
+)
+
+// item is a value, or the start of an array or map of n items, or pairs of
+// items. n is -1 if the length is not known until the end.
+type item struct {
+	kind  itemKind
+	value any
+	n     int
+}
+
+func (f Format) writer() valueWriter {
+	if f == CBOR {
+		return &cborWriter{}
+	}
+	return &msgpackWriter{}
+}
+
+func (f Format) reader(in byteReader) valueReader {
+	if f == CBOR {
+		return &cborReader{in}
+	}
+	return &msgpackReader{in}
+}
+
+func writeValue(w valueWriter, value any) error {
+	switch v := value.(type) {
+	case nil:
+		w.null()
+	case bool:
+		w.boolean(v)
+	case int:
+		w.integer(int64(v))
+	case int64:
+		w.integer(v)
+	case float64:
+		w.float(v)
+	case string:
+		w.text(v)
+	case json.Number:
+		if IsInteger(v) {
+			i, ok := new(big.Int).SetString(string(v), 10)
+			if !ok {
+				return fmt.Errorf("invalid number %s", v)
+			}
+			return writeInt(w, i)
+		}
+		f, err := v.Float64()
+		if err != nil {
+			return err
+		}
+		w.float(f)
+	case *big.Int:
+		if v == nil {
+			w.null()
+			return nil
+		}
+		return writeInt(w, v)
+	case *big.Float:
+		if v == nil {
+			w.null()
+			return nil
+		}
+		f, _ := v.Float64()
+		w.float(f)
+	case *Roze:
+		if v == nil {
+			w.null()
+			return nil
+		}
+		return writeRoze(w, v)
+	default:
+		return fmt.Errorf("cannot encode %T", value)
+	}
+	return nil
+}
+
+func writeInt(w valueWriter, i *big.Int) error {
+	switch {
+	case i.IsInt64():
+		w.integer(i.Int64())
+	case i.IsUint64():
+		w.unsigned(i.Uint64())
+	default:
+		return w.bigInt(i)
+	}
+	return nil
+}
+
+func writeRoze(w valueWriter, r *Roze) error {
+	if r.IsArray() {
+		w.array(len(r.data))
+		for _, value := range r.data {
+			err := writeValue(w, value)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	names, named := r.nameAt()
+	w.object(len(r.data))
+	for i, value := range r.data {
+		if named[i] {
+			w.text(names[i])
+		} else {
+			w.integer(int64(i))
+		}
+		err := writeValue(w, value)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func readValue(rd valueReader, layouts *Layouts, depth int) (any, error) {
+	if depth > maxDepth {
+		return nil, errors.New("values nested too deeply")
+	}
+	it, err := rd.next()
+	if err != nil {
+		return nil, err
+	}
+
+	switch it.kind {
+	case itemArray:
+		r := New()
+		for i := 0; it.n < 0 || i < it.n; i++ {
+			if done, err := ended(rd, it.n); done || err != nil {
+				return r, err
+			}
+			value, err := readNested(rd, layouts, depth)
+			if err != nil {
+				return nil, err
+			}
+			r.Append(value)
+		}
+		return r, nil
+	case itemMap:
+		r := New()
+		b := newBuilder(r, layouts)
+		for i := 0; it.n < 0 || i < it.n; i++ {
+			if done, err := ended(rd, it.n); done || err != nil {
+				return r, err
+			}
+			key, err := readNested(rd, layouts, depth)
+			if err != nil {
+				return nil, err
+			}
+			value, err := readNested(rd, layouts, depth)
+			if err != nil {
+				return nil, err
+			}
+			switch key := key.(type) {
+			case string:
+				b.add(key, value)
+			case int64:
+				b.append(value)
+			default:
+				return nil, fmt.Errorf("map keys must be strings or integers, got %T", key)
+			}
+		}
+		return r, nil
+	default:
+		return it.value, nil
+	}
+}
+
+// readNested reads a value inside an array or map, which must be there.
+func readNested(rd valueReader, layouts *Layouts, depth int) (any, error) {
+	value, err := readValue(rd, layouts, depth+1)
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return value, err
+}
+
+// ended reports whether an array or map of n items has ended early, because
+// n is not known and the end has been read.
+func ended(rd valueReader, n int) (bool, error) {
+	if n >= 0 {
+		return false, nil
+	}
+	done, err := rd.end()
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return done, err
+}
+
+// readFull reads n bytes. It reads large amounts as they arrive, rather than
+// trusting n to allocate them all at once.
+func readFull(in io.Reader, n uint64) ([]byte, error) {
+	if n <= 64*1024 {
+		b := make([]byte, n)
+		_, err := io.ReadFull(in, b)
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return b, err
+	}
+	var b bytes.Buffer
+	copied, err := io.CopyN(&b, in, int64(min(n, 1<<62)))
+	if uint64(copied) < n && (err == nil || err == io.EOF) {
+		err = io.ErrUnexpectedEOF
+	}
+	return b.Bytes(), err
+}
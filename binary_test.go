@@ -0,0 +1,259 @@
+package rozer
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"testing"
+)
+
+var formats = []Format{MessagePack, CBOR}
+
+func TestBinaryRoundTrip(t *testing.T) {
+	huge, _ := new(big.Int).SetString("123456789012345678901234567890", 10)
+	mixed := New().Append(1).Put("a", "x").Append(New())
+	first, _ := Unmarshal([]byte(`{"z": 1, "a": [1.0, -2, 3.5, "x", null, true, false], "m": {"b": {}, "c": []}}`), NumbersInt64)
+	large, _ := Unmarshal([]byte(`[18446744073709551615, -9223372036854775808, 1e300, ""]`), NumbersBig)
+	for _, f := range formats {
+		for _, r := range []*Roze{
+			first,
+			large,
+			NewObject().Add("a", int64(1)).Add("a", int64(2)),
+			mixed,
+			New().Append(int64(-1)).Append(int64(-33)).Append(int64(-40000)).Append(int64(1 << 40)).Append(float64(2)),
+		} {
+			data, err := f.Marshal(r)
+			if err != nil {
+				t.Fatalf("%s: %s: %v", f, marshal(t, r), err)
+			}
+			got, err := f.Unmarshal(data)
+			if err != nil {
+				t.Fatalf("%s: %s: %v", f, marshal(t, r), err)
+			}
+			if marshal(t, got) != marshal(t, r) || got.IsArray() != r.IsArray() {
+				t.Errorf("%s: got %s, want %s", f, marshal(t, got), marshal(t, r))
+			}
+			if _, ok := got.Get("z").(int64); r.Has("z") && !ok {
+				t.Errorf("%s: z is %T, want int64", f, got.Get("z"))
+			}
+			if a, ok := got.GetRoze("a"); ok && r.Has("z") {
+				if _, ok := a.At(0).(float64); !ok {
+					t.Errorf("%s: a[0] is %T, want float64", f, a.At(0))
+				}
+			}
+			if r == mixed {
+				if name, ok := got.NameAt(0); ok {
+					t.Errorf("%s: position 0 of mixed is named %q", f, name)
+				}
+			}
+		}
+
+		r := New().Append(huge).Append(new(big.Int).Neg(huge))
+		data, err := f.Marshal(r)
+		if f == MessagePack {
+			if err == nil {
+				t.Errorf("%s: encoded %s", f, huge)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := f.Unmarshal(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !got.Equal(r) {
+			t.Errorf("%s: got %s, want %s", f, marshal(t, got), marshal(t, r))
+		}
+	}
+}
+
+func TestBinaryEncoding(t *testing.T) {
+	r := NewObject().Put("a", int64(1)).Put("b", New().Append(-1).Append(1.5).Append(nil))
+	for _, tt := range []struct {
+		f    Format
+		want string
+	}{
+		{MessagePack, "82a16101a16293ffcb3ff8000000000000c0"},
+		{CBOR, "a261610161628320fb3ff8000000000000f6"},
+	} {
+		data, err := tt.f.Marshal(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := hex.EncodeToString(data); got != tt.want {
+			t.Errorf("%s: got %s, want %s", tt.f, got, tt.want)
+		}
+	}
+}
+
+func TestCBORDecoding(t *testing.T) {
+	for _, tt := range []struct {
+		in, want string
+	}{
+		// indefinite lengths, a chunked string and a half float.
+		{"bf61619f0102ff6162f9c400ff", `{"a":[1,2],"b":-4}`},
+		{"9f7f626162626364ffff", `["abcd"]`},
+		// a date, whose tag is ignored, and a negative bignum.
+		{"82c074323031332d30332d32315432303a30343a30305ac349010000000000000000", `["2013-03-21T20:04:00Z",-18446744073709551617]`},
+	} {
+		data, _ := hex.DecodeString(tt.in)
+		r, err := CBOR.Unmarshal(data)
+		if err != nil {
+			t.Errorf("%s: %v", tt.in, err)
+			continue
+		}
+		if got := marshal(t, r); got != tt.want {
+			t.Errorf("%s: got %s, want %s", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestBinaryStream(t *testing.T) {
+	for _, f := range formats {
+		var buf bytes.Buffer
+		enc := f.NewEncoder(&buf)
+		for i := range 3 {
+			err := enc.Encode(NewObject().Put("n", int64(i)))
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		dec := f.NewDecoder(&buf)
+		var got []string
+		for {
+			r, err := dec.Decode()
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			got = append(got, marshal(t, r))
+		}
+		if fmt.Sprint(got) != `[{"n":0} {"n":1} {"n":2}]` {
+			t.Errorf("%s: got %v", f, got)
+		}
+	}
+}
+
+func TestBinaryErrors(t *testing.T) {
+	for _, tt := range []struct {
+		f  Format
+		in string
+	}{
+		{MessagePack, "01"},       // not a map or array
+		{MessagePack, "92c0"},     // too short
+		{MessagePack, "81c0c0"},   // null key
+		{MessagePack, "90c0"},     // trailing data
+		{MessagePack, "d4000000"}, // extension
+		{CBOR, "9f01"},            // no break
+		{CBOR, "81ff"},            // unexpected break
+		{CBOR, "c2"},              // tag of nothing
+		{CBOR, "5b00000000ffffffff"},
+	} {
+		data, _ := hex.DecodeString(tt.in)
+		if r, err := tt.f.Unmarshal(data); err == nil {
+			t.Errorf("%s %s: got %s", tt.f, tt.in, marshal(t, r))
+		}
+	}
+
+	if _, err := CBOR.Marshal(New().Append(struct{}{})); err == nil {
+		t.Errorf("encoded a struct")
+	}
+}
+
+func FuzzBinary(f *testing.F) {
+	for _, in := range []string{"82a16101a16293ffcb3ff8000000000000c0", "a261610161628320fb3ff8000000000000f6", "bf61619f0102ff6162f9c400ff"} {
+		data, _ := hex.DecodeString(in)
+		f.Add(data)
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		for _, format := range formats {
+			r, err := format.Unmarshal(data)
+			if err != nil {
+				continue
+			}
+			again, err := format.Marshal(r)
+			if err != nil {
+				t.Fatalf("%s: %v", format, err)
+			}
+			r2, err := format.Unmarshal(again)
+			if err != nil {
+				t.Fatalf("%s: reading %x: %v", format, again, err)
+			}
+			// compared as encoded, since NaN is not equal to itself.
+			if third, err := format.Marshal(r2); err != nil || !bytes.Equal(third, again) {
+				t.Fatalf("%s: %x became %x, %v", format, again, third, err)
+			}
+		}
+	})
+}
+
+// benchmarkRows returns rows of the kind a pipeline caches.
+func benchmarkRows(b *testing.B) []*Roze {
+	var rows []*Roze
+	for i := range 1000 {
+		r, err := Unmarshal([]byte(fmt.Sprintf(
+			`{"id": %d, "name": "name %d", "price": %d.25, "tags": ["a", "b"], "address": {"city": "c", "zip": "%05d"}}`,
+			i, i, i, i)), NumbersInt64)
+		if err != nil {
+			b.Fatal(err)
+		}
+		rows = append(rows, r)
+	}
+	return rows
+}
+
+func BenchmarkDecode(b *testing.B) {
+	rows := benchmarkRows(b)
+
+	var jsonData bytes.Buffer
+	for _, r := range rows {
+		line, _ := json.Marshal(r)
+		jsonData.Write(line)
+		jsonData.WriteByte('\n')
+	}
+	b.Run("json", func(b *testing.B) {
+		b.SetBytes(int64(jsonData.Len()))
+		for range b.N {
+			for _, line := range bytes.SplitAfter(jsonData.Bytes(), []byte("\n")) {
+				if len(line) == 0 {
+					continue
+				}
+				if _, err := Unmarshal(line, NumbersInt64); err != nil {
+					b.Fatal(err)
+				}
+			}
+		}
+	})
+
+	for _, f := range formats {
+		var data bytes.Buffer
+		enc := f.NewEncoder(&data)
+		for _, r := range rows {
+			enc.Encode(r)
+		}
+		b.Run(f.String(), func(b *testing.B) {
+			b.SetBytes(int64(data.Len()))
+			for range b.N {
+				dec := f.NewDecoder(bytes.NewReader(data.Bytes()))
+				for {
+					_, err := dec.Decode()
+					if err == io.EOF {
+						break
+					}
+					if err != nil {
+						b.Fatal(err)
+					}
+				}
+			}
+		})
+	}
+}
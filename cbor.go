@@ -0,0 +1,319 @@
+package rozer
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"math/big"
+	"strings"
+)
+
+// The major types of CBOR.
+const (
+	cborUnsigned = 0 << 5
+	cborNegative = 1 << 5
+	cborBytes    = 2 << 5
+	cborText     = 3 << 5
+	cborArray    = 4 << 5
+	cborMap      = 5 << 5
+	cborTag      = 6 << 5
+	cborSimple   = 7 << 5
+)
+
+// cborIndefinite is the additional information of a string, array or map
+// whose length is not known until the break that ends it.
+const (
+	cborIndefinite = 31
+	cborBreak      = cborSimple | cborIndefinite
+)
+
+// The tags of bignums.
+const (
+	cborPositiveBignum = 2
+	cborNegativeBignum = 3
+)
+
+type cborWriter struct {
+	buf []byte
+}
+
+// head writes the start of an item: its major type and a number, which is
+// the value of an integer or the length of a string, array or map.
+func (w *cborWriter) head(major byte, n uint64) {
+	switch {
+	case n < 24:
+		w.buf = append(w.buf, major|byte(n))
+	case n <= math.MaxUint8:
+		w.buf = append(w.buf, major|24, byte(n))
+	case n <= math.MaxUint16:
+		w.buf = binary.BigEndian.AppendUint16(append(w.buf, major|25), uint16(n))
+	case n <= math.MaxUint32:
+		w.buf = binary.BigEndian.AppendUint32(append(w.buf, major|26), uint32(n))
+	default:
+		w.buf = binary.BigEndian.AppendUint64(append(w.buf, major|27), n)
+	}
+}
+
+func (w *cborWriter) null() {
+	w.buf = append(w.buf, cborSimple|22)
+}
+
+func (w *cborWriter) boolean(b bool) {
+	if b {
+		w.buf = append(w.buf, cborSimple|21)
+	} else {
+		w.buf = append(w.buf, cborSimple|20)
+	}
+}
+
+func (w *cborWriter) integer(i int64) {
+	if i < 0 {
+		w.head(cborNegative, uint64(-1-i))
+		return
+	}
+	w.head(cborUnsigned, uint64(i))
+}
+
+func (w *cborWriter) unsigned(u uint64) {
+	w.head(cborUnsigned, u)
+}
+
+// float always writes a float64, so that a float with no fraction is read
+// back as a float.
+func (w *cborWriter) float(f float64) {
+	w.buf = binary.BigEndian.AppendUint64(append(w.buf, cborSimple|27), math.Float64bits(f))
+}
+
+func (w *cborWriter) text(s string) {
+	w.head(cborText, uint64(len(s)))
+	w.buf = append(w.buf, s...)
+}
+
+func (w *cborWriter) array(n int) {
+	w.head(cborArray, uint64(n))
+}
+
+func (w *cborWriter) object(n int) {
+	w.head(cborMap, uint64(n))
+}
+
+func (w *cborWriter) bigInt(i *big.Int) error {
+	if i.Sign() < 0 {
+		// a negative bignum holds -1-i.
+		w.head(cborTag, cborNegativeBignum)
+		i = new(big.Int).Sub(new(big.Int).Neg(i), big.NewInt(1))
+	} else {
+		w.head(cborTag, cborPositiveBignum)
+	}
+	b := i.Bytes()
+	w.head(cborBytes, uint64(len(b)))
+	w.buf = append(w.buf, b...)
+	return nil
+}
+
+func (w *cborWriter) bytes() []byte {
+	return w.buf
+}
+
+type cborReader struct {
+	in byteReader
+}
+
+// head reads the start of an item: its major type, its additional
+// information, and the number that follows, if there is one.
+func (rd *cborReader) head() (major byte, info byte, n uint64, err error) {
+	b, err := rd.in.ReadByte()
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	major, info = b&0xe0, b&0x1f
+
+	switch {
+	case info < 24:
+		return major, info, uint64(info), nil
+	case info <= 27:
+		n, err = readUint(rd.in, 1<<(info-24))
+		return major, info, n, err
+	case info == cborIndefinite:
+		return major, info, 0, nil
+	default:
+		return 0, 0, 0, fmt.Errorf("cbor: invalid additional information %d", info)
+	}
+}
+
+func (rd *cborReader) next() (item, error) {
+	major, info, n, err := rd.head()
+	if err != nil {
+		return item{}, err
+	}
+
+	// bignums are read as *big.Int; other tags are ignored, and the item
+	// read as if it had none.
+	tagged, tag := false, uint64(0)
+	for major == cborTag {
+		if info == cborIndefinite {
+			return item{}, fmt.Errorf("cbor: tag of indefinite length")
+		}
+		tagged, tag = true, n
+		major, info, n, err = rd.head()
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			return item{}, err
+		}
+	}
+
+	it, err := rd.item(major, info, n)
+	if err != nil || !tagged || (tag != cborPositiveBignum && tag != cborNegativeBignum) {
+		return it, err
+	}
+	return bignum(tag, it)
+}
+
+// item reads an item other than a tag, after its head.
+func (rd *cborReader) item(major, info byte, n uint64) (item, error) {
+	indefinite := info == cborIndefinite
+
+	switch major {
+	case cborUnsigned, cborNegative:
+		if indefinite {
+			return item{}, fmt.Errorf("cbor: integer of indefinite length")
+		}
+		return item{value: cborInt(major == cborNegative, n)}, nil
+	case cborBytes, cborText:
+		s, err := rd.text(major, indefinite, n)
+		return item{value: s}, err
+	case cborArray, cborMap:
+		kind := itemArray
+		if major == cborMap {
+			kind = itemMap
+		}
+		if indefinite {
+			return item{kind: kind, n: -1}, nil
+		}
+		if n > math.MaxInt {
+			return item{}, fmt.Errorf("cbor: length %d is too long", n)
+		}
+		return item{kind: kind, n: int(n)}, nil
+	default:
+		return rd.simple(info, n)
+	}
+}
+
+// cborInt returns the integer n, or -1-n if negative, as an int64 if it fits
+// and otherwise a *big.Int.
+func cborInt(negative bool, n uint64) any {
+	if n <= math.MaxInt64 {
+		if negative {
+			return -1 - int64(n)
+		}
+		return int64(n)
+	}
+	i := new(big.Int).SetUint64(n)
+	if negative {
+		i.Neg(i).Sub(i, big.NewInt(1))
+	}
+	return i
+}
+
+// text reads a string, or binary data, which is read as a string too. A
+// string of indefinite length is a series of strings ended by a break.
+func (rd *cborReader) text(major byte, indefinite bool, n uint64) (string, error) {
+	if !indefinite {
+		b, err := readFull(rd.in, n)
+		return string(b), err
+	}
+
+	var s strings.Builder
+	for {
+		chunkMajor, info, n, err := rd.head()
+		if err != nil {
+			return "", err
+		}
+		if chunkMajor|info == cborBreak {
+			return s.String(), nil
+		}
+		if chunkMajor != major || info == cborIndefinite {
+			return "", fmt.Errorf("cbor: invalid chunk in a string of indefinite length")
+		}
+		b, err := readFull(rd.in, n)
+		if err != nil {
+			return "", err
+		}
+		s.Write(b)
+	}
+}
+
+// bignum converts the bytes of a bignum with the tag to an integer.
+func bignum(tag uint64, it item) (item, error) {
+	b, ok := it.value.(string)
+	if it.kind != itemValue || !ok {
+		return item{}, fmt.Errorf("cbor: bignum must hold bytes")
+	}
+	i := new(big.Int).SetBytes([]byte(b))
+	if tag == cborNegativeBignum {
+		i.Neg(i).Sub(i, big.NewInt(1))
+	}
+	if i.IsInt64() {
+		return item{value: i.Int64()}, nil
+	}
+	return item{value: i}, nil
+}
+
+// simple reads false, true, null, undefined, which is read as null, and
+// floats.
+func (rd *cborReader) simple(info byte, n uint64) (item, error) {
+	switch info {
+	case 20:
+		return item{value: false}, nil
+	case 21:
+		return item{value: true}, nil
+	case 22, 23:
+		return item{value: nil}, nil
+	case 25:
+		return item{value: float16(uint16(n))}, nil
+	case 26:
+		return item{value: float64(math.Float32frombits(uint32(n)))}, nil
+	case 27:
+		return item{value: math.Float64frombits(n)}, nil
+	case cborIndefinite:
+		return item{}, fmt.Errorf("cbor: unexpected break")
+	default:
+		return item{}, fmt.Errorf("cbor: unsupported simple value %d", n)
+	}
+}
+
+func (rd *cborReader) end() (bool, error) {
+	b, err := rd.in.ReadByte()
+	if err != nil {
+		return false, err
+	}
+	if b == cborBreak {
+		return true, nil
+	}
+	return false, rd.in.UnreadByte()
+}
+
+// float16 converts an IEEE 754 half precision float.
+func float16(h uint16) float64 {
+	exp, frac := int(h>>10)&0x1f, float64(h&0x3ff)
+	var f float64
+	switch exp {
+	case 0:
+		f = math.Ldexp(frac, -24)
+	case 0x1f:
+		if frac == 0 {
+			f = math.Inf(1)
+		} else {
+			f = math.NaN()
+		}
+	default:
+		f = math.Ldexp(frac+0x400, exp-25)
+	}
+	if h&0x8000 != 0 {
+		f = -f
+	}
+	return f
+}
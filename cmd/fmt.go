@@ -0,0 +1,58 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/pdk/rozer/lang"
+)
+
+type FmtCmd struct {
+	Check bool     `help:"List files whose formatting differs, and fail if there are any."`
+	Write bool     `short:"w" help:"Write the result to the file instead of stdout."`
+	Files []string `arg:"" type:"existingfile" help:"Files to format."`
+}
+
+func (fc *FmtCmd) Run() error {
+	unformatted := 0
+	for _, file := range fc.Files {
+		src, err := os.ReadFile(file)
+		if err != nil {
+			return err
+		}
+
+		formatted, err := lang.Format(file, src)
+		var diagnostics lang.Diagnostics
+		if errors.As(err, &diagnostics) {
+			fmt.Fprint(os.Stderr, diagnostics.Render(src))
+			return fmt.Errorf("%s: %d syntax error(s)", file, len(diagnostics))
+		}
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case fc.Check:
+			if !bytes.Equal(src, formatted) {
+				fmt.Println(file)
+				unformatted++
+			}
+		case fc.Write:
+			if !bytes.Equal(src, formatted) {
+				err = os.WriteFile(file, formatted, 0644)
+				if err != nil {
+					return err
+				}
+			}
+		default:
+			os.Stdout.Write(formatted)
+		}
+	}
+
+	if unformatted > 0 {
+		return fmt.Errorf("%d file(s) not formatted", unformatted)
+	}
+	return nil
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFmtCheck(t *testing.T) {
+	dir := t.TempDir()
+	formatted := filepath.Join(dir, "formatted.roz")
+	unformatted := filepath.Join(dir, "unformatted.roz")
+	if err := os.WriteFile(formatted, []byte("x := 1\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(unformatted, []byte("x:=1\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := (&FmtCmd{Check: true, Files: []string{formatted}}).Run(); err != nil {
+		t.Errorf("formatted file: %v", err)
+	}
+	if err := (&FmtCmd{Check: true, Files: []string{formatted, unformatted}}).Run(); err == nil || err.Error() != "1 file(s) not formatted" {
+		t.Errorf("unformatted file: got %v", err)
+	}
+
+	// -w fixes the file, so that it then passes the check.
+	if err := (&FmtCmd{Write: true, Files: []string{unformatted}}).Run(); err != nil {
+		t.Fatal(err)
+	}
+	if err := (&FmtCmd{Check: true, Files: []string{unformatted}}).Run(); err != nil {
+		t.Errorf("rewritten file: %v", err)
+	}
+}
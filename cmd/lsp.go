@@ -0,0 +1,13 @@
+package main
+
+import (
+	"os"
+
+	"github.com/pdk/rozer/lsp"
+)
+
+type LspCmd struct{}
+
+func (lc *LspCmd) Run() error {
+	return lsp.NewServer(os.Stdin, os.Stdout).Serve()
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/alecthomas/participle/v2/lexer"
+	"github.com/pdk/rozer/lang"
+)
+
+type ReplCmd struct {
+	Verbose bool `short:"v" help:"Show execution logging."`
+}
+
+const replHelp = `enter expressions or functions to evaluate them. meta-commands:
+  :type expr    show the inferred type of expr
+  :ast expr     show the parsed form of expr
+  :dump [expr]  dump the compiled form of expr, or of the session so far
+  :help         show this help
+  :quit         leave the session`
+
+// replSession keeps the environment and types of everything entered so far.
+type replSession struct {
+	env     *lang.ExecutionEnvironment
+	typeMap lang.TypeMap
+	history lang.ProgramExecute
+	out     io.Writer
+}
+
+func (rc *ReplCmd) Run() error {
+	if !rc.Verbose {
+		log.SetOutput(io.Discard)
+	}
+
+	session := &replSession{
+		env:     lang.NewProgramEnvironment(context.Background(), lang.Limits{}),
+		typeMap: lang.TypeMap{},
+		out:     os.Stdout,
+	}
+
+	scanner := bufio.NewScanner(os.Stdin)
+	input := ""
+	prompt := "> "
+	for {
+		fmt.Fprint(session.out, prompt)
+		if !scanner.Scan() {
+			fmt.Fprintln(session.out)
+			return scanner.Err()
+		}
+
+		input += scanner.Text() + "\n"
+		if strings.HasPrefix(input, ":") {
+			if !session.meta(strings.TrimSpace(input)) {
+				return nil
+			}
+			input = ""
+			continue
+		}
+
+		if needsMore(input) {
+			prompt = "... "
+			continue
+		}
+
+		session.eval(input)
+		input = ""
+		prompt = "> "
+	}
+}
+
+// eval parses, compiles and executes one input in the session's environment.
+func (s *replSession) eval(input string) {
+	if strings.TrimSpace(input) == "" {
+		return
+	}
+
+	executable, ok := s.compile(input, s.typeMap)
+	if !ok {
+		return
+	}
+
+	result, err := executable.ExecuteIn(s.env)
+	if err != nil {
+		fmt.Fprintf(s.out, "error: %s\n", err)
+		return
+	}
+
+	s.history.NamedFunctions = append(s.history.NamedFunctions, executable.NamedFunctions...)
+	s.history.Commands = append(s.history.Commands, executable.Commands...)
+
+	if len(executable.Commands) > 0 {
+		fmt.Fprintln(s.out, lang.FormatValue(result))
+	}
+}
+
+func (s *replSession) compile(input string, typeMap lang.TypeMap) (lang.ProgramExecute, bool) {
+	program, diagnostics := lang.ParseAll("repl", []byte(input))
+	if len(diagnostics) > 0 {
+		fmt.Fprint(s.out, diagnostics.Render([]byte(input)))
+		return lang.ProgramExecute{}, false
+	}
+
+	executable, errors := program.Compile(typeMap)
+	if errors.Len() > 0 {
+		fmt.Fprint(s.out, lang.CompileDiagnostics(errors).Render([]byte(input)))
+		return lang.ProgramExecute{}, false
+	}
+
+	return executable, true
+}
+
+// meta runs a meta-command. It returns false when the session should end.
+func (s *replSession) meta(line string) bool {
+	command, expr, _ := strings.Cut(line, " ")
+	expr = strings.TrimSpace(expr) + "\n"
+
+	switch command {
+	case ":quit", ":q":
+		return false
+	case ":help":
+		fmt.Fprintln(s.out, replHelp)
+	case ":type":
+		// compile against a copy, so that assignments in expr are not
+		// remembered by the session.
+		typeMap := lang.TypeMap{}
+		for k, v := range s.typeMap {
+			typeMap[k] = v
+		}
+		executable, ok := s.compile(expr, typeMap)
+		if ok && len(executable.Commands) > 0 {
+			last := executable.Commands[len(executable.Commands)-1]
+			fmt.Fprintln(s.out, last.Type(typeMap))
+		}
+	case ":ast":
+		program, err := lang.ParseString("repl", expr)
+		if err != nil {
+			fmt.Fprintf(s.out, "error: %s\n", err)
+			break
+		}
+		fmt.Fprint(s.out, program.String())
+	case ":dump":
+		if strings.TrimSpace(expr) == "" {
+			s.history.DumpFunctions(s.out)
+			s.history.DumpProgram(s.out)
+			break
+		}
+		executable, ok := s.compile(expr, lang.TypeMap{})
+		if ok {
+			executable.DumpFunctions(s.out)
+			executable.DumpProgram(s.out)
+		}
+	default:
+		fmt.Fprintf(s.out, "unknown command %s (try :help)\n", command)
+	}
+
+	return true
+}
+
+var continuationTokens = map[string]bool{
+	"MoreMore":     true,
+	"MoreMoreMore": true,
+	"AndAnd":       true,
+	"OrOr":         true,
+	"ColonEqual":   true,
+	"PlusEqual":    true,
+	"Plus":         true,
+	"Minus":        true,
+	"Star":         true,
+	"Slash":        true,
+	"Percent":      true,
+	"EqualEqual":   true,
+	"BangEqual":    true,
+	"LessEqual":    true,
+	"MoreEqual":    true,
+	"Less":         true,
+	"More":         true,
+	"Colon":        true,
+}
+
+// needsMore reports whether input is incomplete, either because a bracket is
+// still open or because the last token is an operator, which the grammar
+// allows to be continued on the next line.
+func needsMore(input string) bool {
+	lex, err := lang.PipelineLexer.Lex("repl", strings.NewReader(input))
+	if err != nil {
+		return false
+	}
+
+	names := map[lexer.TokenType]string{}
+	for name, tokenType := range lang.PipelineLexer.Symbols() {
+		names[tokenType] = name
+	}
+
+	depth := 0
+	last := ""
+	for {
+		token, err := lex.Next()
+		if err != nil {
+			// let the parser report it.
+			return false
+		}
+		if token.EOF() {
+			break
+		}
+
+		name := names[token.Type]
+		switch name {
+		case "EOL", "Comment", "Scriptor":
+			continue
+		case "Punct":
+			switch token.Value {
+			case "(", "[", "{":
+				depth++
+			case ")", "]", "}":
+				depth--
+			}
+		}
+		last = name
+	}
+
+	return depth > 0 || continuationTokens[last]
+}
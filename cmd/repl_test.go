@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/pdk/rozer/lang"
+)
+
+func TestNeedsMore(t *testing.T) {
+	for _, tt := range []struct {
+		input string
+		want  bool
+	}{
+		{"x := 1\n", false},
+		{"", false},
+		{"fn f(a) {\n", true},
+		{"fn f(a) {\n    a + 1\n", true},
+		{"fn f(a) {\n    a + 1\n}\n", false},
+		{"[1, 2,\n", true},
+		{"[1, 2,\n3]\n", false},
+		{"f(1,\n", true},
+		{"[[1], [2\n", true},
+		{"x := [1, 2] >>\n", true},
+		{"x := [1, 2] >> f\n", false},
+		{"x := [1, 2] >>> \n", true},
+		{"x := [1, 2] >> // more to come\n", true},
+		{"x := 1 +\n", true},
+		{"a &&\n", true},
+		{"x :=\n", true},
+		// a closing bracket too many, or a bad token, is left to the parser.
+		{")\n", false},
+		{"x := \"open\n", false},
+	} {
+		if got := needsMore(tt.input); got != tt.want {
+			t.Errorf("needsMore(%q) = %v, want %v", tt.input, got, tt.want)
+		}
+	}
+}
+
+func newTestSession(out *strings.Builder) *replSession {
+	return &replSession{
+		env:     lang.NewProgramEnvironment(context.Background(), lang.Limits{}),
+		typeMap: lang.TypeMap{},
+		out:     out,
+	}
+}
+
+func TestMetaCommands(t *testing.T) {
+	var out strings.Builder
+	s := newTestSession(&out)
+	s.eval("x := 1\n")
+	s.eval("fn f(a) {\n    a + x\n}\n")
+	out.Reset()
+
+	for _, tt := range []struct {
+		line, want string
+	}{
+		{":type x", "integer\n"},
+		{":type [x]", "list\n"},
+		// assignments in :type are not remembered.
+		{":type y := \"a\"", "string\n"},
+		{":type y", "unknown\n"},
+		{":ast x := [1, 2] >> f", "  0: (x := ([1, 2] >> f))\n"},
+		{":ast )", "error: repl:1:1: unexpected token \")\"\n"},
+		{":bogus", "unknown command :bogus (try :help)\n"},
+	} {
+		out.Reset()
+		if !s.meta(tt.line) {
+			t.Errorf("%s ended the session", tt.line)
+		}
+		if out.String() != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.line, out.String(), tt.want)
+		}
+	}
+
+	out.Reset()
+	s.meta(":type )")
+	if !strings.Contains(out.String(), "error[E001]") {
+		t.Errorf(":type of a syntax error: got %q", out.String())
+	}
+
+	if s.meta(":quit") || s.meta(":q") {
+		t.Errorf(":quit did not end the session")
+	}
+}
+
+// dumped decodes the JSON values written by :dump.
+func dumped(t *testing.T, output string) []any {
+	t.Helper()
+	var values []any
+	decoder := json.NewDecoder(strings.NewReader(output))
+	for decoder.More() {
+		var v any
+		if err := decoder.Decode(&v); err != nil {
+			t.Fatalf("%v in\n%s", err, output)
+		}
+		values = append(values, v)
+	}
+	return values
+}
+
+func TestMetaDump(t *testing.T) {
+	var out strings.Builder
+	s := newTestSession(&out)
+
+	// an expression is dumped without running it.
+	s.meta(":dump 1 + 2")
+	values := dumped(t, out.String())
+	if len(values) != 2 {
+		t.Fatalf("got %d values, want functions and program", len(values))
+	}
+	program, _ := json.Marshal(values[1])
+	if string(program) != `["block",[["+",["integer","1"],["integer","2"]]]]` {
+		t.Errorf("got program %s", program)
+	}
+
+	// with no expression, the session so far is dumped.
+	s.eval("x := 1\n")
+	s.eval("fn f(a) {\n    a + x\n}\n")
+	s.eval("bad := \n)\n")
+	out.Reset()
+	s.meta(":dump")
+	values = dumped(t, out.String())
+	if len(values) != 2 {
+		t.Fatalf("got %d values, want functions and program", len(values))
+	}
+	functions, _ := json.Marshal(values[0])
+	if !strings.HasPrefix(string(functions), `[["named function","f",["params","a"]`) {
+		t.Errorf("got functions %s", functions)
+	}
+	program, _ = json.Marshal(values[1])
+	if string(program) != `["block",[[":=",["ident","x"],["integer","1"]]]]` {
+		t.Errorf("got program %s", program)
+	}
+}
@@ -1,51 +1,40 @@
 package main
 
 import (
+	"context"
 	"fmt"
-	"io"
 	"log"
 	"os"
 
-	"github.com/alecthomas/participle/v2"
+	"github.com/alecthomas/kong"
 	"github.com/pdk/rozer/lang"
 )
 
 var (
-	basicParser = participle.MustBuild[lang.Program](
-		participle.Lexer(lang.PipelineLexer),
-		participle.CaseInsensitive("Ident"),
-		participle.Unquote("String"),
-		participle.UseLookahead(4),
-	)
-
 	cli struct {
-		File string `arg:"" type:"existingfile" help:"File to parse."`
+		Run  RunCmd  `cmd:"" help:"Run a program."`
+		Repl ReplCmd `cmd:"" help:"Start an interactive session."`
+		Test TestCmd `cmd:"" help:"Run the tests of programs."`
+		Fmt  FmtCmd  `cmd:"" help:"Format programs."`
+		Lsp  LspCmd  `cmd:"" help:"Run a language server on stdin and stdout."`
 	}
 )
 
-func Parse(r io.Reader) (*lang.Program, error) {
-	program, err := basicParser.Parse("", r)
-	if err != nil {
-		return nil, err
-	}
-	return program, nil
+type RunCmd struct {
+	Include []string `short:"I" type:"existingdir" help:"Directories to search for imported modules."`
+	File    string   `arg:"" type:"existingfile" help:"File to run."`
 }
 
-func main() {
-	// ctx := kong.Parse(&cli)
-	// r, err := os.Open(cli.File)
-	// ctx.FatalIfErrorf(err)
-	// defer r.Close()
-
-	r, err := os.Open("/Users/pdk/src/rozer/short.roz")
+func (rc *RunCmd) Run() error {
+	src, err := os.ReadFile(rc.File)
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
-	defer r.Close()
 
-	program, err := Parse(r)
-	if err != nil {
-		log.Fatal(err)
+	program, diagnostics := lang.ParseAll(rc.File, src)
+	if len(diagnostics) > 0 {
+		fmt.Fprint(os.Stderr, diagnostics.Render(src))
+		os.Exit(1)
 	}
 
 	// repr.Println(program)
@@ -54,23 +43,28 @@ func main() {
 	globalTypeMap := lang.TypeMap{}
 
 	log.Printf("compiling...")
-	executableProgram, errors := program.Compile(globalTypeMap)
+	loader := lang.NewLoader(append(rc.Include, lang.DefaultLoader.SearchPath...)...)
+	executableProgram, errors := program.CompileWith(globalTypeMap, loader)
 
 	if errors.Len() > 0 {
-		log.Printf("errors found during compilation:")
-		for _, err := range *errors.Errs {
-			log.Printf("error: %s", err)
-		}
+		fmt.Fprint(os.Stderr, lang.CompileDiagnostics(errors).Render(src))
 		os.Exit(1)
 	}
 
 	// log.Printf("here are the functions: ")
-	// executableProgram.DumpFunctions()
+	// executableProgram.DumpFunctions(os.Stdout)
 
 	// log.Printf("here is the program: ")
-	// executableProgram.DumpProgram()
+	// executableProgram.DumpProgram(os.Stdout)
 
 	log.Printf("executing...")
 
-	executableProgram.ExecuteProgram()
+	_, err = executableProgram.ExecuteProgramContext(context.Background(), lang.Limits{})
+	return err
+}
+
+func main() {
+	ctx := kong.Parse(&cli)
+	err := ctx.Run()
+	ctx.FatalIfErrorf(err)
 }
@@ -0,0 +1,291 @@
+package main
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/alecthomas/participle/v2/lexer"
+	"github.com/pdk/rozer/lang"
+)
+
+type TestCmd struct {
+	Format   string        `enum:"text,tap,junit" default:"text" help:"Output format: text, tap or junit."`
+	Filter   string        `name:"run" help:"Only run tests whose names match this regular expression."`
+	Include  []string      `short:"I" type:"existingdir" help:"Directories to search for imported modules."`
+	Timeout  time.Duration `default:"1m" help:"Stop a test that runs longer than this, 0 for no limit."`
+	MaxSteps int64         `default:"100000000" help:"Stop a test that executes more steps than this, 0 for no limit."`
+	Verbose  bool          `short:"v" help:"Show the interpreter's log."`
+	Files    []string      `arg:"" type:"existingfile" help:"Files to test."`
+}
+
+// fileResults are the results of the tests of one file.
+type fileResults struct {
+	file    string
+	results []lang.TestResult
+}
+
+func (tc *TestCmd) Run() error {
+	return tc.run(os.Stdout)
+}
+
+func (tc *TestCmd) run(w io.Writer) error {
+	if !tc.Verbose {
+		log.SetOutput(io.Discard)
+	}
+
+	var filter *regexp.Regexp
+	if tc.Filter != "" {
+		var err error
+		filter, err = regexp.Compile(tc.Filter)
+		if err != nil {
+			return err
+		}
+	}
+
+	loader := lang.NewLoader(append(tc.Include, lang.DefaultLoader.SearchPath...)...)
+	limits := lang.Limits{MaxSteps: tc.MaxSteps, MaxWallTime: tc.Timeout}
+
+	all := []fileResults{}
+	for _, file := range tc.Files {
+		pe, err := compileFile(file, loader)
+		if err != nil {
+			// report the file as failed, and go on to test the others.
+			all = append(all, fileResults{file: file, results: []lang.TestResult{{
+				TestCase: lang.TestCase{Name: "compile", Pos: lexer.Position{Filename: file, Line: 1, Column: 1}},
+				Err:      err,
+			}}})
+			continue
+		}
+
+		fr := fileResults{file: file}
+		for _, test := range pe.TestCases() {
+			if filter != nil && !filter.MatchString(test.Name) {
+				continue
+			}
+			result := pe.RunTest(context.Background(), limits, test, fileFixtures(file))
+			fr.results = append(fr.results, result)
+		}
+		all = append(all, fr)
+	}
+
+	var err error
+	switch tc.Format {
+	case "tap":
+		writeTAP(w, all)
+	case "junit":
+		err = writeJUnit(w, all)
+	default:
+		writeText(w, all)
+	}
+	if err != nil {
+		return err
+	}
+
+	failed := 0
+	for _, fr := range all {
+		for _, r := range fr.results {
+			if r.Err != nil {
+				failed++
+			}
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d test(s) failed", failed)
+	}
+	return nil
+}
+
+// compileFile parses and compiles file, printing any diagnostics.
+func compileFile(file string, loader *lang.Loader) (lang.ProgramExecute, error) {
+	src, err := os.ReadFile(file)
+	if err != nil {
+		return lang.ProgramExecute{}, err
+	}
+
+	program, diagnostics := lang.ParseAll(file, src)
+	if len(diagnostics) == 0 {
+		pe, errs := program.CompileWith(lang.TypeMap{}, loader)
+		if errs.Len() == 0 {
+			return pe, nil
+		}
+		diagnostics = lang.CompileDiagnostics(errs)
+	}
+
+	fmt.Fprint(os.Stderr, diagnostics.Render(src))
+	return lang.ProgramExecute{}, fmt.Errorf("%s: %d error(s)", file, len(diagnostics))
+}
+
+// fileFixtures reads fixtures from the testdata directory next to file. A
+// fixture named rows is read from rows.json, holding one value, or from
+// rows.jsonl, holding a list of values one per line.
+func fileFixtures(file string) lang.Fixtures {
+	dir := filepath.Join(filepath.Dir(file), "testdata")
+
+	return func(name string) (any, error) {
+		data, err := os.ReadFile(filepath.Join(dir, name+".json"))
+		if err == nil {
+			return lang.ValueFromJSON(data)
+		}
+		if !errors.Is(err, os.ErrNotExist) {
+			return nil, err
+		}
+
+		data, err = os.ReadFile(filepath.Join(dir, name+".jsonl"))
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("no fixture_%s function or testdata/%s.json(l) file", name, name)
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		list := lang.ListResult{Items: []any{}}
+		for i, line := range strings.Split(string(data), "\n") {
+			if strings.TrimSpace(line) == "" {
+				continue
+			}
+			value, err := lang.ValueFromJSON([]byte(line))
+			if err != nil {
+				return nil, fmt.Errorf("%s.jsonl line %d: %w", name, i+1, err)
+			}
+			list.Items = append(list.Items, value)
+		}
+		return list, nil
+	}
+}
+
+// failureLines returns the message of a failed test, followed by the
+// differences found by assert_eq.
+func failureLines(err error) []string {
+	lines := []string{err.Error()}
+	var ae *lang.AssertionError
+	if errors.As(err, &ae) {
+		lines = append(lines, ae.Diff...)
+	}
+	return lines
+}
+
+func writeText(w io.Writer, all []fileResults) {
+	for _, fr := range all {
+		passed, failed := 0, 0
+		for _, r := range fr.results {
+			if r.Err == nil {
+				passed++
+				fmt.Fprintf(w, "ok   %s (%s)\n", r.Name, r.Elapsed)
+				continue
+			}
+			failed++
+			fmt.Fprintf(w, "FAIL %s (%s)\n", r.Name, r.Elapsed)
+			for _, line := range failureLines(r.Err) {
+				fmt.Fprintf(w, "    %s\n", line)
+			}
+		}
+		if len(fr.results) == 0 {
+			fmt.Fprintf(w, "%s: no tests\n", fr.file)
+			continue
+		}
+		fmt.Fprintf(w, "%s: %d passed, %d failed\n", fr.file, passed, failed)
+	}
+}
+
+// writeTAP writes the results in the Test Anything Protocol, version 13.
+func writeTAP(w io.Writer, all []fileResults) {
+	total := 0
+	for _, fr := range all {
+		total += len(fr.results)
+	}
+
+	fmt.Fprintln(w, "TAP version 13")
+	fmt.Fprintf(w, "1..%d\n", total)
+	n := 0
+	for _, fr := range all {
+		for _, r := range fr.results {
+			n++
+			if r.Err == nil {
+				fmt.Fprintf(w, "ok %d - %s: %s\n", n, fr.file, r.Name)
+				continue
+			}
+			fmt.Fprintf(w, "not ok %d - %s: %s\n", n, fr.file, r.Name)
+			fmt.Fprintln(w, "  ---")
+			fmt.Fprintln(w, "  message: |")
+			for _, line := range failureLines(r.Err) {
+				fmt.Fprintf(w, "    %s\n", line)
+			}
+			fmt.Fprintf(w, "  at: %s\n", r.Pos)
+			fmt.Fprintln(w, "  ...")
+		}
+	}
+}
+
+type junitTestSuites struct {
+	XMLName xml.Name         `xml:"testsuites"`
+	Suites  []junitTestSuite `xml:"testsuite"`
+}
+
+type junitTestSuite struct {
+	Name     string          `xml:"name,attr"`
+	Tests    int             `xml:"tests,attr"`
+	Failures int             `xml:"failures,attr"`
+	Time     string          `xml:"time,attr"`
+	Cases    []junitTestCase `xml:"testcase"`
+}
+
+type junitTestCase struct {
+	Name      string        `xml:"name,attr"`
+	ClassName string        `xml:"classname,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitFailure `xml:"failure,omitempty"`
+}
+
+type junitFailure struct {
+	Message string `xml:"message,attr"`
+	Text    string `xml:",chardata"`
+}
+
+// writeJUnit writes the results as JUnit XML, one test suite per file.
+func writeJUnit(w io.Writer, all []fileResults) error {
+	suites := junitTestSuites{}
+	for _, fr := range all {
+		suite := junitTestSuite{Name: fr.file, Tests: len(fr.results)}
+		elapsed := 0.0
+		for _, r := range fr.results {
+			tc := junitTestCase{
+				Name:      r.Name,
+				ClassName: fr.file,
+				Time:      fmt.Sprintf("%.6f", r.Elapsed.Seconds()),
+			}
+			if r.Err != nil {
+				suite.Failures++
+				tc.Failure = &junitFailure{
+					Message: r.Err.Error(),
+					Text:    strings.Join(failureLines(r.Err), "\n"),
+				}
+			}
+			elapsed += r.Elapsed.Seconds()
+			suite.Cases = append(suite.Cases, tc)
+		}
+		suite.Time = fmt.Sprintf("%.6f", elapsed)
+		suites.Suites = append(suites.Suites, suite)
+	}
+
+	_, err := io.WriteString(w, xml.Header)
+	if err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	err = enc.Encode(suites)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(w)
+	return err
+}
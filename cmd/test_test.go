@@ -0,0 +1,183 @@
+package main
+
+import (
+	"encoding/xml"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alecthomas/participle/v2/lexer"
+	"github.com/pdk/rozer/lang"
+)
+
+func sampleResults() []fileResults {
+	pos := lexer.Position{Filename: "a.roz", Line: 3, Column: 1}
+	return []fileResults{
+		{file: "a.roz", results: []lang.TestResult{
+			{TestCase: lang.TestCase{Name: "test_ok", Pos: pos}, Elapsed: 1500 * time.Microsecond},
+			{
+				TestCase: lang.TestCase{Name: "test_bad", Pos: pos},
+				Err: &lang.AssertionError{
+					Pos:      pos,
+					Expected: lang.IntegerValue(1),
+					Actual:   lang.IntegerValue(2),
+					Diff:     []string{"- 1", "+ 2"},
+				},
+				Elapsed: 2 * time.Millisecond,
+			},
+		}},
+		{file: "b.roz"},
+		{file: "c.roz", results: []lang.TestResult{
+			{TestCase: lang.TestCase{Name: "<&>", Pos: pos}, Err: errors.New("x < y & z")},
+		}},
+	}
+}
+
+func TestWriteTAP(t *testing.T) {
+	var out strings.Builder
+	writeTAP(&out, sampleResults())
+
+	want := `TAP version 13
+1..3
+ok 1 - a.roz: test_ok
+not ok 2 - a.roz: test_bad
+  ---
+  message: |
+    assertion failed: expected 1, got 2 at a.roz:3:1
+    - 1
+    + 2
+  at: a.roz:3:1
+  ...
+not ok 3 - c.roz: <&>
+  ---
+  message: |
+    x < y & z
+  at: a.roz:3:1
+  ...
+`
+	if out.String() != want {
+		t.Errorf("got\n%s\nwant\n%s", out.String(), want)
+	}
+}
+
+func TestWriteJUnit(t *testing.T) {
+	var out strings.Builder
+	if err := writeJUnit(&out, sampleResults()); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(out.String(), xml.Header) {
+		t.Errorf("no XML header in\n%s", out.String())
+	}
+
+	// the output must read back as the same results.
+	var suites junitTestSuites
+	if err := xml.Unmarshal([]byte(out.String()), &suites); err != nil {
+		t.Fatalf("%v in\n%s", err, out.String())
+	}
+	if len(suites.Suites) != 3 {
+		t.Fatalf("got %d suites, want 3", len(suites.Suites))
+	}
+	a := suites.Suites[0]
+	if a.Name != "a.roz" || a.Tests != 2 || a.Failures != 1 || a.Time != "0.003500" {
+		t.Errorf("got suite %+v", a)
+	}
+	if a.Cases[0].Failure != nil || a.Cases[0].Time != "0.001500" || a.Cases[0].ClassName != "a.roz" {
+		t.Errorf("got passing case %+v", a.Cases[0])
+	}
+	failure := a.Cases[1].Failure
+	if failure == nil || failure.Message != "assertion failed: expected 1, got 2 at a.roz:3:1" || failure.Text != failure.Message+"\n- 1\n+ 2" {
+		t.Errorf("got failure %+v", failure)
+	}
+	if b := suites.Suites[1]; b.Tests != 0 || len(b.Cases) != 0 {
+		t.Errorf("got empty suite %+v", b)
+	}
+	if c := suites.Suites[2].Cases[0]; c.Name != "<&>" || c.Failure == nil || c.Failure.Message != "x < y & z" {
+		t.Errorf("got escaped case %+v", c)
+	}
+}
+
+func TestFileFixtures(t *testing.T) {
+	dir := t.TempDir()
+	testdata := filepath.Join(dir, "testdata")
+	if err := os.Mkdir(testdata, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range map[string]string{
+		"one.json":   `{"id": 1}`,
+		"rows.jsonl": "{\"id\": 1}\n\n[2, 3]\n",
+		"bad.jsonl":  "1\n{\n",
+		"both.json":  `"json"`,
+		"both.jsonl": `"jsonl"`,
+	} {
+		if err := os.WriteFile(filepath.Join(testdata, name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	fixtures := fileFixtures(filepath.Join(dir, "x.roz"))
+
+	for _, tt := range []struct {
+		name, want, err string
+	}{
+		{name: "one", want: `["id": 1]`},
+		{name: "rows", want: `[["id": 1], [2, 3]]`},
+		{name: "both", want: `"json"`},
+		{name: "bad", err: "bad.jsonl line 2"},
+		{name: "missing", err: "no fixture_missing function or testdata/missing.json(l) file"},
+	} {
+		value, err := fixtures(tt.name)
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("%s: got error %v, want %q", tt.name, err, tt.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if got := lang.FormatValue(value); got != tt.want {
+			t.Errorf("%s: got %s, want %s", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestRunTests(t *testing.T) {
+	dir := t.TempDir()
+	bad := filepath.Join(dir, "bad.roz")
+	good := filepath.Join(dir, "good.roz")
+	if err := os.WriteFile(bad, []byte("x := (\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	src := "#test \"passes\" {\n    assert_eq(1, 1)\n}\n\n" +
+		"#test \"runs forever\" {\n    fn() {\n        1\n    } >> fn(x) {\n        x\n    }\n}\n"
+	if err := os.WriteFile(good, []byte(src), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	// a file that does not compile fails, and the others are still tested.
+	var out strings.Builder
+	tc := &TestCmd{Format: "tap", Timeout: 50 * time.Millisecond, Files: []string{bad, good}}
+	if err := tc.run(&out); err == nil || err.Error() != "2 test(s) failed" {
+		t.Errorf("got error %v", err)
+	}
+	for _, want := range []string{
+		"1..3\n",
+		"not ok 1 - " + bad + ": compile\n",
+		"ok 2 - " + good + ": passes\n",
+		"not ok 3 - " + good + ": runs forever\n",
+		"wall time limit of 50ms exceeded",
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("no %q in\n%s", want, out.String())
+		}
+	}
+
+	out.Reset()
+	tc = &TestCmd{MaxSteps: 1000, Filter: "forever", Files: []string{good}}
+	if err := tc.run(&out); err == nil || !strings.Contains(out.String(), "step limit of 1000 exceeded") {
+		t.Errorf("got error %v and output\n%s", err, out.String())
+	}
+}
@@ -0,0 +1,102 @@
+package rozer
+
+import (
+	"encoding/json"
+	"math"
+	"math/big"
+)
+
+// GetString returns the value with the name if it is a string.
+func (r *Roze) GetString(name string) (string, bool) {
+	s, ok := r.Get(name).(string)
+	return s, ok
+}
+
+// GetBool returns the value with the name if it is a bool.
+func (r *Roze) GetBool(name string) (bool, bool) {
+	b, ok := r.Get(name).(bool)
+	return b, ok
+}
+
+// GetRoze returns the value with the name if it is a nested Roze.
+func (r *Roze) GetRoze(name string) (*Roze, bool) {
+	n, ok := r.Get(name).(*Roze)
+	return n, ok && n != nil
+}
+
+// GetInt returns the value with the name if it is an integer that fits in an
+// int64, however it was decoded. A float with no fraction, such as 2.0, counts
+// as an integer.
+func (r *Roze) GetInt(name string) (int64, bool) {
+	return toInt(r.Get(name))
+}
+
+// GetFloat returns the value with the name if it is a number, however it was
+// decoded, converted to float64.
+func (r *Roze) GetFloat(name string) (float64, bool) {
+	return toFloat(r.Get(name))
+}
+
+func toInt(value any) (int64, bool) {
+	switch v := value.(type) {
+	case int:
+		return int64(v), true
+	case int64:
+		return v, true
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return i, true
+		}
+		f, err := v.Float64()
+		if err != nil {
+			return 0, false
+		}
+		return toInt(f)
+	case *big.Int:
+		if v == nil || !v.IsInt64() {
+			return 0, false
+		}
+		return v.Int64(), true
+	case float64:
+		if v != math.Trunc(v) || v < math.MinInt64 || v >= math.MaxInt64 {
+			return 0, false
+		}
+		return int64(v), true
+	case *big.Float:
+		if v == nil || !v.IsInt() {
+			return 0, false
+		}
+		i, accuracy := v.Int64()
+		return i, accuracy == big.Exact
+	default:
+		return 0, false
+	}
+}
+
+func toFloat(value any) (float64, bool) {
+	switch v := value.(type) {
+	case float64:
+		return v, true
+	case int:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case json.Number:
+		f, err := v.Float64()
+		return f, err == nil
+	case *big.Int:
+		if v == nil {
+			return 0, false
+		}
+		f, _ := new(big.Float).SetInt(v).Float64()
+		return f, true
+	case *big.Float:
+		if v == nil {
+			return 0, false
+		}
+		f, _ := v.Float64()
+		return f, true
+	default:
+		return 0, false
+	}
+}
@@ -1,6 +1,6 @@
 module github.com/pdk/rozer
 
-go 1.21.4
+go 1.23
 
 require (
 	github.com/alecthomas/kong v0.8.1
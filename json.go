@@ -4,36 +4,58 @@ import (
 	"bytes"
 	"encoding/json"
 	"fmt"
+	"io"
+	"math/big"
+	"strconv"
 
 	"github.com/pdk/rozer/pushback"
 )
 
+// MarshalJSON writes a Roze without names as a JSON array, and any other as a
+// JSON object with its members in order. In a mixed Roze each value without a
+// name is written with its position as its key, so Append(1).Put("a", 2) is
+// written as {"0":1,"a":2}; it is an error if a value has that position as
+// its name. A name given to several values with Add is written once for each.
 func (r Roze) MarshalJSON() ([]byte, error) {
-	if len(r.data) != len(r.names) {
+	if r.names == nil {
 		// marshal as array.
-		return json.Marshal(r.data)
-	}
-	// invert the names map.
-	names := make([]string, len(r.names))
-	for k, v := range r.names {
-		names[v] = k
+		m := bytes.Buffer{}
+		m.WriteByte('[')
+		for i, value := range r.data {
+			if i > 0 {
+				m.WriteByte(',')
+			}
+			valueBytes, err := marshalValue(value)
+			if err != nil {
+				return m.Bytes(), err
+			}
+			m.Write(valueBytes)
+		}
+		m.WriteByte(']')
+		return m.Bytes(), nil
 	}
+	names, named := r.nameAt()
 	// marshal as object.
 	m := bytes.Buffer{}
 	m.WriteByte('{')
-	for i, v := range names {
+	for i, value := range r.data {
 		if i > 0 {
 			m.WriteByte(',')
 		}
-		key := v
-		value := r.data[i]
+		key := names[i]
+		if !named[i] {
+			key = strconv.Itoa(i)
+			if _, ok := r.names[key]; ok {
+				return nil, fmt.Errorf("value at position %d would be written with the key %q, which is also a name", i, key)
+			}
+		}
 		keyBytes, err := json.Marshal(key)
 		if err != nil {
 			return m.Bytes(), err
 		}
 		m.Write(keyBytes)
 		m.WriteByte(':')
-		valueBytes, err := json.Marshal(value)
+		valueBytes, err := marshalValue(value)
 		if err != nil {
 			return m.Bytes(), err
 		}
@@ -44,13 +66,63 @@ func (r Roze) MarshalJSON() ([]byte, error) {
 	return m.Bytes(), nil
 }
 
+func marshalValue(value any) ([]byte, error) {
+	if f, ok := value.(*big.Float); ok {
+		return marshalNumber(f), nil
+	}
+	return json.Marshal(value)
+}
+
+// Decoding says how JSON is decoded into a Roze. The zero Decoding is how
+// UnmarshalJSON decodes.
+type Decoding struct {
+	// Numbers says how numbers are converted.
+	Numbers Numbers
+	// KeepDuplicates keeps every member of an object that repeats a key, as
+	// Add does, rather than only the value of the last.
+	KeepDuplicates bool
+	// Layouts, if not nil, shares the names of the objects decoded with it.
+	// Use one Layouts for the rows of a file, since they mostly have the
+	// same names.
+	Layouts *Layouts
+}
+
+// Unmarshal decodes a JSON object or array into a new Roze.
+func (d Decoding) Unmarshal(data []byte) (*Roze, error) {
+	r := New()
+	err := r.unmarshal(data, d)
+	if err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// UnmarshalJSON decodes a JSON object or array into r, keeping numbers as
+// json.Number. See Decoding for other ways to decode.
 func (r *Roze) UnmarshalJSON(data []byte) error {
+	return r.unmarshal(data, Decoding{})
+}
+
+func (r *Roze) unmarshal(data []byte, d Decoding) error {
 
 	dec := pushback.NewDecoder(bytes.NewReader(data))
-	return r.parseObjectOrArray(dec)
+	dec.UseNumber()
+	err := r.parseObjectOrArray(dec, d)
+	if err != nil {
+		return err
+	}
+
+	next, err := dec.Token()
+	if err != io.EOF {
+		if err != nil {
+			return err
+		}
+		return fmt.Errorf("unexpected JSON token after close: %T: %v", next, next)
+	}
+	return nil
 }
 
-func (r *Roze) parseObjectOrArray(dec *pushback.Decoder) error {
+func (r *Roze) parseObjectOrArray(dec *pushback.Decoder, d Decoding) error {
 
 	next, err := dec.Token()
 	if err != nil {
@@ -64,13 +136,13 @@ func (r *Roze) parseObjectOrArray(dec *pushback.Decoder) error {
 
 	switch delim {
 	case '{':
-		err = r.parseObject(dec)
+		err = r.parseObject(dec, d)
 		if err != nil {
 			return err
 		}
 		return confirmClose(dec, '}')
 	case '[':
-		err = r.parseArray(dec)
+		err = r.parseArray(dec, d)
 		if err != nil {
 			return err
 		}
@@ -93,7 +165,15 @@ func confirmClose(dec *pushback.Decoder, delim json.Delim) error {
 	return nil
 }
 
-func (r *Roze) parseObject(dec *pushback.Decoder) error {
+func (r *Roze) parseObject(dec *pushback.Decoder, d Decoding) error {
+	// an empty object is still an object, not an array, as newBuilder sees
+	// to.
+	b := newBuilder(r, d.Layouts)
+	put := b.put
+	if d.KeepDuplicates {
+		put = b.add
+	}
+
 	for {
 		next, err := dec.Token()
 		if err != nil {
@@ -110,10 +190,10 @@ func (r *Roze) parseObject(dec *pushback.Decoder) error {
 			return fmt.Errorf("unexpected JSON token (should be string key): %T: %v", next, next)
 		}
 
-		// TODO check if json.Decoder guarantees this is a string.
+		// json.Decoder only returns a string in key position, but be sure.
 		key, ok := next.(string)
 		if !ok {
-			return fmt.Errorf("unexpected JSON token (check if this ever happens): %T: %v", next, next)
+			return fmt.Errorf("unexpected JSON token (should be string key): %T: %v", next, next)
 		}
 
 		// json.Decoder eats the colon for us. value is next.
@@ -129,24 +209,28 @@ func (r *Roze) parseObject(dec *pushback.Decoder) error {
 				// value is a nested object or array
 				dec.Pushback(next)
 				value := &Roze{}
-				err = value.parseObjectOrArray(dec)
+				err = value.parseObjectOrArray(dec, d)
 				if err != nil {
 					return err
 				}
-				r.Put(key, value)
+				put(key, value)
 			} else {
 				return fmt.Errorf("unexpected JSON token (should be a value): %T: %v", next, next)
 			}
 		} else {
 			// value is a simple value.
-			r.Put(key, next)
+			value, err := d.Numbers.value(next)
+			if err != nil {
+				return err
+			}
+			put(key, value)
 		}
 
 		// json.Decoder eats the comma for us.
 	}
 }
 
-func (r *Roze) parseArray(dec *pushback.Decoder) error {
+func (r *Roze) parseArray(dec *pushback.Decoder, d Decoding) error {
 	for {
 		next, err := dec.Token()
 		if err != nil {
@@ -164,7 +248,7 @@ func (r *Roze) parseArray(dec *pushback.Decoder) error {
 				// value is a nested object or array
 				dec.Pushback(next)
 				value := &Roze{}
-				err = value.parseObjectOrArray(dec)
+				err = value.parseObjectOrArray(dec, d)
 				if err != nil {
 					return err
 				}
@@ -174,7 +258,11 @@ func (r *Roze) parseArray(dec *pushback.Decoder) error {
 			}
 		} else {
 			// value is a simple value
-			r.Append(next)
+			value, err := d.Numbers.value(next)
+			if err != nil {
+				return err
+			}
+			r.Append(value)
 		}
 
 		// json.Decoder eats the comma for us.
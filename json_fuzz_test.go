@@ -0,0 +1,55 @@
+package rozer
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+)
+
+// FuzzRozeJSON checks that any JSON a Roze accepts marshals to JSON that
+// reads back as the same Roze.
+func FuzzRozeJSON(f *testing.F) {
+	for _, seed := range []string{
+		`{}`,
+		`[]`,
+		`[[], {}]`,
+		`{"z":"apple", "x": {"b": "ack", "a": [1,2,3]}, "a":42}`,
+		`{"a": 1, "a": 2}`,
+		`[1.5, -2e10, true, false, null, "xé\n"]`,
+		`{"": {"": [{"": null}]}}`,
+		`{"a": 1} {"b": 2}`,
+		`[1, 2`,
+		`"just a string"`,
+	} {
+		f.Add([]byte(seed))
+	}
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		for _, d := range []Decoding{{}, {KeepDuplicates: true}} {
+			r, err := d.Unmarshal(data)
+			if err != nil {
+				return
+			}
+
+			first, err := json.Marshal(r)
+			if err != nil {
+				t.Fatalf("marshal %q: %v", data, err)
+			}
+			if !json.Valid(first) {
+				t.Fatalf("marshal %q: invalid JSON %q", data, first)
+			}
+
+			again, err := d.Unmarshal(first)
+			if err != nil {
+				t.Fatalf("unmarshal %q (from %q): %v", first, data, err)
+			}
+			second, err := json.Marshal(again)
+			if err != nil {
+				t.Fatalf("marshal %q: %v", first, err)
+			}
+			if !bytes.Equal(first, second) {
+				t.Fatalf("round trip of %q with %+v changed %q to %q", data, d, first, second)
+			}
+		}
+	})
+}
@@ -0,0 +1,149 @@
+package rozer
+
+import (
+	"encoding/json"
+	"math/big"
+	"testing"
+)
+
+func TestNumbersRoundTrip(t *testing.T) {
+	in := `{"id":18446744073709551615,"small":7,"one":1.0,"exp":1e3,"pi":3.14159265358979323846264338327950288,"list":[-0,12345678901234567]}`
+
+	for _, numbers := range []Numbers{NumbersPreserve, NumbersBig} {
+		r, err := Unmarshal([]byte(in), numbers)
+		if err != nil {
+			t.Fatalf("%s: %v", numbers, err)
+		}
+		out, err := json.Marshal(r)
+		if err != nil {
+			t.Fatalf("%s: %v", numbers, err)
+		}
+		if numbers == NumbersPreserve && string(out) != in {
+			t.Errorf("%s: got %s, want %s", numbers, out, in)
+		}
+
+		// big numbers keep every digit, but not how they were written.
+		again, err := Unmarshal(out, numbers)
+		if err != nil {
+			t.Fatalf("%s: %v", numbers, err)
+		}
+		out2, _ := json.Marshal(again)
+		if string(out2) != string(out) {
+			t.Errorf("%s: second round trip got %s, want %s", numbers, out2, out)
+		}
+	}
+}
+
+func TestNumbers(t *testing.T) {
+	in := []byte(`[9007199254740993, 2.5, 1e2, 18446744073709551615]`)
+
+	preserve, err := Unmarshal(in, NumbersPreserve)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := preserve.At(0).(json.Number); !IsInteger(n) || n.String() != "9007199254740993" {
+		t.Errorf("preserve: got %v", n)
+	}
+	if IsInteger(preserve.At(2).(json.Number)) {
+		t.Errorf("preserve: 1e2 is not written as an integer")
+	}
+
+	int64s, err := Unmarshal(in, NumbersInt64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := int64s.At(0); got != int64(9007199254740993) {
+		t.Errorf("int64: got %#v", got)
+	}
+	if got := int64s.At(1); got != 2.5 {
+		t.Errorf("int64: got %#v", got)
+	}
+	// too big for int64
+	if _, ok := int64s.At(3).(float64); !ok {
+		t.Errorf("int64: got %#v, want float64", int64s.At(3))
+	}
+
+	floats, err := Unmarshal(in, NumbersFloat64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := floats.At(0); got != float64(9007199254740992) {
+		t.Errorf("float64: got %#v", got)
+	}
+
+	bigs, err := Unmarshal(in, NumbersBig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := bigs.At(3).(*big.Int); got.String() != "18446744073709551615" {
+		t.Errorf("big: got %v", got)
+	}
+	if got := bigs.At(1).(*big.Float); got.Text('g', -1) != "2.5" {
+		t.Errorf("big: got %v", got)
+	}
+}
+
+func TestMixed(t *testing.T) {
+	r := New().Append(1).Put("a", 2).Append(3)
+	out, err := json.Marshal(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(out) != `{"0":1,"a":2,"2":3}` {
+		t.Errorf("got %s", out)
+	}
+
+	// a position written as a key must not be read back as a name.
+	for _, clash := range []*Roze{New().Append(1).Put("0", 2), New().Put("1", 1).Append(2)} {
+		if out, err := json.Marshal(clash); err == nil {
+			t.Errorf("clashing key: got %s", out)
+		}
+	}
+	if out, err := json.Marshal(New().Put("0", 1).Append(2)); err != nil || string(out) != `{"0":1,"1":2}` {
+		t.Errorf("got %s, %v", out, err)
+	}
+
+	out, _ = json.Marshal(New())
+	if string(out) != `[]` {
+		t.Errorf("empty: got %s", out)
+	}
+	empty, err := Decoding{}.Unmarshal([]byte(`{}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, _ = json.Marshal(empty)
+	if string(out) != `{}` {
+		t.Errorf("empty object: got %s", out)
+	}
+}
+
+func TestDuplicates(t *testing.T) {
+	in := `{"a":1,"b":2,"a":3,"a":{"x":1,"x":2}}`
+
+	kept, err := Decoding{KeepDuplicates: true}.Unmarshal([]byte(in))
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, _ := json.Marshal(kept)
+	if string(out) != in {
+		t.Errorf("kept: got %s, want %s", out, in)
+	}
+	if kept.Len() != 4 {
+		t.Errorf("kept: got %d values, want 4", kept.Len())
+	}
+	if got := kept.Get("b"); got != json.Number("2") {
+		t.Errorf("kept: Get(b) = %v", got)
+	}
+	if _, ok := kept.Get("a").(*Roze); !ok {
+		t.Errorf("kept: Get(a) = %v, want the last value", kept.Get("a"))
+	}
+
+	last, err := Unmarshal([]byte(in), NumbersPreserve)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, _ = json.Marshal(last)
+	if string(out) != `{"a":{"x":2},"b":2}` {
+		t.Errorf("last: got %s", out)
+	}
+}
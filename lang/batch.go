@@ -0,0 +1,227 @@
+package lang
+
+import (
+	"sync/atomic"
+
+	"github.com/alecthomas/participle/v2/lexer"
+	"github.com/pdk/rozer"
+)
+
+func init() {
+	registerBuiltin(BuiltinFunction{
+		Name:   "batch",
+		Params: []string{"size"},
+		Func: func(ee *ExecutionEnvironment) ExecutionResult {
+			size, ok := ee.Get("size").(IntegerValue)
+			if !ok || size < 1 {
+				runtimeErrorf(lexer.Position{}, "batch: size must be a positive integer, got %s", FormatValue(ee.Get("size")))
+			}
+			return BatchStage{Size: int(size)}
+		},
+	})
+	registerBuiltin(BuiltinFunction{
+		Name:   "unbatch",
+		Params: []string{},
+		Func: func(ee *ExecutionEnvironment) ExecutionResult {
+			return UnbatchStage{}
+		},
+	})
+}
+
+// BatchValue is a batch of records held by column, as made by the batch
+// stage. Reading a field of a batch, as in b.price, yields the list of its
+// values in every row, #null where a row has none, finding the column once.
+// b[i] is row i, as a RowValue.
+type BatchValue struct {
+	*rozer.Batch
+}
+
+// RowValue is a row of a batch. It is read like a record, but a field is
+// found by its column, whose index each field access in the program finds
+// once per batch rather than once per row.
+type RowValue struct {
+	Batch *rozer.Batch
+	Row   int
+}
+
+// Record returns the row as a record, copied out of the batch.
+func (rv RowValue) Record() RecordValue {
+	return RecordValue{rv.Batch.Row(rv.Row)}
+}
+
+// rows returns the rows of the batch.
+func (bv BatchValue) rows() ListResult {
+	list := ListResult{Items: make([]any, bv.Len())}
+	for i := range list.Items {
+		list.Items[i] = RowValue{bv.Batch, i}
+	}
+	return list
+}
+
+// newBatch makes a batch of records.
+func newBatch(items []any) BatchValue {
+	rows := make([]*rozer.Roze, len(items))
+	for i, item := range items {
+		switch item := item.(type) {
+		case RecordValue:
+			rows[i] = item.Roze
+		case RowValue:
+			rows[i] = item.Batch.Row(item.Row)
+		default:
+			runtimeErrorf(lexer.Position{}, "batch: expecting records, got %s", FormatValue(item))
+		}
+	}
+	b, err := rozer.NewBatch(rows)
+	if err != nil {
+		runtimeErrorf(lexer.Position{}, "batch: %s", err)
+	}
+	return BatchValue{b}
+}
+
+// columnValue returns the value of a column in row i, and whether the row has
+// one.
+func columnValue(c *rozer.Column, i int) (any, bool) {
+	if c.Missing != nil && c.Missing[i] {
+		return nil, false
+	}
+	switch c.Type {
+	case rozer.TypeInteger:
+		return IntegerValue(c.Ints[i]), true
+	case rozer.TypeNumber:
+		return FloatValue(c.Floats[i]), true
+	case rozer.TypeString:
+		return StringValue(c.Strings[i]), true
+	case rozer.TypeBool:
+		return BoolValue(c.Bools[i]), true
+	default:
+		return ValueFromRoze(c.Values[i]), true
+	}
+}
+
+// columnCache remembers the column a field access last found, and in which
+// batch. It is shared by every execution of the access, concurrent ones
+// included.
+type columnCache struct {
+	last atomic.Pointer[cachedColumn]
+}
+
+type cachedColumn struct {
+	batch *rozer.Batch
+	name  string
+	index int
+	ok    bool
+}
+
+// column returns the index of the column with the name in b, and whether
+// there is one.
+func (cc *columnCache) column(b *rozer.Batch, name string) (int, bool) {
+	if last := cc.last.Load(); last != nil && last.batch == b && last.name == name {
+		return last.index, last.ok
+	}
+	index, ok := b.Index(name)
+	cc.last.Store(&cachedColumn{b, name, index, ok})
+	return index, ok
+}
+
+// BatchStage is a pipeline stage that groups records into batches of Size,
+// the last of which may be smaller. Used outside of a pipeline it makes a
+// batch of a list of records.
+type BatchStage struct {
+	Size int
+}
+
+func (bs BatchStage) Apply(ee *ExecutionEnvironment) ExecutionResult {
+	list, ok := ee.Get("rows").(ListResult)
+	if !ok {
+		runtimeErrorf(lexer.Position{}, "batch: expecting a list of records, got %s", FormatValue(ee.Get("rows")))
+	}
+	return newBatch(list.Items)
+}
+
+func (bs BatchStage) ParameterNames() []string {
+	return []string{"rows"}
+}
+
+func (bs BatchStage) Execute(ee *ExecutionEnvironment) ExecutionResult {
+	return bs
+}
+
+func (bs BatchStage) Type(typeMap TypeMap) Type {
+	return TypeFunction
+}
+
+func (bs BatchStage) ListRep() []any {
+	return []any{"batch", bs.Size}
+}
+
+func (bs BatchStage) Stream(ee *ExecutionEnvironment, upstream Stream, done <-chan struct{}) Stream {
+	var end ExecutionResult
+	ended := false
+	return func() (ExecutionResult, bool) {
+		if ended {
+			return end, false
+		}
+		items := make([]any, 0, bs.Size)
+		for len(items) < bs.Size {
+			item, ok := upstream()
+			if !ok {
+				end, ended = item, true
+				if len(items) == 0 {
+					return end, false
+				}
+				break
+			}
+			items = append(items, item)
+		}
+		return newBatch(items), true
+	}
+}
+
+// UnbatchStage is a pipeline stage that yields each row of each batch, as a
+// RowValue. Other items are passed on as they are. Used outside of a pipeline
+// it returns the list of the rows of a batch.
+type UnbatchStage struct{}
+
+func (us UnbatchStage) Apply(ee *ExecutionEnvironment) ExecutionResult {
+	bv, ok := ee.Get("b").(BatchValue)
+	if !ok {
+		runtimeErrorf(lexer.Position{}, "unbatch: expecting a batch, got %s", FormatValue(ee.Get("b")))
+	}
+	return bv.rows()
+}
+
+func (us UnbatchStage) ParameterNames() []string {
+	return []string{"b"}
+}
+
+func (us UnbatchStage) Execute(ee *ExecutionEnvironment) ExecutionResult {
+	return us
+}
+
+func (us UnbatchStage) Type(typeMap TypeMap) Type {
+	return TypeFunction
+}
+
+func (us UnbatchStage) ListRep() []any {
+	return []any{"unbatch"}
+}
+
+func (us UnbatchStage) Stream(ee *ExecutionEnvironment, upstream Stream, done <-chan struct{}) Stream {
+	var current *rozer.Batch
+	next := 0
+	return func() (ExecutionResult, bool) {
+		for current == nil || next >= current.Len() {
+			item, ok := upstream()
+			if !ok {
+				return item, false
+			}
+			bv, ok := item.(BatchValue)
+			if !ok {
+				return item, true
+			}
+			current, next = bv.Batch, 0
+		}
+		next++
+		return RowValue{current, next - 1}, true
+	}
+}
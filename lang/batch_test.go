@@ -0,0 +1,69 @@
+package lang
+
+import (
+	"testing"
+
+	"github.com/pdk/rozer"
+)
+
+func recordStream(n int) Stream {
+	i := 0
+	return func() (ExecutionResult, bool) {
+		if i == n {
+			return TagComplete, false
+		}
+		i++
+		return RecordValue{rozer.NewObject().Put("id", int64(i)).Put("price", float64(i)/2)}, true
+	}
+}
+
+func TestBatchStages(t *testing.T) {
+	done := make(chan struct{})
+	defer close(done)
+	ee := NewExecutionEnvironment()
+
+	batches, end := drain(BatchStage{Size: 2}.Stream(ee, recordStream(5), done))
+	if end != TagComplete || len(batches) != 3 {
+		t.Fatalf("got %d batches, ended with %v", len(batches), end)
+	}
+	for i, want := range []int{2, 2, 1} {
+		if got := batches[i].(BatchValue).Len(); got != want {
+			t.Errorf("batch %d has %d rows, want %d", i, got, want)
+		}
+	}
+
+	double := compileForTest(t, "double", "fn(row) {\n    row.price * 2.0\n}\n").ExecuteProgram().(Parameterized)
+	stream := BatchStage{Size: 2}.Stream(ee, recordStream(5), done)
+	stream = UnbatchStage{}.Stream(ee, stream, done)
+	stream = applyStream(ee, stream, double)
+	items, end := drain(stream)
+	if end != TagComplete {
+		t.Errorf("stream ended with %v", end)
+	}
+	list := ListResult{}
+	for _, item := range items {
+		list.Items = append(list.Items, item)
+	}
+	if got := FormatValue(list); got != "[1.0, 2.0, 3.0, 4.0, 5.0]" {
+		t.Errorf("got %s", got)
+	}
+}
+
+func TestBatchOfNonRecords(t *testing.T) {
+	done := make(chan struct{})
+	defer close(done)
+
+	err := runStage(func() {
+		drain(BatchStage{Size: 2}.Stream(NewExecutionEnvironment(), countingStream(3), done))
+	})
+	if err == nil || err.Error() != "batch: expecting records, got 1" {
+		t.Errorf("got error %v", err)
+	}
+}
+
+// runStage runs fn, returning the error it aborts with, if any.
+func runStage(fn func()) (err error) {
+	defer recoverAbort(&err)
+	fn()
+	return nil
+}
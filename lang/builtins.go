@@ -0,0 +1,59 @@
+package lang
+
+import (
+	"sort"
+)
+
+// BuiltinFunction is a function implemented in Go that is available to every
+// program as a global.
+type BuiltinFunction struct {
+	Name   string
+	Params []string
+	Func   func(ee *ExecutionEnvironment) ExecutionResult
+}
+
+func (bf BuiltinFunction) Apply(ee *ExecutionEnvironment) ExecutionResult {
+	return bf.Func(ee)
+}
+
+func (bf BuiltinFunction) ParameterNames() []string {
+	return bf.Params
+}
+
+func (bf BuiltinFunction) Execute(ee *ExecutionEnvironment) ExecutionResult {
+	return bf
+}
+
+func (bf BuiltinFunction) Type(typeMap TypeMap) Type {
+	return TypeFunction
+}
+
+func (bf BuiltinFunction) ListRep() []any {
+	params := []string{"params"}
+	params = append(params, bf.Params...)
+	return []any{"builtin function", bf.Name, params}
+}
+
+var builtins = map[string]BuiltinFunction{}
+
+func registerBuiltin(bf BuiltinFunction) {
+	builtins[bf.Name] = bf
+}
+
+// Builtins returns the builtin functions, sorted by name.
+func Builtins() []BuiltinFunction {
+	list := make([]BuiltinFunction, 0, len(builtins))
+	for _, bf := range builtins {
+		list = append(list, bf)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Name < list[j].Name
+	})
+	return list
+}
+
+func installBuiltins(ee *ExecutionEnvironment) {
+	for name, bf := range builtins {
+		ee.SetGlobal(name, bf)
+	}
+}
@@ -1,9 +1,12 @@
 package lang
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
+	"io"
 	"log"
+	"strings"
 
 	"github.com/alecthomas/participle/v2/lexer"
 )
@@ -21,15 +24,23 @@ type Parameterized interface {
 	Apply(*ExecutionEnvironment) ExecutionResult
 }
 
+// ExecutionEnvironment holds the variables of one program run. Local
+// environments share the globals of the environment they were created from.
+// An ExecutionEnvironment is not safe for concurrent use; concurrent runs each
+// need their own, as ProgramExecute.ExecuteProgram provides.
 type ExecutionEnvironment struct {
 	global map[string]any
 	local  map[string]any
+
+	run   *runState
+	depth int
 }
 
 func NewExecutionEnvironment() *ExecutionEnvironment {
 	return &ExecutionEnvironment{
 		global: map[string]any{},
 		local:  map[string]any{},
+		run:    newRunState(context.Background(), Limits{}),
 	}
 }
 
@@ -37,6 +48,8 @@ func (ee *ExecutionEnvironment) NewLocalEnvironment() *ExecutionEnvironment {
 	return &ExecutionEnvironment{
 		global: ee.global,
 		local:  map[string]any{},
+		run:    ee.run,
+		depth:  ee.depth,
 	}
 }
 
@@ -67,7 +80,7 @@ func (ee *ExecutionEnvironment) GlobalExists(key string) bool {
 
 func (ee *ExecutionEnvironment) Set(key string, value any) {
 	if ee.GlobalExists(key) {
-		log.Fatalf("cannot reassign global variable %s", key)
+		panic(abort{&RuntimeError{Msg: fmt.Sprintf("cannot reassign global variable %s", key)}})
 	}
 
 	ee.local[key] = value
@@ -96,6 +109,9 @@ const (
 	TypeList
 	TypeKeyValue
 	TypeFunction
+	TypeModule
+	TypeRecord
+	TypeBatch
 	TypeCount
 )
 
@@ -121,6 +137,12 @@ func (t Type) String() string {
 		return "identifier"
 	case TypeFunction:
 		return "function"
+	case TypeModule:
+		return "module"
+	case TypeRecord:
+		return "record"
+	case TypeBatch:
+		return "batch"
 	default:
 		return fmt.Sprintf("unknown type %d", t)
 	}
@@ -138,7 +160,7 @@ func TypeOf(x any) Type {
 		return TypeInteger
 	case StringValue:
 		return TypeString
-	case ListValue:
+	case ListValue, ListResult:
 		return TypeList
 	case KeyValueResult:
 		return TypeKeyValue
@@ -146,8 +168,14 @@ func TypeOf(x any) Type {
 		return TypeTag
 	case IdentifierValue:
 		return TypeIdentifier
-	case FunctionExecute:
+	case FunctionExecute, BuiltinFunction, ParallelStage, ModuleFunction, BatchStage, UnbatchStage, SortStage, TopStage, JoinStage, DistinctStage, DedupeStage, RunningStage, CountWindowStage, TimeWindowStage:
 		return TypeFunction
+	case *Module:
+		return TypeModule
+	case RecordValue, RowValue:
+		return TypeRecord
+	case BatchValue:
+		return TypeBatch
 	default:
 		log.Printf("unknown type %T", x)
 		return TypeUnknown
@@ -170,10 +198,10 @@ var (
 	}
 	DivOpMap = [TypeCount]func(any, any) any{
 		TypeFloat:   func(a, b any) any { return FloatValue(a.(FloatValue) / b.(FloatValue)) },
-		TypeInteger: func(a, b any) any { return IntegerValue(a.(IntegerValue) / b.(IntegerValue)) },
+		TypeInteger: func(a, b any) any { return IntegerValue(a.(IntegerValue) / nonZero(b.(IntegerValue))) },
 	}
 	ModuloOpMap = [TypeCount]func(any, any) any{
-		TypeInteger: func(a, b any) any { return IntegerValue(a.(IntegerValue) % b.(IntegerValue)) },
+		TypeInteger: func(a, b any) any { return IntegerValue(a.(IntegerValue) % nonZero(b.(IntegerValue))) },
 	}
 	EqualOpMap = [TypeCount]func(any, any) BoolValue{
 		TypeBool:    func(a, b any) BoolValue { return BoolValue(a.(BoolValue) == b.(BoolValue)) },
@@ -230,16 +258,58 @@ func unknownHandler[T any](m [TypeCount]func(any, any) T, desc string) func(any,
 		aType := TypeOf(a)
 		bType := TypeOf(b)
 		if aType != bType || aType == TypeUnknown || bType == TypeUnknown {
-			log.Fatalf("cannot %s types %s, %s at %s", desc, aType, bType, currentPos)
+			panic(operandError(fmt.Sprintf("cannot %s types %s, %s", desc, aType, bType)))
 		}
 		op := m[aType]
 		if op == nil {
-			log.Fatalf("cannot %s type %s at %s", desc, aType, currentPos)
+			panic(operandError(fmt.Sprintf("cannot %s type %s", desc, aType)))
 		}
 		return op(a, b)
 	}
 }
 
+// operandError is raised by the operator maps when the operand types are only
+// known at run time and turn out to be invalid. The operator maps do not know
+// where they were invoked from, so the operation that called them recovers the
+// error and reports it with its own position.
+type operandError string
+
+// nonZero returns the divisor i, raising an operandError if it is zero.
+func nonZero(i IntegerValue) IntegerValue {
+	if i == 0 {
+		panic(operandError("integer division by zero"))
+	}
+	return i
+}
+
+func reportOperandError(pos lexer.Position) {
+	r := recover()
+	if r == nil {
+		return
+	}
+	if oe, ok := r.(operandError); ok {
+		runtimeErrorf(pos, "%s", oe)
+	}
+	panic(r)
+}
+
+// CompileError is an error found while compiling, with the extent of the
+// source it applies to and a diagnostic code identifying the kind of error.
+type CompileError struct {
+	Code   string
+	Pos    lexer.Position
+	EndPos lexer.Position
+	Msg    string
+}
+
+func (ce *CompileError) Error() string {
+	return fmt.Sprintf("%s at %s", ce.Msg, ce.Pos)
+}
+
+func compileErrorf(code string, pos, endPos lexer.Position, format string, args ...any) error {
+	return &CompileError{code, pos, endPos, fmt.Sprintf(format, args...)}
+}
+
 type CompileErrors struct {
 	Errs *[]error
 }
@@ -258,13 +328,35 @@ func (ce *CompileErrors) Append(err ...error) CompileErrors {
 	return *ce
 }
 
+// Collect appends errs to ce and returns e. If e failed to compile, the
+// result is a placeholder of unknown type, so that compiling can go on to find
+// further errors.
 func (ce *CompileErrors) Collect(e Executable, errs CompileErrors) Executable {
 	if errs.Errs != nil {
 		ce.Append(*errs.Errs...)
 	}
+	if e == nil {
+		return invalidExecutable{}
+	}
 	return e
 }
 
+// invalidExecutable stands in for an expression that failed to compile. It
+// is never executed, as programs with compile errors are not run.
+type invalidExecutable struct{}
+
+func (invalidExecutable) Execute(*ExecutionEnvironment) ExecutionResult {
+	panic(abort{fmt.Errorf("cannot execute an expression with compile errors")})
+}
+
+func (invalidExecutable) Type(TypeMap) Type {
+	return TypeUnknown
+}
+
+func (invalidExecutable) ListRep() []any {
+	return []any{"invalid"}
+}
+
 func NewError(err error) CompileErrors {
 	return CompileErrors{&[]error{err}}
 }
@@ -283,6 +375,7 @@ type ExecutableBlock struct {
 func (b ExecutableBlock) Execute(ee *ExecutionEnvironment) ExecutionResult {
 	var lastResult ExecutionResult
 	for _, c := range b.Commands {
+		ee.step()
 		lastResult = c.Execute(ee)
 		log.Printf("execution returned %v", lastResult)
 	}
@@ -380,7 +473,17 @@ type IdentifierValue struct {
 }
 
 func (i IdentifierValue) Execute(ee *ExecutionEnvironment) ExecutionResult {
-	return ee.Get(i.Value)
+	v := ee.Get(i.Value)
+	if v == nil {
+		// functions do not see the variables of their caller, so a name that
+		// compiled may still be undefined here.
+		var pos lexer.Position
+		if i.Base != nil {
+			pos = i.Base.Pos
+		}
+		runtimeErrorf(pos, "%s is not defined", i.Value)
+	}
+	return v
 }
 
 func (i IdentifierValue) Type(typeMap TypeMap) Type {
@@ -404,20 +507,16 @@ func (l ListValue) Type(typeMap TypeMap) Type {
 func (b *Base) Compile(typeMap TypeMap) (Executable, CompileErrors) {
 	switch {
 	case b.Bool != nil:
-		switch *b.Bool {
-		case "true":
-			return BoolValue(true), NoErrors
-		case "false":
-			return BoolValue(false), NoErrors
-		default:
-			log.Fatalf("invalid bool at %s: %s", b.Pos, *b.Bool)
-		}
+		// the literals are case insensitive, like identifiers.
+		return BoolValue(strings.EqualFold(*b.Bool, "true")), NoErrors
 	case b.Float != nil:
 		return FloatValue(*b.Float), NoErrors
 	case b.Integer != nil:
 		return IntegerValue(*b.Integer), NoErrors
 	case b.Tag != nil:
 		return TagValue{b, *b.Tag}, NoErrors
+	case b.Ident != nil && len(b.Members) > 0:
+		return b.compileMember(typeMap)
 	case b.Ident != nil:
 		return IdentifierValue{b, *b.Ident}, NoErrors
 	case b.StringValue != nil:
@@ -434,7 +533,7 @@ func (b *Base) Compile(typeMap TypeMap) (Executable, CompileErrors) {
 		return b.Invocation.Compile(typeMap)
 	}
 
-	return nil, NewError(fmt.Errorf("cannot compile base %#v", b))
+	return nil, NewError(compileErrorf(CodeInvalidSyntax, b.Pos, b.Pos, "cannot compile base %#v", b))
 }
 
 type UnaryExecuteNot struct {
@@ -443,7 +542,12 @@ type UnaryExecuteNot struct {
 }
 
 func (uen UnaryExecuteNot) Execute(ee *ExecutionEnvironment) ExecutionResult {
-	return BoolValue(!uen.Operand.Execute(ee).(BoolValue))
+	operand := uen.Operand.Execute(ee)
+	b, ok := operand.(BoolValue)
+	if !ok {
+		runtimeErrorf(uen.Unary.Pos, "invalid unary operation ! for type %s", TypeOf(operand))
+	}
+	return BoolValue(!b)
 }
 
 func (uen UnaryExecuteNot) Type(typeMap TypeMap) Type {
@@ -488,6 +592,32 @@ func (uemi UnaryExecuteMinusInteger) ListRep() []any {
 	return []any{"-", uemi.Operand.ListRep()}
 }
 
+// UnaryExecuteMinus negates an operand whose type is only known at run time.
+type UnaryExecuteMinus struct {
+	Unary   *Unary
+	Operand Executable
+}
+
+func (uem UnaryExecuteMinus) Execute(ee *ExecutionEnvironment) ExecutionResult {
+	switch operand := uem.Operand.Execute(ee).(type) {
+	case IntegerValue:
+		return -operand
+	case FloatValue:
+		return -operand
+	default:
+		runtimeErrorf(uem.Unary.Pos, "invalid unary operation - for type %s", TypeOf(operand))
+		return nil
+	}
+}
+
+func (uem UnaryExecuteMinus) Type(typeMap TypeMap) Type {
+	return TypeUnknown
+}
+
+func (uem UnaryExecuteMinus) ListRep() []any {
+	return []any{"-", uem.Operand.ListRep()}
+}
+
 func (u *Unary) Compile(typeMap TypeMap) (Executable, CompileErrors) {
 
 	if u.Base != nil {
@@ -495,15 +625,16 @@ func (u *Unary) Compile(typeMap TypeMap) (Executable, CompileErrors) {
 	}
 
 	if u.Unary == nil {
-		return nil, NewError(fmt.Errorf("cannot compile unary %s", u))
+		return nil, NewError(compileErrorf(CodeInvalidSyntax, u.Pos, u.EndPos, "cannot compile unary %s", u))
 	}
 
-	operand, errs := u.Unary.Compile(typeMap)
+	var errs CompileErrors
+	operand := errs.Collect(u.Unary.Compile(typeMap))
 
 	switch *u.Op {
 	case "!":
-		if operand.Type(typeMap) != TypeBool {
-			errs.Append(fmt.Errorf("invalid unary operation %s at %s", *u.Op, u.Pos))
+		if t := operand.Type(typeMap); t != TypeBool && t != TypeUnknown {
+			errs.Append(compileErrorf(CodeInvalidOperator, u.Pos, u.EndPos, "invalid unary operation %s", *u.Op))
 		}
 		return UnaryExecuteNot{u, operand}, errs
 	case "-":
@@ -512,13 +643,15 @@ func (u *Unary) Compile(typeMap TypeMap) (Executable, CompileErrors) {
 			return UnaryExecuteSubtractFloat{u, operand}, errs
 		case TypeInteger:
 			return UnaryExecuteMinusInteger{u, operand}, errs
+		case TypeUnknown:
+			return UnaryExecuteMinus{u, operand}, errs
 		default:
-			errs.Append(fmt.Errorf("invalid unary operation %s at %s", *u.Op, u.Pos))
+			errs.Append(compileErrorf(CodeInvalidOperator, u.Pos, u.EndPos, "invalid unary operation %s", *u.Op))
 			return nil, errs
 		}
 	}
 
-	return nil, errs.Append(fmt.Errorf("invalid unary operation %s at %s", *u.Op, u.Pos))
+	return nil, errs.Append(compileErrorf(CodeInvalidOperator, u.Pos, u.EndPos, "invalid unary operation %s", *u.Op))
 }
 
 type InvocationExecute struct {
@@ -538,14 +671,14 @@ func (i InvocationExecute) Execute(ee *ExecutionEnvironment) ExecutionResult {
 	case Parameterized:
 		// looks good. fall thru to execute the function
 	default:
-		log.Fatalf("invalid function invocation (expecting Parameterized, got %T) at %s: %s", producerResult, i.Pos, i.String())
+		runtimeErrorf(i.Pos, "invalid function invocation (expecting Parameterized, got %T): %s", producerResult, i.String())
 	}
 
 	functionExecute := producerResult.(Parameterized)
 	params := functionExecute.ParameterNames()
 
 	if len(params) != len(i.Arguments) {
-		log.Fatalf("function invocation expecting %d params, but got %d at %s: %s", len(params), len(i.Arguments), i.Pos, i.String())
+		runtimeErrorf(i.Pos, "function invocation expecting %d params, but got %d: %s", len(params), len(i.Arguments), i.String())
 	}
 
 	// compute the values of the arguments
@@ -557,12 +690,16 @@ func (i InvocationExecute) Execute(ee *ExecutionEnvironment) ExecutionResult {
 	}
 
 	// assign the values to the parameters in a new local environment
-	local := ee.NewLocalEnvironment()
+	ee.step()
+	local := ee.enter()
 	for i, param := range params {
 		local.Set(param, values[i])
 	}
 
 	// execute the function with the new environment
+	if _, ok := functionExecute.(BuiltinFunction); ok {
+		defer locateError(i.Pos)
+	}
 	result := functionExecute.Apply(local)
 	log.Printf("invocation result: %v", result)
 
@@ -580,13 +717,26 @@ func (i InvocationExecute) ListRep() []any {
 		args = append(args, arg.ListRep())
 	}
 
-	return []any{"invocation", *i.Name, args}
+	return []any{"invocation", i.qualifiedName(), args}
+}
+
+func (i *Invocation) qualifiedName() string {
+	if i.Module != nil {
+		return *i.Module + "." + *i.Name
+	}
+	return *i.Name
 }
 
 func (i *Invocation) Compile(typeMap TypeMap) (Executable, CompileErrors) {
 	var errs CompileErrors
 
-	funcProducer := IdentifierValue{Value: *i.Name}
+	var funcProducer Executable = IdentifierValue{Value: *i.Name}
+	if i.Module != nil {
+		if typeMap[*i.Module] != TypeModule {
+			errs.Append(compileErrorf(CodeImport, i.Pos, i.Pos, "unknown module %s", *i.Module))
+		}
+		funcProducer = ModuleMember{i.Pos, *i.Module, *i.Name}
+	}
 
 	execArgs := []Executable{}
 	for _, arg := range i.Arguments {
@@ -644,7 +794,7 @@ func (nf *NamedFunction) Compile(typeMap TypeMap) (Executable, CompileErrors) {
 	var errs CompileErrors
 
 	if nf.Name == nil {
-		return nil, errs.Append(fmt.Errorf("invalid named function at %s", nf.Pos))
+		return nil, errs.Append(compileErrorf(CodeInvalidSyntax, nf.Pos, nf.EndPos, "invalid named function"))
 	}
 
 	ex := errs.Collect(nf.Body.Compile(typeMap))
@@ -671,9 +821,9 @@ func (rb *RequiredBlock) Compile(typeMap TypeMap) (Executable, CompileErrors) {
 
 	block := ExecutableBlock{}
 
-	for _, e := range rb.Statements {
-		if e != nil {
-			ex := errs.Collect(e.Compile(typeMap))
+	for _, line := range rb.Lines {
+		if line.Statement != nil {
+			ex := errs.Collect(line.Statement.Compile(typeMap))
 			block.Commands = append(block.Commands, ex)
 		}
 	}
@@ -717,7 +867,7 @@ func (m *Multiplication) Compile(typeMap TypeMap) (Executable, CompileErrors) {
 	} else {
 		for _, operand := range operands {
 			if ex.Type(typeMap) != operand.Type(typeMap) {
-				errs.Append(fmt.Errorf("type mismatch %s for %s at %s", ex.Type(typeMap), operand.Type(typeMap), m.Pos))
+				errs.Append(compileErrorf(CodeTypeMismatch, m.Pos, m.EndPos, "type mismatch %s for %s", ex.Type(typeMap), operand.Type(typeMap)))
 			}
 		}
 	}
@@ -726,24 +876,24 @@ func (m *Multiplication) Compile(typeMap TypeMap) (Executable, CompileErrors) {
 		switch m.Operations[i].Op {
 		case "*":
 			if multOp == nil {
-				errs.Append(fmt.Errorf("invalid operator %s for type %s at %s", m.Operations[i].Op, ex.Type(typeMap), m.Pos))
+				errs.Append(compileErrorf(CodeInvalidOperator, m.Pos, m.EndPos, "invalid operator %s for type %s", m.Operations[i].Op, ex.Type(typeMap)))
 				continue
 			}
 			ex = BinaryOperation{m.Pos, "*", multOp, ex, operand, ex.Type(typeMap)}
 		case "/":
 			if divOp == nil {
-				errs.Append(fmt.Errorf("invalid operator %s for type %s at %s", m.Operations[i].Op, ex.Type(typeMap), m.Pos))
+				errs.Append(compileErrorf(CodeInvalidOperator, m.Pos, m.EndPos, "invalid operator %s for type %s", m.Operations[i].Op, ex.Type(typeMap)))
 				continue
 			}
 			ex = BinaryOperation{m.Pos, "/", divOp, ex, operand, ex.Type(typeMap)}
 		case "%":
 			if moduloOp == nil {
-				errs.Append(fmt.Errorf("invalid operator %s for type %s at %s", m.Operations[i].Op, ex.Type(typeMap), m.Pos))
+				errs.Append(compileErrorf(CodeInvalidOperator, m.Pos, m.EndPos, "invalid operator %s for type %s", m.Operations[i].Op, ex.Type(typeMap)))
 				continue
 			}
 			ex = BinaryOperation{m.Pos, "%", moduloOp, ex, operand, ex.Type(typeMap)}
 		default:
-			errs.Append(fmt.Errorf("invalid operator %s for type %s at %s", m.Operations[i].Op, ex.Type(typeMap), m.Pos))
+			errs.Append(compileErrorf(CodeInvalidOperator, m.Pos, m.EndPos, "invalid operator %s for type %s", m.Operations[i].Op, ex.Type(typeMap)))
 		}
 	}
 
@@ -796,6 +946,8 @@ func (ife InnerFunctionExecute) ListRep() []any {
 	return []any{"inner function"}
 }
 
+// Execute returns a generator for the series. The generator's position is
+// captured by the closure, so each execution of the series gets its own.
 func (se SeriesExecute) Execute(ee *ExecutionEnvironment) ExecutionResult {
 	from := se.From.Execute(ee)
 	to := se.To.Execute(ee)
@@ -844,7 +996,7 @@ func (kv *KeyValue) Compile(typeMap TypeMap) (Executable, CompileErrors) {
 	}
 
 	if ex.Type(typeMap) != TypeString {
-		errs.Append(fmt.Errorf("invalid type %s for key (should be string) at %s", ex.Type(typeMap), kv.Pos))
+		errs.Append(compileErrorf(CodeInvalidType, kv.Pos, kv.EndPos, "invalid type %s for key (should be string)", ex.Type(typeMap)))
 	}
 
 	rex := errs.Collect(kv.RightValue.Compile(typeMap))
@@ -883,36 +1035,50 @@ func (l *Logical) Compile(typeMap TypeMap) (Executable, CompileErrors) {
 		return ex, errs
 	}
 
-	if ex.Type(typeMap) != TypeBool {
-		errs.Append(fmt.Errorf("invalid type %s for logical operation at %s", ex.Type(typeMap), l.Pos))
+	// operands whose type is only known at run time are checked then.
+	if t := ex.Type(typeMap); t != TypeBool && t != TypeUnknown {
+		errs.Append(compileErrorf(CodeInvalidType, l.Pos, l.EndPos, "invalid type %s for logical operation", t))
 	}
 
-	operands := []Executable{}
 	for _, opExpr := range l.Operations {
-		operands = append(operands, errs.Collect(opExpr.Operand.Compile(typeMap)))
-	}
+		operand := errs.Collect(opExpr.Operand.Compile(typeMap))
+		if t := operand.Type(typeMap); t != TypeBool && t != TypeUnknown {
+			errs.Append(compileErrorf(CodeInvalidType, opExpr.Operand.Pos, opExpr.Operand.EndPos, "invalid type %s for logical operation", t))
+		}
 
-	for i, operand := range operands {
-		switch ex.Type(typeMap) {
-		case TypeBool:
-			switch l.Operations[i].Op {
-			case "&&":
-				ex = ShortCircuitAnd{ex, operand}
-			case "||":
-				ex = ShortCircuitOr{ex, operand}
-			default:
-				errs.Append(fmt.Errorf("invalid operator %s for type %s at %s", l.Operations[i].Op, ex.Type(typeMap), l.Pos))
-				return nil, errs
-			}
+		switch opExpr.Op {
+		case "&&":
+			ex = ShortCircuitAnd{l.Pos, ex, operand}
+		case "||":
+			ex = ShortCircuitOr{l.Pos, ex, operand}
 		default:
-			errs.Append(fmt.Errorf("invalid type %s for logical operation at %s", ex.Type(typeMap), l.Pos))
-			return nil, errs
+			errs.Append(compileErrorf(CodeInvalidOperator, l.Pos, l.EndPos, "invalid operator %s for type %s", opExpr.Op, ex.Type(typeMap)))
+			return ex, errs
 		}
 	}
 
 	return ex, errs
 }
 
+// truth returns the value of an operand of a logical operation, which must be
+// a bool.
+func truth(pos lexer.Position, v ExecutionResult) bool {
+	b, ok := v.(BoolValue)
+	if !ok {
+		runtimeErrorf(pos, "invalid type %s for logical operation", TypeOf(v))
+	}
+	return bool(b)
+}
+
+var comparisonOpMaps = map[string]*[TypeCount]func(any, any) BoolValue{
+	"==": &EqualOpMap,
+	"!=": &NotEqualOpMap,
+	"<":  &LessThanOpMap,
+	"<=": &LessThanOrEqualOpMap,
+	">":  &GreaterThanOpMap,
+	">=": &GreaterThanOrEqualOpMap,
+}
+
 func (c *Comparison) Compile(typeMap TypeMap) (Executable, CompileErrors) {
 	var errs CompileErrors
 
@@ -927,49 +1093,28 @@ func (c *Comparison) Compile(typeMap TypeMap) (Executable, CompileErrors) {
 	}
 
 	for i, operand := range operands {
-		switch c.Operations[i].Op {
-		case "==":
-			equalOp := EqualOpMap[ex.Type(typeMap)]
-			if equalOp == nil {
-				errs.Append(fmt.Errorf("invalid operator %s for type %s at %s", c.Operations[i].Op, ex.Type(typeMap), c.Pos))
-				continue
-			}
-			ex = ComparisonOperation{c.Pos, "==", equalOp, ex, operand}
-		case "!=":
-			notEqualOp := NotEqualOpMap[ex.Type(typeMap)]
-			if notEqualOp == nil {
-				errs.Append(fmt.Errorf("invalid operator %s for type %s at %s", c.Operations[i].Op, ex.Type(typeMap), c.Pos))
-				continue
-			}
-			ex = ComparisonOperation{c.Pos, "!=", notEqualOp, ex, operand}
-		case "<":
-			lessThanOp := LessThanOpMap[ex.Type(typeMap)]
-			if lessThanOp == nil {
-				errs.Append(fmt.Errorf("invalid operator %s for type %s at %s", c.Operations[i].Op, ex.Type(typeMap), c.Pos))
-				continue
-			}
-			ex = ComparisonOperation{c.Pos, "<", lessThanOp, ex, operand}
-		case "<=":
-			lessThanOrEqualOp := LessThanOrEqualOpMap[ex.Type(typeMap)]
-			if lessThanOrEqualOp == nil {
-				errs.Append(fmt.Errorf("invalid operator %s for type %s at %s", c.Operations[i].Op, ex.Type(typeMap), c.Pos))
-			}
-			ex = ComparisonOperation{c.Pos, "<=", lessThanOrEqualOp, ex, operand}
-		case ">":
-			greaterThanOp := GreaterThanOpMap[ex.Type(typeMap)]
-			if greaterThanOp == nil {
-				errs.Append(fmt.Errorf("invalid operator %s for type %s at %s", c.Operations[i].Op, ex.Type(typeMap), c.Pos))
-			}
-			ex = ComparisonOperation{c.Pos, ">", greaterThanOp, ex, operand}
-		case ">=":
-			greaterThanOrEqualOp := GreaterThanOrEqualOpMap[ex.Type(typeMap)]
-			if greaterThanOrEqualOp == nil {
-				errs.Append(fmt.Errorf("invalid operator %s for type %s at %s", c.Operations[i].Op, ex.Type(typeMap), c.Pos))
-			}
-			ex = ComparisonOperation{c.Pos, ">=", greaterThanOrEqualOp, ex, operand}
-		default:
-			errs.Append(fmt.Errorf("invalid operator %s for type %s at %s", c.Operations[i].Op, ex.Type(typeMap), c.Pos))
+		op := c.Operations[i].Op
+		opMap, ok := comparisonOpMaps[op]
+		if !ok {
+			errs.Append(compileErrorf(CodeInvalidOperator, c.Pos, c.EndPos, "invalid operator %s for type %s", op, ex.Type(typeMap)))
+			continue
 		}
+
+		// operands of unknown type are checked when the comparison is run.
+		opType := ex.Type(typeMap)
+		if opType == TypeUnknown || operand.Type(typeMap) == TypeUnknown {
+			opType = TypeUnknown
+		} else if opType != operand.Type(typeMap) {
+			errs.Append(compileErrorf(CodeTypeMismatch, c.Pos, c.EndPos, "type mismatch %s for %s", opType, operand.Type(typeMap)))
+			continue
+		}
+
+		compareOp := opMap[opType]
+		if compareOp == nil {
+			errs.Append(compileErrorf(CodeInvalidOperator, c.Pos, c.EndPos, "invalid operator %s for type %s", op, opType))
+			continue
+		}
+		ex = ComparisonOperation{c.Pos, op, compareOp, ex, operand}
 	}
 
 	return ex, errs
@@ -1015,34 +1160,33 @@ func (pe PipeExecute) Execute(ee *ExecutionEnvironment) ExecutionResult {
 		switch fn := result.(type) {
 		case Parameterized:
 			if i > 0 && len(fn.ParameterNames()) != 1 {
-				log.Fatalf("invalid pipeline (every target must accept 1 argument) at %s: %s", pe.Pipe.Pos, pe.Pipe.String())
+				runtimeErrorf(pe.Pipe.Pos, "invalid pipeline (every target must accept 1 argument): %s", pe.Pipe.String())
 			}
 			functions[i] = fn
 		default:
-			log.Fatalf("invalid pipeline (expecting function, got %T) at %s: %s", fn, pe.Pipe.Pos, pe.Pipe.String())
+			runtimeErrorf(pe.Pipe.Pos, "invalid pipeline (expecting function, got %T): %s", fn, pe.Pipe.String())
+		}
+	}
+
+	done := make(chan struct{})
+	defer close(done)
+
+	stream := sourceStream(ee, functions[0])
+	for _, fn := range functions[1:] {
+		switch stage := fn.(type) {
+		case StreamStage:
+			stream = stage.Stream(ee, stream, done)
+		default:
+			stream = applyStream(ee, stream, fn)
 		}
 	}
 
 	var lastResult ExecutionResult
 	for {
 		log.Printf("executing pipeline, lastResult=%v", lastResult)
-		for i, fn := range functions {
-			fnEnv := ee.NewLocalEnvironment()
-			if i > 0 {
-				firstParam := fn.ParameterNames()[0]
-				fnEnv.Set(firstParam, lastResult)
-			}
-			lastResult = fn.Apply(fnEnv)
-			if TagContinue == lastResult ||
-				TagComplete == lastResult ||
-				TagBreak == lastResult ||
-				TagNull == lastResult {
-				break
-			}
-		}
-		if TagComplete == lastResult ||
-			TagBreak == lastResult ||
-			TagNull == lastResult {
+		result, ok := stream()
+		lastResult = result
+		if !ok {
 			break
 		}
 	}
@@ -1075,8 +1219,11 @@ func (a *Assignment) Compile(typeMap TypeMap) (Executable, CompileErrors) {
 		return nil, errs
 	}
 
+	if fa, ok := ex.(FieldAccess); ok {
+		return a.compileFieldAssignment(typeMap, fa, operand, errs)
+	}
 	if !IsIdentifier(ex) {
-		errs.Append(fmt.Errorf("invalid left hand side %s for assignment at %s", ex.Type(typeMap), a.Pos))
+		errs.Append(compileErrorf(CodeInvalidAssignment, a.Pos, a.EndPos, "invalid left hand side %s for assignment", ex.Type(typeMap)))
 		return nil, errs
 	}
 	curType, ok := typeMap[ex.(IdentifierValue).Value]
@@ -1084,8 +1231,8 @@ func (a *Assignment) Compile(typeMap TypeMap) (Executable, CompileErrors) {
 	if !ok {
 		typeMap[ex.(IdentifierValue).Value] = opType
 	} else if curType != TypeUnknown && opType != TypeUnknown && curType != opType {
-		errs.Append(fmt.Errorf("cannot change type of variable %s from %s to %s at %s",
-			ex.(IdentifierValue).Value, curType, opType, a.Pos))
+		errs.Append(compileErrorf(CodeTypeChange, a.Pos, a.EndPos, "cannot change type of variable %s from %s to %s",
+			ex.(IdentifierValue).Value, curType, opType))
 	}
 	switch a.Operation.Op {
 	case ":=":
@@ -1093,7 +1240,7 @@ func (a *Assignment) Compile(typeMap TypeMap) (Executable, CompileErrors) {
 	case "+=":
 		ex = PlusAssignmentExecute{a, ex.(IdentifierValue), operand}
 	default:
-		errs.Append(fmt.Errorf("invalid assignment operator %s at %s", a.Operation.Op, a.Pos))
+		errs.Append(compileErrorf(CodeInvalidAssignment, a.Pos, a.EndPos, "invalid assignment operator %s", a.Operation.Op))
 	}
 
 	return ex, errs
@@ -1115,7 +1262,7 @@ func (ae AssignmentExecute) Execute(ee *ExecutionEnvironment) ExecutionResult {
 
 	leftType := TypeOf(ee.Get(ae.Left.Value))
 	if leftType != TypeUnknown && leftType != TypeOf(right) {
-		log.Fatalf("cannot change type of variable %s from %s to %s at %s", ae.Left.Value, leftType, TypeOf(right), ae.Assignment.Pos)
+		runtimeErrorf(ae.Assignment.Pos, "cannot change type of variable %s from %s to %s", ae.Left.Value, leftType, TypeOf(right))
 	}
 
 	ee.Set(ae.Left.Value, right)
@@ -1140,18 +1287,23 @@ func (pae PlusAssignmentExecute) Execute(ee *ExecutionEnvironment) ExecutionResu
 	left := ee.Get(pae.Left.Value)
 	right := pae.Right.Execute(ee)
 
+	newVal := plus(pae.Assignment.Pos, left, right)
+	ee.Set(pae.Left.Value, newVal)
+	return newVal
+}
+
+// plus adds right to left, for +=.
+func plus(pos lexer.Position, left, right ExecutionResult) ExecutionResult {
 	if TypeOf(left) != TypeOf(right) {
-		log.Fatalf("type mismatch %s/%s for += at %s", TypeOf(left), TypeOf(right), pae.Assignment.Pos)
+		runtimeErrorf(pos, "type mismatch %s/%s for +=", TypeOf(left), TypeOf(right))
 	}
 
 	plusOp := PlusOpMap[TypeOf(left)]
 	if plusOp == nil {
-		log.Fatalf("invalid type %s for += at %s", TypeOf(left), pae.Assignment.Pos)
+		runtimeErrorf(pos, "invalid type %s for +=", TypeOf(left))
 	}
 
-	newVal := plusOp(left, right)
-	ee.Set(pae.Left.Value, newVal)
-	return newVal
+	return plusOp(left, right)
 }
 
 func (pae PlusAssignmentExecute) Type(typeMap TypeMap) Type {
@@ -1169,7 +1321,7 @@ func (l List) Compile(typeMap TypeMap) (Executable, CompileErrors) {
 	var list ListExecute
 
 	for _, item := range l.Items {
-		list.Items = append(list.Items, errs.Collect(item.Compile(typeMap)))
+		list.Items = append(list.Items, errs.Collect(item.Value.Compile(typeMap)))
 	}
 
 	return list, errs
@@ -1188,6 +1340,7 @@ type ListResult struct {
 func (le ListExecute) Execute(ee *ExecutionEnvironment) ExecutionResult {
 	result := ListResult{}
 
+	ee.collect(len(le.Items))
 	for _, Item := range le.Items {
 		result.Items = append(result.Items, Item.Execute(ee))
 	}
@@ -1211,17 +1364,37 @@ func (e Expression) Compile(typeMap TypeMap) (Executable, CompileErrors) {
 	var errs CompileErrors
 
 	if e.Assignment == nil {
-		return nil, errs.Append(fmt.Errorf("expression is empty at %s", e.Pos))
+		return nil, errs.Append(compileErrorf(CodeInvalidSyntax, e.Pos, e.EndPos, "expression is empty"))
 	}
 
 	return e.Assignment.Compile(typeMap)
 }
 
+// Compile compiles the program, loading the modules it imports with the
+// DefaultLoader.
 func (p Program) Compile(typeMap TypeMap) (ProgramExecute, CompileErrors) {
+	return p.CompileWith(typeMap, DefaultLoader)
+}
+
+// CompileWith compiles the program, loading the modules it imports with loader.
+func (p Program) CompileWith(typeMap TypeMap, loader *Loader) (ProgramExecute, CompileErrors) {
+	loader.mu.Lock()
+	defer loader.mu.Unlock()
+
+	return p.compile(typeMap, loader, importStack(p.Pos.Filename))
+}
+
+func (p Program) compile(typeMap TypeMap, loader *Loader, stack []string) (ProgramExecute, CompileErrors) {
 	var errs CompileErrors
 
+	imports, importErrs := p.compileImports(typeMap, loader, stack)
+	if importErrs.Errs != nil {
+		errs.Append(*importErrs.Errs...)
+	}
+
 	block := ExecutableBlock{}
 	functions := []FunctionExecute{}
+	tests := []TestExecute{}
 
 	for _, c := range p.Commands {
 		if c != nil {
@@ -1229,6 +1402,9 @@ func (p Program) Compile(typeMap TypeMap) (ProgramExecute, CompileErrors) {
 			case c.NamedFunction != nil:
 				e := errs.Collect(c.NamedFunction.Compile(typeMap))
 				functions = append(functions, e.(FunctionExecute))
+			case c.TestBlock != nil:
+				e := errs.Collect(c.TestBlock.Compile(typeMap))
+				tests = append(tests, e.(TestExecute))
 			case c.Expression != nil:
 				e := errs.Collect(c.Expression.Compile(typeMap))
 				block.Commands = append(block.Commands, e)
@@ -1236,39 +1412,93 @@ func (p Program) Compile(typeMap TypeMap) (ProgramExecute, CompileErrors) {
 		}
 	}
 
+	referenceErrs := p.checkModuleReferences(imports)
+	if referenceErrs.Errs != nil {
+		errs.Append(*referenceErrs.Errs...)
+	}
+
 	return ProgramExecute{
 		Program:         p,
+		Imports:         imports,
 		NamedFunctions:  functions,
+		Tests:           tests,
 		ExecutableBlock: block,
 	}, errs
 }
 
+// ProgramExecute is a compiled program. It is not modified by execution, and
+// all state for a run lives in the ExecutionEnvironment created for it, so a
+// single ProgramExecute may be executed from many goroutines at once.
 type ProgramExecute struct {
 	Program        Program
+	Imports        []ImportExecute
 	NamedFunctions []FunctionExecute
+	Tests          []TestExecute
 	ExecutableBlock
 }
 
+// ExecuteProgram runs the program in a new ExecutionEnvironment and returns the
+// result of the last command. It is safe to call concurrently.
 func (pe ProgramExecute) ExecuteProgram() ExecutionResult {
+	result, err := pe.ExecuteProgramContext(context.Background(), Limits{})
+	if err != nil {
+		log.Fatal(err)
+	}
+	return result
+}
+
+// ExecuteProgramContext runs the program like ExecuteProgram, but stops when
+// ctx is done or the run exceeds limits. Errors found while executing are
+// returned rather than being fatal; exceeding a limit returns a *LimitError.
+func (pe ProgramExecute) ExecuteProgramContext(ctx context.Context, limits Limits) (ExecutionResult, error) {
+	ctx, cancel := limits.context(ctx)
+	defer cancel()
+
+	return pe.ExecuteIn(NewProgramEnvironment(ctx, limits))
+}
+
+// NewProgramEnvironment returns a top level environment, with the builtin
+// functions installed, for running programs with ExecuteIn.
+func NewProgramEnvironment(ctx context.Context, limits Limits) *ExecutionEnvironment {
 	execEnv := NewExecutionEnvironment()
+	execEnv.run = newRunState(ctx, limits)
+	installBuiltins(execEnv)
+	return execEnv
+}
 
-	for _, fe := range pe.NamedFunctions {
-		if execEnv.GlobalExists(*fe.NamedFunction.Name) {
-			var pos lexer.Position
-			if fe.NamedFunction.Name != nil {
-				pos = fe.NamedFunction.Pos
-			} else {
-				pos = fe.UnnamedFunction.Pos
-			}
-			log.Fatalf("duplicate function %s at %s", *fe.NamedFunction.Name, pos)
+// ExecuteIn runs the program in an environment that may already hold the
+// variables and functions of programs run before it. Named functions and
+// imports replace earlier definitions of the same name, but not builtins.
+func (pe ProgramExecute) ExecuteIn(execEnv *ExecutionEnvironment) (result ExecutionResult, err error) {
+	defer recoverAbort(&err)
+
+	pe.define(execEnv)
+	return pe.ExecutableBlock.Execute(execEnv), nil
+}
+
+// define installs the imports and named functions of the program in execEnv.
+func (pe ProgramExecute) define(execEnv *ExecutionEnvironment) {
+	for _, ie := range pe.Imports {
+		if _, isBuiltin := execEnv.Get(ie.Alias).(BuiltinFunction); isBuiltin {
+			runtimeErrorf(ie.Import.Pos, "cannot import %s as builtin %s", ie.Path, ie.Alias)
 		}
-		execEnv.SetGlobal(*fe.NamedFunction.Name, fe)
+		execEnv.SetGlobal(ie.Alias, ie.Module)
 	}
 
-	return pe.ExecutableBlock.Execute(execEnv)
+	defined := map[string]bool{}
+	for _, fe := range pe.NamedFunctions {
+		name := *fe.NamedFunction.Name
+		_, isBuiltin := execEnv.Get(name).(BuiltinFunction)
+		if defined[name] || isBuiltin {
+			runtimeErrorf(fe.NamedFunction.Pos, "duplicate function %s", name)
+		}
+		defined[name] = true
+		execEnv.SetGlobal(name, fe)
+	}
 }
 
-func (pe ProgramExecute) DumpProgram() {
+// DumpProgram writes the compiled form of the program to w, as JSON.
+func (pe ProgramExecute) DumpProgram(w io.Writer) {
 	dump := pe.ExecutableBlock.ListRep()
 
 	b, err := json.MarshalIndent(dump, "", "    ")
@@ -1276,10 +1506,12 @@ func (pe ProgramExecute) DumpProgram() {
 		log.Fatal(err)
 	}
 
-	fmt.Println(string(b))
+	fmt.Fprintln(w, string(b))
 }
 
-func (pe ProgramExecute) DumpFunctions() {
+// DumpFunctions writes the compiled form of the named functions to w, as
+// JSON.
+func (pe ProgramExecute) DumpFunctions(w io.Writer) {
 	functions := []any{}
 
 	for _, fe := range pe.NamedFunctions {
@@ -1291,7 +1523,7 @@ func (pe ProgramExecute) DumpFunctions() {
 		log.Fatal(err)
 	}
 
-	fmt.Println(string(b))
+	fmt.Fprintln(w, string(b))
 }
 
 func (a *Addition) Compile(typeMap TypeMap) (Executable, CompileErrors) {
@@ -1319,13 +1551,13 @@ func (a *Addition) Compile(typeMap TypeMap) (Executable, CompileErrors) {
 	} else {
 		for _, operand := range operands {
 			if ex.Type(typeMap) != operand.Type(typeMap) {
-				errs.Append(fmt.Errorf("type mismatch %s for %s at %s", ex.Type(typeMap), operand.Type(typeMap), a.Pos))
+				errs.Append(compileErrorf(CodeTypeMismatch, a.Pos, a.EndPos, "type mismatch %s for %s", ex.Type(typeMap), operand.Type(typeMap)))
 			}
 		}
 	}
 
 	if plus == nil || minus == nil {
-		errs.Append(fmt.Errorf("invalid type %s for +, - at %s", ex.Type(typeMap), a.Pos))
+		errs.Append(compileErrorf(CodeInvalidType, a.Pos, a.EndPos, "invalid type %s for +, -", ex.Type(typeMap)))
 		return nil, errs
 	}
 
@@ -1336,16 +1568,13 @@ func (a *Addition) Compile(typeMap TypeMap) (Executable, CompileErrors) {
 		case "-":
 			ex = BinaryOperation{a.Pos, "-", minus, ex, operand, ex.Type(typeMap)}
 		default:
-			errs.Append(fmt.Errorf("invalid operator %s for type %s at %s", a.Operations[i].Op, ex.Type(typeMap), a.Pos))
+			errs.Append(compileErrorf(CodeInvalidOperator, a.Pos, a.EndPos, "invalid operator %s for type %s", a.Operations[i].Op, ex.Type(typeMap)))
 		}
 	}
 
 	return ex, errs
 }
 
-// very evil. very very bad.
-var currentPos lexer.Position
-
 type BinaryOperation struct {
 	Pos lexer.Position
 
@@ -1356,7 +1585,7 @@ type BinaryOperation struct {
 }
 
 func (bo BinaryOperation) Execute(ee *ExecutionEnvironment) ExecutionResult {
-	currentPos = bo.Pos
+	defer reportOperandError(bo.Pos)
 	return bo.Func(bo.Left.Execute(ee), bo.Right.Execute(ee))
 }
 
@@ -1377,7 +1606,7 @@ type ComparisonOperation struct {
 }
 
 func (co ComparisonOperation) Execute(ee *ExecutionEnvironment) ExecutionResult {
-	currentPos = co.Pos
+	defer reportOperandError(co.Pos)
 	return co.Func(co.Left.Execute(ee), co.Right.Execute(ee))
 }
 
@@ -1390,16 +1619,16 @@ func (co ComparisonOperation) ListRep() []any {
 }
 
 type ShortCircuitAnd struct {
+	Pos         lexer.Position
 	Left, Right Executable
 }
 
 // Execute returns the result of the left expression if it is false, otherwise it returns the result of the right expression.
 func (sca ShortCircuitAnd) Execute(ee *ExecutionEnvironment) ExecutionResult {
-	leftValue := sca.Left.Execute(ee)
-	if !bool(leftValue.(BoolValue)) {
+	if !truth(sca.Pos, sca.Left.Execute(ee)) {
 		return BoolValue(false)
 	}
-	return sca.Right.Execute(ee)
+	return BoolValue(truth(sca.Pos, sca.Right.Execute(ee)))
 }
 
 func (sca ShortCircuitAnd) Type(typeMap TypeMap) Type {
@@ -1411,16 +1640,16 @@ func (sca ShortCircuitAnd) ListRep() []any {
 }
 
 type ShortCircuitOr struct {
+	Pos         lexer.Position
 	Left, Right Executable
 }
 
 // Execute returns the result of the left expression if it is true, otherwise it returns the result of the right expression.
 func (sco ShortCircuitOr) Execute(ee *ExecutionEnvironment) ExecutionResult {
-	leftValue := sco.Left.Execute(ee)
-	if bool(leftValue.(BoolValue)) {
+	if truth(sco.Pos, sco.Left.Execute(ee)) {
 		return BoolValue(true)
 	}
-	return sco.Right.Execute(ee)
+	return BoolValue(truth(sco.Pos, sco.Right.Execute(ee)))
 }
 
 func (sco ShortCircuitOr) Type(typeMap TypeMap) Type {
@@ -0,0 +1,102 @@
+package lang
+
+import (
+	"io"
+	"log"
+	"os"
+	"sync"
+	"testing"
+)
+
+func TestMain(m *testing.M) {
+	log.SetOutput(io.Discard)
+	os.Exit(m.Run())
+}
+
+var concurrentPrograms = []struct {
+	name   string
+	source string
+	want   ExecutionResult
+}{
+	{
+		name:   "arithmetic",
+		source: "1 + 2 * 3 - 4 / 2\n",
+		want:   IntegerValue(5),
+	},
+	{
+		name:   "invocation",
+		source: "fn sq(x) {\n    x * x\n}\nsq(3) + sq(4) == 25\n",
+		want:   BoolValue(true),
+	},
+	{
+		name:   "assignment",
+		source: "a := 2\na += 40\na\n",
+		want:   IntegerValue(42),
+	},
+	{
+		name:   "pipeline",
+		source: "1..500 >> fn(x) {\n    x + 1\n} >> fn(x) {\n    x * 2 > 10\n}\n",
+		want:   TagComplete,
+	},
+}
+
+func compileForTest(t *testing.T, name, source string) ProgramExecute {
+	program, err := ParseString(name, source)
+	if err != nil {
+		t.Fatalf("%s: parse: %s", name, err)
+	}
+	executable, errs := program.Compile(TypeMap{})
+	if errs.Len() > 0 {
+		t.Fatalf("%s: compile: %v", name, *errs.Errs)
+	}
+	return executable
+}
+
+func TestConcurrentExecution(t *testing.T) {
+	const runs = 50
+
+	var wg sync.WaitGroup
+	for _, cp := range concurrentPrograms {
+		executable := compileForTest(t, cp.name, cp.source)
+		for i := 0; i < runs; i++ {
+			wg.Add(1)
+			go func(name string, want ExecutionResult) {
+				defer wg.Done()
+				got := executable.ExecuteProgram()
+				if got != want {
+					t.Errorf("%s: got %v, want %v", name, got, want)
+				}
+			}(cp.name, cp.want)
+		}
+	}
+	wg.Wait()
+}
+
+func TestConcurrentParseCompileExecute(t *testing.T) {
+	const runs = 20
+
+	var wg sync.WaitGroup
+	for i := 0; i < runs; i++ {
+		for _, cp := range concurrentPrograms {
+			wg.Add(1)
+			go func(name, source string, want ExecutionResult) {
+				defer wg.Done()
+				program, err := ParseString(name, source)
+				if err != nil {
+					t.Errorf("%s: parse: %s", name, err)
+					return
+				}
+				executable, errs := program.Compile(TypeMap{})
+				if errs.Len() > 0 {
+					t.Errorf("%s: compile: %v", name, *errs.Errs)
+					return
+				}
+				got := executable.ExecuteProgram()
+				if got != want {
+					t.Errorf("%s: got %v, want %v", name, got, want)
+				}
+			}(cp.name, cp.source, cp.want)
+		}
+	}
+	wg.Wait()
+}
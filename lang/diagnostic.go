@@ -0,0 +1,275 @@
+package lang
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/alecthomas/participle/v2"
+	"github.com/alecthomas/participle/v2/lexer"
+)
+
+// Diagnostic codes. E0xx are syntax errors found while lexing and parsing,
+// E1xx are errors found while compiling.
+const (
+	CodeSyntax            = "E001"
+	CodeInvalidToken      = "E002"
+	CodeInvalidSyntax     = "E100"
+	CodeTypeMismatch      = "E101"
+	CodeInvalidOperator   = "E102"
+	CodeInvalidType       = "E103"
+	CodeInvalidAssignment = "E104"
+	CodeTypeChange        = "E105"
+	CodeImport            = "E106"
+)
+
+// Diagnostic is an error in a program, located in its source, with an
+// optional hint on how to fix it.
+type Diagnostic struct {
+	Code    string
+	Pos     lexer.Position
+	EndPos  lexer.Position
+	Message string
+	Hint    string
+}
+
+func (d Diagnostic) Error() string {
+	return fmt.Sprintf("%s at %s", d.Message, d.Pos)
+}
+
+// Render formats the diagnostic with the source line it refers to and the
+// offending text underlined:
+//
+//	error[E001]: unexpected token "="
+//	  --> input.roz:3:3
+//	   |
+//	 3 | a = 1
+//	   |   ^
+//	   = hint: did you mean `:=`?
+func (d Diagnostic) Render(src []byte) string {
+	out := &strings.Builder{}
+	fmt.Fprintf(out, "error[%s]: %s\n", d.Code, d.Message)
+
+	if d.Pos.Line == 0 || d.Pos.Offset > len(src) {
+		fmt.Fprintf(out, "  --> %s\n", d.Pos.Filename)
+		if d.Hint != "" {
+			fmt.Fprintf(out, "  = hint: %s\n", d.Hint)
+		}
+		return out.String()
+	}
+
+	lineStart := bytes.LastIndexByte(src[:d.Pos.Offset], '\n') + 1
+	lineEnd := bytes.IndexByte(src[d.Pos.Offset:], '\n')
+	if lineEnd < 0 {
+		lineEnd = len(src)
+	} else {
+		lineEnd += d.Pos.Offset
+	}
+	line := strings.TrimRight(string(src[lineStart:lineEnd]), "\r")
+
+	end := d.EndPos.Offset
+	if end > lineStart+len(line) {
+		end = lineStart + len(line)
+	}
+
+	number := fmt.Sprint(d.Pos.Line)
+	gutter := strings.Repeat(" ", len(number))
+	fmt.Fprintf(out, "%s--> %s:%d:%d\n", gutter, d.Pos.Filename, d.Pos.Line, d.Pos.Column)
+	fmt.Fprintf(out, "%s |\n", gutter)
+	fmt.Fprintf(out, "%s | %s\n", number, line)
+	fmt.Fprintf(out, "%s | %s%s\n", gutter, padding(line[:d.Pos.Offset-lineStart]), underline(string(src[d.Pos.Offset:max(end, d.Pos.Offset)])))
+	if d.Hint != "" {
+		fmt.Fprintf(out, "%s = hint: %s\n", gutter, d.Hint)
+	}
+	return out.String()
+}
+
+// padding returns blanks as wide as prefix, keeping its tabs so the caret
+// lines up with the source line above it.
+func padding(prefix string) string {
+	b := &strings.Builder{}
+	for _, r := range prefix {
+		if r == '\t' {
+			b.WriteRune('\t')
+		} else {
+			b.WriteRune(' ')
+		}
+	}
+	return b.String()
+}
+
+func underline(text string) string {
+	n := len([]rune(text))
+	if n == 0 {
+		n = 1
+	}
+	return strings.Repeat("^", n)
+}
+
+// Diagnostics is a list of diagnostics, usable as an error.
+type Diagnostics []Diagnostic
+
+func (ds Diagnostics) Error() string {
+	messages := make([]string, len(ds))
+	for i, d := range ds {
+		messages[i] = d.Error()
+	}
+	return strings.Join(messages, "\n")
+}
+
+// Render renders each of the diagnostics, separated by blank lines.
+func (ds Diagnostics) Render(src []byte) string {
+	rendered := make([]string, len(ds))
+	for i, d := range ds {
+		rendered[i] = d.Render(src)
+	}
+	return strings.Join(rendered, "\n")
+}
+
+// ParseDiagnostic converts an error from Parse into a diagnostic.
+func ParseDiagnostic(err error) Diagnostic {
+	d := Diagnostic{Code: CodeSyntax, Message: err.Error()}
+
+	var perr participle.Error
+	if errors.As(err, &perr) {
+		d.Message = perr.Message()
+		d.Pos = perr.Position()
+		d.EndPos = d.Pos
+	}
+
+	var lerr *lexer.Error
+	if errors.As(err, &lerr) {
+		d.Code = CodeInvalidToken
+		d.EndPos.Offset++
+	}
+
+	var uerr *participle.UnexpectedTokenError
+	if errors.As(err, &uerr) {
+		d.EndPos.Offset += len(uerr.Unexpected.Value)
+		d.Hint = unexpectedTokenHint(uerr)
+	}
+
+	return d
+}
+
+func unexpectedTokenHint(uerr *participle.UnexpectedTokenError) string {
+	token := uerr.Unexpected
+	message := uerr.Message()
+	switch {
+	case strings.HasSuffix(message, `(expected ")")`):
+		return "check for a missing closing `)`"
+	case strings.HasSuffix(message, `(expected "]")`):
+		return "check for a missing closing `]`"
+	case strings.HasSuffix(message, `(expected "}")`):
+		return "check for a missing closing `}`"
+	case token.EOF():
+		return "the program ended early, check for an unclosed `(`, `[` or `{`"
+	case token.Value == "=":
+		return "did you mean `:=`?"
+	case token.Value == "#":
+		return "comments start with `//`"
+	case token.Value == "'":
+		return "strings are written with double quotes"
+	case token.Value == ")" || token.Value == "]" || token.Value == "}":
+		return fmt.Sprintf("check for a missing opening bracket before this %q", token.Value)
+	}
+	return ""
+}
+
+// CompileDiagnostic converts an error from Compile into a diagnostic.
+func CompileDiagnostic(err error) Diagnostic {
+	var ce *CompileError
+	if !errors.As(err, &ce) {
+		return Diagnostic{Code: CodeInvalidSyntax, Message: err.Error()}
+	}
+
+	d := Diagnostic{
+		Code:    ce.Code,
+		Pos:     ce.Pos,
+		EndPos:  ce.EndPos,
+		Message: ce.Msg,
+	}
+	switch ce.Code {
+	case CodeInvalidAssignment:
+		d.Hint = "only variables, and fields and items of records and lists, can be assigned to"
+	case CodeTypeChange:
+		d.Hint = "a variable keeps the type of its first assignment, use a new name"
+	}
+	return d
+}
+
+// CompileDiagnostics converts the errors from Compile into diagnostics.
+func CompileDiagnostics(errs CompileErrors) Diagnostics {
+	diagnostics := Diagnostics{}
+	if errs.Errs == nil {
+		return diagnostics
+	}
+	for _, err := range *errs.Errs {
+		diagnostics = append(diagnostics, CompileDiagnostic(err))
+	}
+	return diagnostics
+}
+
+// maxParseErrors is the number of syntax errors after which ParseAll gives up.
+const maxParseErrors = 10
+
+// ParseAll parses src, and instead of stopping at the first syntax error
+// carries on from the next line that looks like the start of a command, so
+// that several errors can be reported at once. The program is only returned
+// if there were no errors.
+func ParseAll(filename string, src []byte) (*Program, Diagnostics) {
+	diagnostics := Diagnostics{}
+	start, startLine := 0, 1
+
+	for {
+		program, err := Parser.ParseBytes(filename, src[start:])
+		if err == nil {
+			if len(diagnostics) == 0 {
+				return program, diagnostics
+			}
+			break
+		}
+
+		d := ParseDiagnostic(err)
+		if d.Pos.Line > 0 {
+			d.Pos = shift(d.Pos, start, startLine)
+			d.EndPos = shift(d.EndPos, start, startLine)
+		}
+		diagnostics = append(diagnostics, d)
+
+		if d.Pos.Line == 0 || len(diagnostics) == maxParseErrors {
+			break
+		}
+		start, startLine = resumeAfter(src, d.Pos)
+		if start >= len(src) {
+			break
+		}
+	}
+
+	return nil, diagnostics
+}
+
+func shift(pos lexer.Position, offset, line int) lexer.Position {
+	pos.Offset += offset
+	pos.Line += line - 1
+	return pos
+}
+
+// resumeAfter returns the offset and line number of the first line after pos
+// that starts in the first column with something other than a closing
+// bracket, which is likely the start of the next command.
+func resumeAfter(src []byte, pos lexer.Position) (int, int) {
+	offset, line := pos.Offset, pos.Line
+	for {
+		next := bytes.IndexByte(src[offset:], '\n')
+		if next < 0 {
+			return len(src), line
+		}
+		offset += next + 1
+		line++
+		if offset < len(src) && !strings.ContainsRune(" \t\r\n)]}", rune(src[offset])) {
+			return offset, line
+		}
+	}
+}
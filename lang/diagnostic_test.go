@@ -0,0 +1,59 @@
+package lang
+
+import (
+	"testing"
+)
+
+func TestParseAllReportsEachError(t *testing.T) {
+	src := []byte("a = 1\nb := 2\nc := [1, 2\nd := 3\n# comment\n")
+
+	program, diagnostics := ParseAll("bad.roz", src)
+	if program != nil {
+		t.Fatalf("expected no program from source with errors")
+	}
+
+	want := []struct {
+		line int
+		hint string
+	}{
+		{1, "did you mean `:=`?"},
+		{4, "check for a missing closing `]`"},
+		{5, "comments start with `//`"},
+	}
+	if len(diagnostics) != len(want) {
+		t.Fatalf("got %d diagnostics, want %d: %v", len(diagnostics), len(want), diagnostics)
+	}
+	for i, w := range want {
+		d := diagnostics[i]
+		if d.Pos.Line != w.line || d.Hint != w.hint || d.Code != CodeSyntax {
+			t.Errorf("diagnostic %d: got line %d code %s hint %q, want line %d hint %q", i, d.Pos.Line, d.Code, d.Hint, w.line, w.hint)
+		}
+	}
+}
+
+func TestParseAllEmpty(t *testing.T) {
+	program, diagnostics := ParseAll("empty.roz", nil)
+	if program == nil || len(diagnostics) > 0 {
+		t.Fatalf("got %v, %v for empty source", program, diagnostics)
+	}
+}
+
+func TestRender(t *testing.T) {
+	src := []byte("x := 1\ny := 1 + \"s\"\n")
+	program, diagnostics := ParseAll("mismatch.roz", src)
+	if len(diagnostics) > 0 {
+		t.Fatal(diagnostics)
+	}
+	_, errs := program.Compile(TypeMap{})
+	diagnostics = CompileDiagnostics(errs)
+
+	want := `error[E101]: type mismatch integer for string
+ --> mismatch.roz:2:6
+  |
+2 | y := 1 + "s"
+  |      ^^^^^^^
+`
+	if got := diagnostics.Render(src); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+}
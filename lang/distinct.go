@@ -0,0 +1,240 @@
+package lang
+
+import (
+	"math"
+
+	"github.com/alecthomas/participle/v2/lexer"
+)
+
+func init() {
+	registerBuiltin(BuiltinFunction{
+		Name:   "distinct",
+		Params: []string{},
+		Func: func(ee *ExecutionEnvironment) ExecutionResult {
+			return DistinctStage{}
+		},
+	})
+	registerBuiltin(BuiltinFunction{
+		Name:   "distinct_by",
+		Params: []string{"key"},
+		Func: func(ee *ExecutionEnvironment) ExecutionResult {
+			return DistinctStage{Key: keyFunction(ee, "distinct_by")}
+		},
+	})
+	registerBuiltin(BuiltinFunction{
+		Name:   "distinct_approx",
+		Params: []string{"n"},
+		Func: func(ee *ExecutionEnvironment) ExecutionResult {
+			return DistinctStage{Capacity: distinctCapacity(ee, "distinct_approx")}
+		},
+	})
+	registerBuiltin(BuiltinFunction{
+		Name:   "distinct_by_approx",
+		Params: []string{"n", "key"},
+		Func: func(ee *ExecutionEnvironment) ExecutionResult {
+			return DistinctStage{Key: keyFunction(ee, "distinct_by_approx"), Capacity: distinctCapacity(ee, "distinct_by_approx")}
+		},
+	})
+	registerBuiltin(BuiltinFunction{
+		Name:   "dedupe_consecutive",
+		Params: []string{},
+		Func: func(ee *ExecutionEnvironment) ExecutionResult {
+			return DedupeStage{}
+		},
+	})
+}
+
+func distinctCapacity(ee *ExecutionEnvironment, name string) int {
+	n, ok := ee.Get("n").(IntegerValue)
+	if !ok || n < 1 {
+		runtimeErrorf(lexer.Position{}, "%s: n must be a positive integer, got %s", name, FormatValue(ee.Get("n")))
+	}
+	return int(n)
+}
+
+// DistinctStage is a pipeline stage that yields only the first of the items
+// that are Equal, or whose keys are if Key is set. It remembers the key of
+// every item it yields, unless Capacity is set: then it remembers them in a
+// filter of fixed size, about 1.2 bytes for each of Capacity keys, which may
+// wrongly take an item for one seen before, about once in a hundred times
+// once Capacity items have been seen, and more often after. Used outside of
+// a pipeline it returns the distinct items of a list.
+type DistinctStage struct {
+	Key      Parameterized
+	Capacity int
+}
+
+func (ds DistinctStage) name() string {
+	name := "distinct"
+	if ds.Key != nil {
+		name += "_by"
+	}
+	if ds.Capacity > 0 {
+		name += "_approx"
+	}
+	return name
+}
+
+func (ds DistinctStage) Apply(ee *ExecutionEnvironment) ExecutionResult {
+	list, ok := ee.Get("items").(ListResult)
+	if !ok {
+		runtimeErrorf(lexer.Position{}, "%s: expecting a list, got %s", ds.name(), FormatValue(ee.Get("items")))
+	}
+	items, _ := drainStream(ds.Stream(ee, listStream(list), nil))
+	return ListResult{Items: items}
+}
+
+func (ds DistinctStage) ParameterNames() []string {
+	return []string{"items"}
+}
+
+func (ds DistinctStage) Execute(ee *ExecutionEnvironment) ExecutionResult {
+	return ds
+}
+
+func (ds DistinctStage) Type(typeMap TypeMap) Type {
+	return TypeFunction
+}
+
+func (ds DistinctStage) ListRep() []any {
+	rep := []any{ds.name()}
+	if ds.Capacity > 0 {
+		rep = append(rep, ds.Capacity)
+	}
+	if ds.Key != nil {
+		rep = append(rep, ds.Key.ListRep())
+	}
+	return rep
+}
+
+func (ds DistinctStage) Stream(ee *ExecutionEnvironment, upstream Stream, done <-chan struct{}) Stream {
+	// seen reports whether a key has been seen, and remembers it if not.
+	var seen func(key ExecutionResult) bool
+	if ds.Capacity > 0 {
+		filter := newBloomFilter(ds.Capacity)
+		seen = func(key ExecutionResult) bool {
+			return filter.add(Hash(key))
+		}
+	} else {
+		keys := map[uint64][]ExecutionResult{}
+		seen = func(key ExecutionResult) bool {
+			h := Hash(key)
+			for _, k := range keys[h] {
+				if Equal(k, key) {
+					return true
+				}
+			}
+			ee.collect(1)
+			keys[h] = append(keys[h], key)
+			return false
+		}
+	}
+
+	return func() (ExecutionResult, bool) {
+		for {
+			item, ok := upstream()
+			if !ok {
+				return item, false
+			}
+			key := item
+			if ds.Key != nil {
+				key = keyOf(ee, ds.Key, item).key
+			}
+			if !seen(key) {
+				return item, true
+			}
+		}
+	}
+}
+
+// bloomFilter is a set of hashes that takes a fixed amount of memory, and
+// may hold hashes never added to it.
+type bloomFilter struct {
+	bits   []uint64
+	hashes int
+}
+
+// newBloomFilter returns a filter that holds n hashes with a false positive
+// rate of about 1%.
+func newBloomFilter(n int) *bloomFilter {
+	// m = -n ln(p) / ln(2)^2 bits, and k = m/n ln(2) hashes, for p = 0.01.
+	m := int(math.Ceil(float64(n) * -math.Log(0.01) / (math.Ln2 * math.Ln2)))
+	return &bloomFilter{
+		bits:   make([]uint64, (m+63)/64),
+		hashes: max(1, int(math.Round(float64(m)/float64(n)*math.Ln2))),
+	}
+}
+
+// add adds a hash to the filter, reporting whether it may already have held
+// it.
+func (bf *bloomFilter) add(h uint64) bool {
+	// the k hashes are h1 + i*h2, with h2 mixed from h so that they differ.
+	m := uint64(len(bf.bits) * 64)
+	h1, h2 := h, mix(h)|1
+	held := true
+	for i := range uint64(bf.hashes) {
+		bit := (h1 + i*h2) % m
+		word, mask := bit/64, uint64(1)<<(bit%64)
+		if bf.bits[word]&mask == 0 {
+			held = false
+			bf.bits[word] |= mask
+		}
+	}
+	return held
+}
+
+// mix is the finalizer of splitmix64.
+func mix(h uint64) uint64 {
+	h ^= h >> 30
+	h *= 0xbf58476d1ce4e5b9
+	h ^= h >> 27
+	h *= 0x94d049bb133111eb
+	h ^= h >> 31
+	return h
+}
+
+// DedupeStage is a pipeline stage that drops each item Equal to the one
+// before it. Used outside of a pipeline it does so to the items of a list.
+type DedupeStage struct{}
+
+func (ds DedupeStage) Apply(ee *ExecutionEnvironment) ExecutionResult {
+	list, ok := ee.Get("items").(ListResult)
+	if !ok {
+		runtimeErrorf(lexer.Position{}, "dedupe_consecutive: expecting a list, got %s", FormatValue(ee.Get("items")))
+	}
+	items, _ := drainStream(ds.Stream(ee, listStream(list), nil))
+	return ListResult{Items: items}
+}
+
+func (ds DedupeStage) ParameterNames() []string {
+	return []string{"items"}
+}
+
+func (ds DedupeStage) Execute(ee *ExecutionEnvironment) ExecutionResult {
+	return ds
+}
+
+func (ds DedupeStage) Type(typeMap TypeMap) Type {
+	return TypeFunction
+}
+
+func (ds DedupeStage) ListRep() []any {
+	return []any{"dedupe_consecutive"}
+}
+
+func (ds DedupeStage) Stream(ee *ExecutionEnvironment, upstream Stream, done <-chan struct{}) Stream {
+	var last ExecutionResult
+	first := true
+	return func() (ExecutionResult, bool) {
+		for {
+			item, ok := upstream()
+			if !ok {
+				return item, false
+			}
+			if first || !Equal(last, item) {
+				first, last = false, item
+				return item, true
+			}
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package lang
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/pdk/rozer"
+)
+
+func TestHash(t *testing.T) {
+	b, _ := rozer.NewBatch([]*rozer.Roze{rozer.NewObject().Put("id", int64(1)).Put("name", "a")})
+	for _, pair := range [][2]any{
+		{RecordValue{rozer.NewObject().Put("id", json.Number("1")).Put("name", "a")}, RowValue{b, 0}},
+		{ListResult{Items: []any{FloatValue(0), TagNull}}, ListResult{Items: []any{FloatValue(-1 * 0.0), TagValue{Value: "#null"}}}},
+		{KeyValueResult{StringValue("a"), IntegerValue(1)}, KeyValueResult{StringValue("a"), IntegerValue(1)}},
+	} {
+		if !Equal(pair[0], pair[1]) {
+			t.Fatalf("%s is not equal to %s", FormatValue(pair[0]), FormatValue(pair[1]))
+		}
+		if Hash(pair[0]) != Hash(pair[1]) {
+			t.Errorf("%s and %s have different hashes", FormatValue(pair[0]), FormatValue(pair[1]))
+		}
+	}
+
+	for _, pair := range [][2]any{
+		{IntegerValue(1), FloatValue(1)},
+		{StringValue("ab"), ListResult{Items: []any{StringValue("a"), StringValue("b")}}},
+		{ListResult{Items: []any{StringValue("a"), StringValue("")}}, ListResult{Items: []any{StringValue(""), StringValue("a")}}},
+	} {
+		if Hash(pair[0]) == Hash(pair[1]) {
+			t.Errorf("%s and %s have the same hash", FormatValue(pair[0]), FormatValue(pair[1]))
+		}
+	}
+
+	// the hash is kept, so must not change.
+	if h := Hash(ListResult{Items: []any{IntegerValue(1), StringValue("a")}}); h != 0x20c5cf2ffebca4d1 {
+		t.Errorf("hash is %#x", h)
+	}
+}
+
+func TestBloomFilter(t *testing.T) {
+	const n = 10000
+	bf := newBloomFilter(n)
+	for i := range n {
+		bf.add(Hash(IntegerValue(i)))
+	}
+	for i := range n {
+		if !bf.add(Hash(IntegerValue(i))) {
+			t.Fatalf("%d was added but not held", i)
+		}
+	}
+
+	// about 1% of new items are taken for ones already held.
+	falsePositives := 0
+	for i := n; i < n+1000; i++ {
+		if bf.add(Hash(IntegerValue(i))) {
+			falsePositives++
+		}
+	}
+	if falsePositives > 25 {
+		t.Errorf("%d false positives in 1000", falsePositives)
+	}
+}
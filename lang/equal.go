@@ -0,0 +1,122 @@
+package lang
+
+import (
+	"fmt"
+	"slices"
+)
+
+// Equal reports whether two values are the same. Lists and key-value pairs are
+// compared item by item, records field by field, and tags by their text. A row
+// of a batch is a record, and a batch a list of them. Integers are never equal
+// to floats, and functions are never equal to anything.
+func Equal(a, b any) bool {
+	if rv, ok := b.(RowValue); ok {
+		b = rv.Record()
+	}
+	switch a := a.(type) {
+	case RowValue:
+		return Equal(a.Record(), b)
+	case BatchValue:
+		bb, ok := b.(BatchValue)
+		return ok && Equal(a.rows(), bb.rows())
+	case nil:
+		return b == nil
+	case BoolValue, FloatValue, IntegerValue, StringValue, IdentifierValue:
+		return a == b
+	case TagValue:
+		bt, ok := b.(TagValue)
+		return ok && a.Value == bt.Value
+	case KeyValueResult:
+		bkv, ok := b.(KeyValueResult)
+		return ok && Equal(a.Key, bkv.Key) && Equal(a.Value, bkv.Value)
+	case RecordValue:
+		br, ok := b.(RecordValue)
+		if !ok || a.Len() != br.Len() || !slices.Equal(a.Keys(), br.Keys()) {
+			return false
+		}
+		mixed := len(a.Keys()) != a.Len()
+		for i := 0; i < a.Len(); i++ {
+			if mixed {
+				_, an := a.NameAt(i)
+				_, bn := br.NameAt(i)
+				if an != bn {
+					return false
+				}
+			}
+			if !Equal(ValueFromRoze(a.At(i)), ValueFromRoze(br.At(i))) {
+				return false
+			}
+		}
+		return true
+	case ListResult:
+		bl, ok := b.(ListResult)
+		if !ok || len(a.Items) != len(bl.Items) {
+			return false
+		}
+		for i := range a.Items {
+			if !Equal(a.Items[i], bl.Items[i]) {
+				return false
+			}
+		}
+		return true
+	default:
+		return false
+	}
+}
+
+// Diff describes how actual differs from expected, one line per difference,
+// each starting with the path to the differing item, such as [2] or [0].name.
+// It returns nil if the values are Equal.
+func Diff(expected, actual any) []string {
+	var lines []string
+	diff("", expected, actual, &lines)
+	return lines
+}
+
+func diff(path string, expected, actual any, lines *[]string) {
+	if Equal(expected, actual) {
+		return
+	}
+
+	at := path
+	if at == "" {
+		at = "value"
+	}
+
+	switch e := expected.(type) {
+	case ListResult:
+		a, ok := actual.(ListResult)
+		if !ok {
+			break
+		}
+		for i := 0; i < len(e.Items) || i < len(a.Items); i++ {
+			itemPath := fmt.Sprintf("%s[%d]", path, i)
+			switch {
+			case i >= len(a.Items):
+				*lines = append(*lines, fmt.Sprintf("%s: missing %s", itemPath, FormatValue(e.Items[i])))
+			case i >= len(e.Items):
+				*lines = append(*lines, fmt.Sprintf("%s: unexpected %s", itemPath, FormatValue(a.Items[i])))
+			default:
+				diff(itemPath, e.Items[i], a.Items[i], lines)
+			}
+		}
+		return
+	case KeyValueResult:
+		a, ok := actual.(KeyValueResult)
+		if !ok || !Equal(e.Key, a.Key) {
+			break
+		}
+		diff(path+"."+keyName(e.Key), e.Value, a.Value, lines)
+		return
+	}
+
+	*lines = append(*lines, fmt.Sprintf("%s: expected %s, got %s", at, FormatValue(expected), FormatValue(actual)))
+}
+
+// keyName returns a key as it appears in a diff path: strings without quotes.
+func keyName(key any) string {
+	if s, ok := key.(StringValue); ok {
+		return string(s)
+	}
+	return FormatValue(key)
+}
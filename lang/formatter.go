@@ -0,0 +1,403 @@
+package lang
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Format parses src and returns it in canonical form. Comments and blank lines
+// are kept, spacing is normalized, blocks are indented by four spaces, and
+// lines continued after an operator are indented one level further than the
+// statement they belong to.
+func Format(filename string, src []byte) ([]byte, error) {
+	program, diagnostics := ParseAll(filename, src)
+	if len(diagnostics) > 0 {
+		return nil, diagnostics
+	}
+	return []byte(program.Format()), nil
+}
+
+// Format returns the program in canonical form.
+func (p Program) Format() string {
+	f := &formatter{}
+	p.format(f)
+	if f.started {
+		f.out.WriteByte('\n')
+	}
+	return f.out.String()
+}
+
+type formatter struct {
+	out strings.Builder
+
+	line    int  // indentation of the current line
+	base    int  // indentation of the current statement
+	started bool // whether anything has been written
+	blank   bool // whether a blank line is due before the next line
+}
+
+func (f *formatter) write(s string) {
+	f.out.WriteString(s)
+}
+
+func (f *formatter) startLine(indent int) {
+	if f.started {
+		f.out.WriteByte('\n')
+		if f.blank {
+			f.out.WriteByte('\n')
+		}
+	}
+	f.started = true
+	f.blank = false
+	f.line = indent
+	f.out.WriteString(strings.Repeat("    ", indent))
+}
+
+// eol notes a blank line if the line break eol spans more than one line.
+func (f *formatter) eol(eol *string) {
+	if eol == nil || !f.started {
+		return
+	}
+	newlines := strings.Count(*eol, "\n")
+	if newlines == 0 {
+		newlines = strings.Count(*eol, "\r")
+	}
+	if newlines > 1 {
+		f.blank = true
+	}
+}
+
+func (f *formatter) comment(c string) {
+	f.write(strings.TrimRight(c, " \t\r"))
+}
+
+// breaks writes the line breaks and comments that follow an operator. A
+// comment before the first line break stays on the operator's line; the
+// operand goes on a continuation line.
+func (f *formatter) breaks(breaks []string) {
+	if len(breaks) == 0 {
+		f.write(" ")
+		return
+	}
+	for i, b := range breaks {
+		if !strings.HasPrefix(b, "//") {
+			continue
+		}
+		if i == 0 {
+			f.write(" ")
+		} else {
+			f.startLine(f.base + 1)
+		}
+		f.comment(b)
+	}
+	f.startLine(f.base + 1)
+}
+
+func (p Program) format(f *formatter) {
+	var prev *Command
+	for _, c := range p.Commands {
+		switch {
+		case c.Scriptor != nil:
+			f.startLine(0)
+			f.write(*c.Scriptor)
+		case c.Comment != nil:
+			if prev != nil && prev.endLine() == c.Comment.Pos.Line {
+				f.write(" ")
+			} else {
+				f.startLine(0)
+			}
+			f.comment(c.Comment.Comment)
+			f.eol(c.Comment.End)
+		case c.EOL != nil:
+			f.eol(c.EOL)
+		case c.Import != nil:
+			f.startLine(0)
+			f.base = 0
+			f.write(c.Import.String())
+		case c.NamedFunction != nil:
+			f.startLine(0)
+			f.base = 0
+			c.NamedFunction.format(f)
+		case c.TestBlock != nil:
+			f.startLine(0)
+			f.base = 0
+			c.TestBlock.format(f)
+		case c.Expression != nil:
+			f.startLine(0)
+			f.base = 0
+			c.Expression.format(f)
+			if c.Trailing != nil {
+				f.write(" ")
+				f.comment(*c.Trailing)
+			}
+			f.eol(c.End)
+		}
+		prev = c
+	}
+}
+
+// endLine returns the line a function, import or test block ends on, which a comment
+// following it on the same line belongs to.
+func (c *Command) endLine() int {
+	switch {
+	case c.NamedFunction != nil:
+		return c.NamedFunction.EndPos.Line
+	case c.Import != nil:
+		return c.Import.EndPos.Line
+	case c.TestBlock != nil:
+		return c.TestBlock.EndPos.Line
+	}
+	return -1
+}
+
+func (nf NamedFunction) format(f *formatter) {
+	f.write("fn ")
+	if nf.Name != nil {
+		f.write(*nf.Name)
+	}
+	f.write("(" + strings.Join(nf.Params, ", ") + ") ")
+	nf.Body.format(f)
+}
+
+func (t TestBlock) format(f *formatter) {
+	f.write("#test ")
+	if t.Name != nil {
+		f.write(strconv.Quote(*t.Name) + " ")
+	}
+	t.Body.format(f)
+}
+
+func (uf UnnamedFunction) format(f *formatter) {
+	f.write("fn(" + strings.Join(uf.Params, ", ") + ") ")
+	uf.Body.format(f)
+}
+
+func (rb RequiredBlock) format(f *formatter) {
+	empty := true
+	for _, line := range rb.Lines {
+		if line.EOL == nil {
+			empty = false
+		}
+	}
+	if empty {
+		f.write("{}")
+		return
+	}
+
+	f.write("{")
+	open := f.line
+	base := f.base
+	f.blank = false
+
+	lastLine := 0
+	for _, line := range rb.Lines {
+		switch {
+		case line.Statement != nil:
+			f.startLine(open + 1)
+			f.base = open + 1
+			line.Statement.format(f)
+			lastLine = line.Statement.EndPos.Line
+		case line.Comment != nil:
+			if line.Pos.Line == lastLine {
+				f.write(" ")
+			} else {
+				f.startLine(open + 1)
+			}
+			f.comment(*line.Comment)
+			f.eol(line.CommentEnd)
+		case line.EOL != nil:
+			f.eol(line.EOL)
+		}
+	}
+
+	f.blank = false
+	f.startLine(open)
+	f.write("}")
+	f.base = base
+}
+
+func (e Expression) format(f *formatter) {
+	if e.Assignment != nil {
+		e.Assignment.format(f)
+	}
+}
+
+func (a Assignment) format(f *formatter) {
+	a.Pipe.format(f)
+	if a.Operation != nil {
+		f.write(" " + a.Operation.Op)
+		f.breaks(a.Operation.Breaks)
+		a.Operation.Operand.format(f)
+	}
+}
+
+func (p Pipe) format(f *formatter) {
+	p.Logical.format(f)
+	for _, op := range p.Operations {
+		f.write(" " + op.Op)
+		f.breaks(op.Breaks)
+		op.Operand.format(f)
+	}
+}
+
+func (l Logical) format(f *formatter) {
+	l.Comparison.format(f)
+	for _, op := range l.Operations {
+		f.write(" " + op.Op)
+		f.breaks(op.Breaks)
+		op.Operand.format(f)
+	}
+}
+
+func (c Comparison) format(f *formatter) {
+	c.Series.format(f)
+	for _, op := range c.Operations {
+		f.write(" " + op.Op)
+		f.breaks(op.Breaks)
+		op.Operand.format(f)
+	}
+}
+
+func (s Series) format(f *formatter) {
+	s.FromValue.format(f)
+	if s.ToValue != nil {
+		f.write("..")
+		s.ToValue.format(f)
+	}
+}
+
+func (kv KeyValue) format(f *formatter) {
+	kv.Addition.format(f)
+	if kv.RightValue != nil {
+		f.write(":")
+		f.breaks(kv.Breaks)
+		kv.RightValue.format(f)
+	}
+}
+
+func (a Addition) format(f *formatter) {
+	a.Multiplication.format(f)
+	for _, op := range a.Operations {
+		f.write(" " + op.Op)
+		f.breaks(op.Breaks)
+		op.Operand.format(f)
+	}
+}
+
+func (m Multiplication) format(f *formatter) {
+	m.Unary.format(f)
+	for _, op := range m.Operations {
+		f.write(" " + op.Op)
+		f.breaks(op.Breaks)
+		op.Operand.format(f)
+	}
+}
+
+func (u Unary) format(f *formatter) {
+	if u.Op != nil {
+		f.write(*u.Op)
+	}
+	if u.Unary != nil {
+		u.Unary.format(f)
+	}
+	if u.Base != nil {
+		u.Base.format(f)
+	}
+}
+
+func (b Base) format(f *formatter) {
+	switch {
+	case b.Bool != nil:
+		f.write(*b.Bool)
+	case b.Float != nil:
+		f.write(formatFloat(*b.Float))
+	case b.Integer != nil:
+		f.write(strconv.FormatInt(*b.Integer, 10))
+	case b.Tag != nil:
+		f.write(*b.Tag)
+	case b.Ident != nil:
+		f.write(b.String())
+	case b.StringValue != nil:
+		f.write(strconv.Quote(*b.StringValue))
+	case b.Subexpression != nil:
+		f.write("(")
+		b.Subexpression.format(f)
+		f.write(")")
+	case b.List != nil:
+		b.List.format(f)
+	case b.Invocation != nil:
+		b.Invocation.format(f)
+	case b.UnnamedFunction != nil:
+		b.UnnamedFunction.format(f)
+	}
+}
+
+func (i Invocation) format(f *formatter) {
+	if i.Module != nil {
+		f.write(*i.Module + ".")
+	}
+	f.write(*i.Name + "(")
+	for j, arg := range i.Arguments {
+		if j > 0 {
+			f.write(", ")
+		}
+		arg.format(f)
+	}
+	f.write(")")
+}
+
+// format writes the list on one line, unless it was written over several
+// lines, in which case each item goes on its own line.
+func (l List) format(f *formatter) {
+	multiline := len(l.Breaks) > 0
+	for _, item := range l.Items {
+		if len(item.Breaks) > 0 {
+			multiline = true
+		}
+	}
+
+	if !multiline {
+		f.write("[")
+		for i, item := range l.Items {
+			if i > 0 {
+				f.write(", ")
+			}
+			item.Value.format(f)
+		}
+		f.write("]")
+		return
+	}
+
+	f.write("[")
+	open := f.line
+	base := f.base
+	for i, item := range l.Items {
+		if i > 0 {
+			f.write(",")
+		}
+		listComments(f, item.Breaks, open+1)
+		f.startLine(open + 1)
+		f.base = open + 1
+		item.Value.format(f)
+	}
+	listComments(f, l.Breaks, open+1)
+	f.startLine(open)
+	f.write("]")
+	f.base = base
+}
+
+// listComments writes the comments among the line breaks between list items.
+// A comment before the first line break stays on the previous line.
+func listComments(f *formatter, breaks []string, indent int) {
+	for i, b := range breaks {
+		if !strings.HasPrefix(b, "//") {
+			continue
+		}
+		if i == 0 {
+			f.write(" ")
+		} else {
+			f.startLine(indent)
+		}
+		f.comment(b)
+	}
+}
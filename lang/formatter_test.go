@@ -0,0 +1,46 @@
+package lang
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestFormat(t *testing.T) {
+	for _, tt := range []struct {
+		name, src, want string
+	}{
+		{"spacing", "x:=1+2*3\n", "x := 1 + 2 * 3\n"},
+		{"lists", "x := [1,2,  3]\ny := [\"a\":1]\n", "x := [1, 2, 3]\ny := [\"a\": 1]\n"},
+		{"comments and blank lines", "// leading\n\n\nx := 1 // trailing\n\n\n\ny := 2\n", "// leading\n\nx := 1 // trailing\n\ny := 2\n"},
+		{"blocks", "fn f(a,b){\na+b\n}\n", "fn f(a, b) {\n    a + b\n}\n"},
+		{"reindented", "fn f(a) {\n        b := 1\n   a\n}\n", "fn f(a) {\n    b := 1\n    a\n}\n"},
+		{"test blocks", "#test \"t\" {\nassert_eq(1,1)\n}\n", "#test \"t\" {\n    assert_eq(1, 1)\n}\n"},
+		{"continued pipeline", "1..10 >> fn(x) { x * 2 } >>\n  fn(y) { y }\n", "1..10 >> fn(x) {\n    x * 2\n} >>\n    fn(y) {\n        y\n    }\n"},
+		{"comment in continuation", "x := 1 +\n// why\n2\n", "x := 1 +\n    // why\n    2\n"},
+		{"members and operators", "r.a.b[0]  :=  -x\n!true && false || (1 < 2)\n", "r.a.b[0] := -x\n!true && false || (1 < 2)\n"},
+		{"trailing space and CRLF", "x := 1   \r\n", "x := 1\n"},
+		{"empty", "", ""},
+	} {
+		got, err := Format("t.roz", []byte(tt.src))
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if string(got) != tt.want {
+			t.Errorf("%s: got\n%q\nwant\n%q", tt.name, got, tt.want)
+			continue
+		}
+		again, err := Format("t.roz", got)
+		if err != nil || string(again) != tt.want {
+			t.Errorf("%s: formatting again gives %q, %v", tt.name, again, err)
+		}
+	}
+}
+
+func TestFormatSyntaxError(t *testing.T) {
+	_, err := Format("t.roz", []byte("x := (1 +\n"))
+	var diagnostics Diagnostics
+	if !errors.As(err, &diagnostics) || len(diagnostics) == 0 {
+		t.Errorf("got error %v, want diagnostics", err)
+	}
+}
@@ -0,0 +1,85 @@
+package lang
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fuzzLimits keep generated programs small and quick.
+var fuzzLimits = Limits{
+	MaxSteps:     10_000,
+	MaxDepth:     50,
+	MaxWallTime:  time.Second,
+	MaxHeldItems: 10_000,
+}
+
+// addSeeds adds the sample programs to the corpus of f.
+func addSeeds(f *testing.F) {
+	samples, _ := filepath.Glob("../*.roz")
+	more, _ := filepath.Glob("testdata/*.roz")
+	for _, sample := range append(samples, more...) {
+		src, err := os.ReadFile(sample)
+		if err == nil {
+			f.Add(string(src))
+		}
+	}
+	for _, src := range []string{
+		"",
+		"fnord := 1\nfnord\n",
+		"TRUE && False\n",
+		"x := [1, \"a\": 2]\nx\n",
+		"0..3 >> fn(x) {\n    x * 2\n}\n",
+		"!5\n-\"a\"\n",
+		"2024-01-01T10:00:00\n12:30:00\n3d\n",
+	} {
+		f.Add(src)
+	}
+}
+
+func FuzzParse(f *testing.F) {
+	addSeeds(f)
+	f.Fuzz(func(t *testing.T, src string) {
+		program, diagnostics := ParseAll("fuzz.roz", []byte(src))
+		for _, d := range diagnostics {
+			d.Render([]byte(src))
+		}
+		if program == nil {
+			return
+		}
+
+		// formatting a program gives a program that parses to the same
+		// thing.
+		formatted := program.Format()
+		again, diagnostics := ParseAll("fuzz.roz", []byte(formatted))
+		if len(diagnostics) > 0 {
+			t.Fatalf("formatted program does not parse: %s\n%s", diagnostics, formatted)
+		}
+		if again.Format() != formatted {
+			t.Fatalf("formatting is not idempotent:\n%s\n%s", formatted, again.Format())
+		}
+	})
+}
+
+func FuzzExecute(f *testing.F) {
+	addSeeds(f)
+	f.Fuzz(func(t *testing.T, src string) {
+		if strings.Contains(src, "import") {
+			// imports read files.
+			return
+		}
+		program, diagnostics := ParseAll("fuzz.roz", []byte(src))
+		if len(diagnostics) > 0 {
+			return
+		}
+		pe, errs := program.CompileWith(TypeMap{}, NewLoader())
+		if errs.Len() > 0 {
+			CompileDiagnostics(errs).Render([]byte(src))
+			return
+		}
+		pe.ExecuteProgramContext(context.Background(), fuzzLimits)
+	})
+}
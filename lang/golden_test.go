@@ -0,0 +1,122 @@
+package lang
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files in testdata/golden")
+
+// goldenLimits keeps a runaway sample from hanging the tests.
+var goldenLimits = Limits{
+	MaxSteps:    1_000_000,
+	MaxWallTime: 10 * time.Second,
+}
+
+// TestGolden parses, compiles and runs each sample program, the .roz files at
+// the top of the repository and in testdata, and compares what happens at
+// each stage with testdata/golden/<name>.golden. Run with -update to rewrite
+// the golden files after an intended change.
+func TestGolden(t *testing.T) {
+	samples, err := filepath.Glob("../*.roz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	more, err := filepath.Glob("testdata/*.roz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	samples = append(samples, more...)
+	if len(samples) == 0 {
+		t.Fatal("no samples found")
+	}
+
+	for _, sample := range samples {
+		name := strings.TrimSuffix(filepath.Base(sample), ".roz")
+		t.Run(name, func(t *testing.T) {
+			src, err := os.ReadFile(sample)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got := golden(filepath.Base(sample), src)
+
+			path := filepath.Join("testdata", "golden", name+".golden")
+			if *update {
+				err := os.WriteFile(path, []byte(got), 0644)
+				if err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+
+			want, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatalf("%s (run with -update to create it)", err)
+			}
+			if got != string(want) {
+				t.Errorf("%s differs from %s (run with -update if the change is intended)\ngot:\n%s", sample, path, got)
+			}
+		})
+	}
+}
+
+// golden returns the snapshot of parsing, compiling and running src, each
+// stage in its own section. Stages after a failing stage are left out.
+func golden(filename string, src []byte) string {
+	out := &strings.Builder{}
+	section := func(title string) {
+		fmt.Fprintf(out, "-- %s --\n", title)
+	}
+
+	program, diagnostics := ParseAll(filename, src)
+	if len(diagnostics) > 0 {
+		section("parse errors")
+		out.WriteString(diagnostics.Render(src))
+		return out.String()
+	}
+
+	section("program")
+	out.WriteString(program.String())
+
+	pe, errs := program.Compile(TypeMap{})
+	if errs.Len() > 0 {
+		section("compile errors")
+		out.WriteString(CompileDiagnostics(errs).Render(src))
+		return out.String()
+	}
+
+	section("functions")
+	pe.DumpFunctions(out)
+
+	section("commands")
+	pe.DumpProgram(out)
+
+	section("result")
+	result, err := pe.ExecuteProgramContext(context.Background(), goldenLimits)
+	if err != nil {
+		fmt.Fprintf(out, "error: %s\n", err)
+	} else {
+		fmt.Fprintln(out, FormatValue(result))
+	}
+
+	if cases := pe.TestCases(); len(cases) > 0 {
+		section("tests")
+		for _, tc := range cases {
+			r := pe.RunTest(context.Background(), goldenLimits, tc, nil)
+			if r.Err != nil {
+				fmt.Fprintf(out, "FAIL %s: %s\n", r.Name, r.Err)
+			} else {
+				fmt.Fprintf(out, "ok   %s\n", r.Name)
+			}
+		}
+	}
+
+	return out.String()
+}
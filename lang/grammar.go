@@ -19,18 +19,33 @@ type Command struct {
 	Scriptor      *string        `parser:"  @Scriptor "`
 	Comment       *Comment       `parser:"| @@ "`
 	EOL           *string        `parser:"| @EOL "`
+	Import        *Import        `parser:"| @@ "` // imports only allowed at top level
 	NamedFunction *NamedFunction `parser:"| @@ "` // named functions only allowed at top level
-	Expression    *Expression    `parser:"| @@ (EOL|EOF|(Comment (EOL|EOF))) "`
+	TestBlock     *TestBlock     `parser:"| @@ "` // test blocks only allowed at top level
+	Expression    *Expression    `parser:"| @@ "`
+	Trailing      *string        `parser:"  @Comment? "`
+	End           *string        `parser:"  (@EOL|EOF) "`
 }
 
 type Comment struct {
 	Pos lexer.Position
 
-	Comment string `parser:" @Comment (EOL|EOF) "`
+	Comment string  `parser:" @Comment "`
+	End     *string `parser:" (@EOL|EOF) "`
+}
+
+// Import makes the named functions of another file callable as alias.name.
+type Import struct {
+	Pos    lexer.Position
+	EndPos lexer.Position
+
+	Path  string `parser:" 'import' @String "`
+	Alias string `parser:" 'as' @Ident "`
 }
 
 type NamedFunction struct {
-	Pos lexer.Position
+	Pos    lexer.Position
+	EndPos lexer.Position
 
 	Func   *string        `parser:" @Function "`
 	Name   *string        `parser:" @Ident "`
@@ -38,14 +53,27 @@ type NamedFunction struct {
 	Body   *RequiredBlock `parser:"@@"`
 }
 
+// TestBlock is a block of assertions, run by rozer test and skipped when the
+// program is run.
+type TestBlock struct {
+	Pos    lexer.Position
+	EndPos lexer.Position
+
+	Tag  string         `parser:" @'#test' "`
+	Name *string        `parser:" @String? "`
+	Body *RequiredBlock `parser:" @@ "`
+}
+
 type Expression struct {
-	Pos lexer.Position
+	Pos    lexer.Position
+	EndPos lexer.Position
 
 	Assignment *Assignment `parser:"@@"`
 }
 
 type Assignment struct {
-	Pos lexer.Position
+	Pos    lexer.Position
+	EndPos lexer.Position
 
 	Pipe      *Pipe         `parser:"@@"`
 	Operation *OpAssignment `parser:"@@?"`
@@ -54,8 +82,9 @@ type Assignment struct {
 type OpAssignment struct {
 	Pos lexer.Position
 
-	Op      string `parser:"@( ColonEqual | PlusEqual ) (EOL|Comment EOL)*"`
-	Operand *Pipe  `parser:"@@"`
+	Op      string   `parser:"@( ColonEqual | PlusEqual )"`
+	Breaks  []string `parser:"(@EOL|@Comment EOL)*"`
+	Operand *Pipe    `parser:"@@"`
 }
 
 type Pipe struct {
@@ -68,12 +97,14 @@ type Pipe struct {
 type OpPipe struct {
 	Pos lexer.Position
 
-	Op      string   `parser:"@( MoreMore | MoreMoreMore ) (EOL|Comment EOL)*"`
+	Op      string   `parser:"@( MoreMore | MoreMoreMore )"`
+	Breaks  []string `parser:"(@EOL|@Comment EOL)*"`
 	Operand *Logical `parser:"@@"`
 }
 
 type Logical struct {
-	Pos lexer.Position
+	Pos    lexer.Position
+	EndPos lexer.Position
 
 	Comparison *Comparison  `parser:"@@"`
 	Operations []*OpLogical `parser:"@@*"`
@@ -82,12 +113,14 @@ type Logical struct {
 type OpLogical struct {
 	Pos lexer.Position
 
-	Op      string      `parser:"@( AndAnd | OrOr ) (EOL|Comment EOL)*"`
+	Op      string      `parser:"@( AndAnd | OrOr )"`
+	Breaks  []string    `parser:"(@EOL|@Comment EOL)*"`
 	Operand *Comparison `parser:"@@"`
 }
 
 type Comparison struct {
-	Pos lexer.Position
+	Pos    lexer.Position
+	EndPos lexer.Position
 
 	Series     *Series         `parser:"@@"`
 	Operations []*OpComparison `parser:"@@*"`
@@ -96,8 +129,9 @@ type Comparison struct {
 type OpComparison struct {
 	Pos lexer.Position
 
-	Op      string  `parser:"@( EqualEqual | BangEqual | LessEqual | MoreEqual | Less | More ) (EOL|Comment EOL)*"`
-	Operand *Series `parser:"@@"`
+	Op      string   `parser:"@( EqualEqual | BangEqual | LessEqual | MoreEqual | Less | More )"`
+	Breaks  []string `parser:"(@EOL|@Comment EOL)*"`
+	Operand *Series  `parser:"@@"`
 }
 
 // Series parses a n..m series.
@@ -110,14 +144,18 @@ type Series struct {
 
 // KeyValue parses a key-value pair. If there is no colon, then it's just a value.
 type KeyValue struct {
-	Pos lexer.Position
+	Pos    lexer.Position
+	EndPos lexer.Position
 
 	Addition   *Addition `parser:"@@"`
-	RightValue *Addition `parser:"( Colon (EOL|Comment EOL)* @@ )?"`
+	Colon      *string   `parser:"( @Colon"`
+	Breaks     []string  `parser:"  (@EOL|@Comment EOL)*"`
+	RightValue *Addition `parser:"  @@ )?"`
 }
 
 type Addition struct {
-	Pos lexer.Position
+	Pos    lexer.Position
+	EndPos lexer.Position
 
 	Multiplication *Multiplication `parser:"@@"`
 	Operations     []*OpAddition   `parser:"@@*"`
@@ -126,12 +164,14 @@ type Addition struct {
 type OpAddition struct {
 	Pos lexer.Position
 
-	Op      string          `parser:"@( Plus | Minus ) (EOL|Comment EOL)*"`
+	Op      string          `parser:"@( Plus | Minus )"`
+	Breaks  []string        `parser:"(@EOL|@Comment EOL)*"`
 	Operand *Multiplication `parser:"@@"`
 }
 
 type Multiplication struct {
-	Pos lexer.Position
+	Pos    lexer.Position
+	EndPos lexer.Position
 
 	Unary      *Unary              `parser:"@@"`
 	Operations []*OpMultiplication `parser:"@@*"`
@@ -140,12 +180,14 @@ type Multiplication struct {
 type OpMultiplication struct {
 	Pos lexer.Position
 
-	Op      string `parser:"@( Star | Slash | Percent ) (EOL|Comment EOL)*"`
-	Operand *Unary `parser:"@@"`
+	Op      string   `parser:"@( Star | Slash | Percent )"`
+	Breaks  []string `parser:"(@EOL|@Comment EOL)*"`
+	Operand *Unary   `parser:"@@"`
 }
 
 type Unary struct {
-	Pos lexer.Position
+	Pos    lexer.Position
+	EndPos lexer.Position
 
 	Op    *string `parser:"  ( @( Bang | Minus )"`
 	Unary *Unary  `parser:"    @@ )"`
@@ -153,7 +195,8 @@ type Unary struct {
 }
 
 type Base struct {
-	Pos lexer.Position
+	Pos    lexer.Position
+	EndPos lexer.Position
 
 	Subexpression   *Expression      `parser:"  '(' @@ ')' "`
 	List            *List            `parser:"| @@"`
@@ -163,16 +206,27 @@ type Base struct {
 	Bool            *string          `parser:"| @('true' | 'false')"`
 	Tag             *string          `parser:"| @Tag"`
 	Ident           *string          `parser:"| @Ident "`
+	Members         []*Member        `parser:"  @@* "`
 	// DateTime      *string      `parser:"| @DateTime"`
 	// Date          *string      `parser:"| @Date"`
 	// Time          *string      `parser:"| @Time"`
 	Float   *float64 `parser:"| @Float"`
 	Integer *int64   `parser:"| @Integer"`
 	// TimeSpan      *string      `parser:"| @TimeSpan"`
-	// DottedIdent   *DottedIdent `parser:"| @@"`
 	// StatementBlock  *StatementBlock  `parser:"| '{' (Comment EOL|EOL)* @@ (Comment EOL|EOL)* '}' (EOF|EOL|Comment EOL)* "`
 }
 
+// Member is a field or index of a value, as in row.name, items[2] or
+// items[*], or a function of an imported module, as in util.clean.
+type Member struct {
+	Pos    lexer.Position
+	EndPos lexer.Position
+
+	Field    *string     `parser:"  '.' @Ident "`
+	Wildcard bool        `parser:"| '[' ( @'*' "`
+	Index    *Expression `parser:"      | @@ ) ']' "`
+}
+
 type UnnamedFunction struct {
 	Pos lexer.Position
 
@@ -184,6 +238,7 @@ type UnnamedFunction struct {
 type Invocation struct {
 	Pos lexer.Position
 
+	Module    *string       `parser:" ( @Ident '.' )? "`
 	Name      *string       `parser:" @Ident "`
 	Arguments []*Expression `parser:" '(' @@? ( ',' @@ )* ')' "`
 }
@@ -191,9 +246,19 @@ type Invocation struct {
 type RequiredBlock struct {
 	Pos lexer.Position
 
-	LeftBrace  *string       `parser:" @'{' "`
-	Statements []*Expression `parser:" (@@ | Comment EOL | EOL)* "`
-	RightBrace *string       `parser:" @'}' "`
+	LeftBrace  *string      `parser:" @'{' "`
+	Lines      []*BlockLine `parser:" @@* "`
+	RightBrace *string      `parser:" @'}' "`
+}
+
+// BlockLine is a statement, comment or line break within a block.
+type BlockLine struct {
+	Pos lexer.Position
+
+	Statement  *Expression `parser:"  @@ "`
+	Comment    *string     `parser:"| @Comment "`
+	CommentEnd *string     `parser:"  @EOL "`
+	EOL        *string     `parser:"| @EOL "`
 }
 
 type StatementBlock struct {
@@ -205,5 +270,14 @@ type StatementBlock struct {
 type List struct {
 	Pos lexer.Position
 
-	Items []*Expression `parser:"'[' (EOL|Comment EOL)* @@? ( ',' (EOL|Comment EOL)* @@ )* (EOL|Comment EOL)* ']' "`
+	Items  []*ListItem `parser:"'[' @@? ( ',' @@ )* "`
+	Breaks []string    `parser:"(@EOL|@Comment EOL)* ']' "`
+}
+
+// ListItem is a list element, with any line breaks and comments before it.
+type ListItem struct {
+	Pos lexer.Position
+
+	Breaks []string    `parser:"(@EOL|@Comment EOL)*"`
+	Value  *Expression `parser:"@@"`
 }
@@ -1,6 +1,8 @@
 package lang
 
-import "fmt"
+import (
+	"fmt"
+)
 
 func (p Program) String() string {
 	s := ""
@@ -23,8 +25,12 @@ func (c Command) String() string {
 		return ""
 	case c.Expression != nil:
 		return c.Expression.String()
+	case c.Import != nil:
+		return c.Import.String()
 	case c.NamedFunction != nil:
 		return c.NamedFunction.String()
+	case c.TestBlock != nil:
+		return c.TestBlock.String()
 	default:
 		return fmt.Sprintf("*error in Command.String with %#v *", c)
 	}
@@ -51,12 +57,14 @@ func (b Base) String() string {
 		return fmt.Sprintf("%d", *b.Integer)
 	// case b.TimeSpan != nil:
 	// 	return *b.TimeSpan
-	// case b.DottedIdent != nil:
-	// 	return b.DottedIdent.String()
 	case b.Tag != nil:
 		return *b.Tag
 	case b.Ident != nil:
-		return *b.Ident
+		s := *b.Ident
+		for _, m := range b.Members {
+			s += m.String()
+		}
+		return s
 	case b.StringValue != nil:
 		return fmt.Sprintf("%#v", *b.StringValue)
 	case b.Subexpression != nil:
@@ -86,6 +94,10 @@ func (f UnnamedFunction) String() string {
 	return s
 }
 
+func (i Import) String() string {
+	return fmt.Sprintf("import %q as %s", i.Path, i.Alias)
+}
+
 func (f NamedFunction) String() string {
 	s := "fn "
 	if f.Name != nil {
@@ -102,6 +114,14 @@ func (f NamedFunction) String() string {
 	return s
 }
 
+func (t TestBlock) String() string {
+	s := "#test "
+	if t.Name != nil {
+		s += fmt.Sprintf("%q ", *t.Name)
+	}
+	return s + t.Body.String()
+}
+
 func (s StatementBlock) String() string {
 	s1 := "{\n"
 	for _, stmt := range s.Statements {
@@ -112,8 +132,10 @@ func (s StatementBlock) String() string {
 
 func (rb RequiredBlock) String() string {
 	s1 := "{\n"
-	for _, stmt := range rb.Statements {
-		s1 += "    " + stmt.String() + "\n"
+	for _, line := range rb.Lines {
+		if line.Statement != nil {
+			s1 += "    " + line.Statement.String() + "\n"
+		}
 	}
 	return s1 + "}"
 }
@@ -131,7 +153,7 @@ func (l List) String() string {
 		if i > 0 {
 			s += ", "
 		}
-		s += item.String()
+		s += item.Value.String()
 	}
 	return s + "]"
 }
@@ -233,6 +255,9 @@ func (x Unary) String() string {
 
 func (i Invocation) String() string {
 	s := *i.Name
+	if i.Module != nil {
+		s = *i.Module + "." + s
+	}
 	s += "("
 	for j, arg := range i.Arguments {
 		if j > 0 {
@@ -243,3 +268,14 @@ func (i Invocation) String() string {
 	s += ")"
 	return s
 }
+
+func (m Member) String() string {
+	switch {
+	case m.Field != nil:
+		return "." + *m.Field
+	case m.Wildcard:
+		return "[*]"
+	default:
+		return "[" + m.Index.String() + "]"
+	}
+}
@@ -0,0 +1,87 @@
+package lang
+
+import (
+	"encoding/binary"
+	"hash"
+	"hash/fnv"
+	"math"
+)
+
+// The kinds of hashed values.
+const (
+	hashedNil byte = iota
+	hashedBool
+	hashedInteger
+	hashedFloat
+	hashedString
+	hashedIdentifier
+	hashedTag
+	hashedKeyValue
+	hashedRecord
+	hashedList
+	hashedOther
+)
+
+// Hash returns a hash of a value, from its structure: Equal values have
+// equal hashes. The hash of a value is the same in every run of every
+// program, so it may be kept.
+func Hash(v any) uint64 {
+	h := fnv.New64a()
+	hashValue(h, v)
+	return h.Sum64()
+}
+
+func hashValue(h hash.Hash64, v any) {
+	switch v := v.(type) {
+	case nil:
+		h.Write([]byte{hashedNil})
+	case BoolValue:
+		b := byte(0)
+		if v {
+			b = 1
+		}
+		h.Write([]byte{hashedBool, b})
+	case IntegerValue:
+		h.Write(binary.LittleEndian.AppendUint64([]byte{hashedInteger}, uint64(v)))
+	case FloatValue:
+		if v == 0 {
+			// -0 is equal to 0.
+			v = 0
+		}
+		h.Write(binary.LittleEndian.AppendUint64([]byte{hashedFloat}, math.Float64bits(float64(v))))
+	case StringValue:
+		hashString(h, hashedString, string(v))
+	case IdentifierValue:
+		hashString(h, hashedIdentifier, v.Value)
+	case TagValue:
+		hashString(h, hashedTag, v.Value)
+	case KeyValueResult:
+		h.Write([]byte{hashedKeyValue})
+		hashValue(h, v.Key)
+		hashValue(h, v.Value)
+	case RowValue:
+		hashValue(h, v.Record())
+	case RecordValue:
+		h.Write(binary.AppendUvarint([]byte{hashedRecord}, uint64(v.Len())))
+		v.Each(func(_ int, name string, value any) bool {
+			hashString(h, hashedString, name)
+			hashValue(h, ValueFromRoze(value))
+			return true
+		})
+	case BatchValue:
+		hashValue(h, v.rows())
+	case ListResult:
+		h.Write(binary.AppendUvarint([]byte{hashedList}, uint64(len(v.Items))))
+		for _, item := range v.Items {
+			hashValue(h, item)
+		}
+	default:
+		// functions and modules are equal to nothing, so any hash will do.
+		h.Write([]byte{hashedOther})
+	}
+}
+
+func hashString(h hash.Hash64, kind byte, s string) {
+	h.Write(binary.AppendUvarint([]byte{kind}, uint64(len(s))))
+	h.Write([]byte(s))
+}
@@ -0,0 +1,325 @@
+package lang
+
+import (
+	"bufio"
+	"bytes"
+
+	"github.com/alecthomas/participle/v2/lexer"
+	"github.com/pdk/rozer"
+)
+
+func init() {
+	registerBuiltin(BuiltinFunction{
+		Name:   "join",
+		Params: []string{"other", "left_key", "right_key", "kind"},
+		Func: func(ee *ExecutionEnvironment) ExecutionResult {
+			return newJoinStage(ee, StringValue("left"))
+		},
+	})
+	registerBuiltin(BuiltinFunction{
+		Name:   "join_with",
+		Params: []string{"other", "left_key", "right_key", "kind", "on_conflict"},
+		Func: func(ee *ExecutionEnvironment) ExecutionResult {
+			return newJoinStage(ee, ee.Get("on_conflict"))
+		},
+	})
+}
+
+func newJoinStage(ee *ExecutionEnvironment, onConflict ExecutionResult) JoinStage {
+	js := JoinStage{Other: ee.Get("other")}
+	_, isList := js.Other.(ListResult)
+	fn, isFunction := js.Other.(Parameterized)
+	if !isList && (!isFunction || len(fn.ParameterNames()) != 0) {
+		runtimeErrorf(lexer.Position{}, "join: expecting a list or a function of no arguments to join with, got %s", FormatValue(js.Other))
+	}
+
+	for _, key := range []struct {
+		name string
+		fn   *Parameterized
+	}{{"left_key", &js.LeftKey}, {"right_key", &js.RightKey}} {
+		fn, ok := ee.Get(key.name).(Parameterized)
+		if !ok || len(fn.ParameterNames()) != 1 {
+			runtimeErrorf(lexer.Position{}, "join: expecting a %s function of 1 argument, got %s", key.name, FormatValue(ee.Get(key.name)))
+		}
+		*key.fn = fn
+	}
+
+	kind, _ := ee.Get("kind").(StringValue)
+	switch kind {
+	case "inner", "left", "anti":
+		js.Kind = string(kind)
+	default:
+		runtimeErrorf(lexer.Position{}, "join: kind must be \"inner\", \"left\" or \"anti\", got %s", FormatValue(ee.Get("kind")))
+	}
+
+	switch onConflict := onConflict.(type) {
+	case StringValue:
+		if onConflict != "left" && onConflict != "right" && onConflict != "error" {
+			runtimeErrorf(lexer.Position{}, "join: on_conflict must be \"left\", \"right\", \"error\" or a function, got %s", FormatValue(onConflict))
+		}
+		js.Conflict = string(onConflict)
+	case Parameterized:
+		if len(onConflict.ParameterNames()) != 3 {
+			runtimeErrorf(lexer.Position{}, "join: expecting an on_conflict function of 3 arguments, got %s", FormatValue(onConflict))
+		}
+		js.Resolve = onConflict
+	default:
+		runtimeErrorf(lexer.Position{}, "join: on_conflict must be \"left\", \"right\", \"error\" or a function, got %s", FormatValue(onConflict))
+	}
+	return js
+}
+
+// JoinStage is a pipeline stage that joins its records with those of Other,
+// a list or a function called for each item until it returns a tag, as a
+// pipeline source is. Records match if LeftKey, applied to a record of the
+// pipeline, and RightKey, applied to one of Other, give equal keys; a #null
+// key matches nothing.
+//
+// An inner join yields a record merging each pair of matching records. A
+// left join does too, and yields the records of the pipeline that match none
+// as they are. An anti join yields only the records of the pipeline that
+// match none. The records are yielded in the order of the pipeline, and the
+// matches of each in the order of Other.
+//
+// A merged record has the fields of the left record, then those of the right
+// one. Conflict says which value a field both have keeps: "left", "right", or
+// "error" to fail. If Resolve is set instead, it is called with the name and
+// both values, and returns the value to keep.
+//
+// Other is read in full first. Then whichever side has fewer records is
+// hashed by key, reading the pipeline only as far as needed to tell.
+type JoinStage struct {
+	Other    ExecutionResult
+	LeftKey  Parameterized
+	RightKey Parameterized
+	Kind     string
+	Conflict string
+	Resolve  Parameterized
+}
+
+func (js JoinStage) Apply(ee *ExecutionEnvironment) ExecutionResult {
+	list, ok := ee.Get("items").(ListResult)
+	if !ok {
+		runtimeErrorf(lexer.Position{}, "join: expecting a list, got %s", FormatValue(ee.Get("items")))
+	}
+	items, _ := drainStream(js.Stream(ee, listStream(list), nil))
+	return ListResult{Items: items}
+}
+
+func (js JoinStage) ParameterNames() []string {
+	return []string{"items"}
+}
+
+func (js JoinStage) Execute(ee *ExecutionEnvironment) ExecutionResult {
+	return js
+}
+
+func (js JoinStage) Type(typeMap TypeMap) Type {
+	return TypeFunction
+}
+
+func (js JoinStage) ListRep() []any {
+	var onConflict any = js.Conflict
+	if js.Resolve != nil {
+		onConflict = js.Resolve.ListRep()
+	}
+	return []any{"join", js.LeftKey.ListRep(), js.RightKey.ListRep(), js.Kind, onConflict}
+}
+
+// joinItem is an item of a join and its key, encoded so that equal keys are
+// equal strings. ok is false if the key is #null.
+type joinItem struct {
+	item ExecutionResult
+	key  string
+	ok   bool
+}
+
+func (js JoinStage) keyed(ee *ExecutionEnvironment, fn Parameterized, item ExecutionResult) joinItem {
+	k := keyOf(ee, fn, item)
+	if isNull(k.key) {
+		return joinItem{item: item}
+	}
+	var buf bytes.Buffer
+	out := bufio.NewWriter(&buf)
+	if err := encodeSpilled(out, k.key); err != nil {
+		runtimeErrorf(lexer.Position{}, "join: invalid key: %s", err)
+	}
+	out.Flush()
+	return joinItem{item, buf.String(), true}
+}
+
+// hashJoin indexes items by key.
+func hashJoin(items []joinItem) map[string][]int {
+	index := map[string][]int{}
+	for i, item := range items {
+		if item.ok {
+			index[item.key] = append(index[item.key], i)
+		}
+	}
+	return index
+}
+
+// otherStream returns a stream of the items of Other.
+func (js JoinStage) otherStream(ee *ExecutionEnvironment) Stream {
+	if list, ok := js.Other.(ListResult); ok {
+		return listStream(list)
+	}
+	return sourceStream(ee, js.Other.(Parameterized))
+}
+
+func (js JoinStage) Stream(ee *ExecutionEnvironment, upstream Stream, done <-chan struct{}) Stream {
+	var joined Stream
+	return func() (ExecutionResult, bool) {
+		if joined == nil {
+			joined = js.join(ee, upstream)
+		}
+		return joined()
+	}
+}
+
+// join reads Other and returns the stream of joined records.
+func (js JoinStage) join(ee *ExecutionEnvironment, upstream Stream) Stream {
+	var rights []joinItem
+	others := js.otherStream(ee)
+	for {
+		item, ok := others()
+		if !ok {
+			break
+		}
+		ee.collect(1)
+		rights = append(rights, js.keyed(ee, js.RightKey, item))
+	}
+
+	var lefts []joinItem
+	var end ExecutionResult
+	ended := false
+	for len(lefts) <= len(rights) {
+		item, ok := upstream()
+		if !ok {
+			end, ended = item, true
+			break
+		}
+		ee.collect(1)
+		lefts = append(lefts, js.keyed(ee, js.LeftKey, item))
+	}
+
+	// next returns the next record of the pipeline and the positions of its
+	// matches in rights.
+	var next func() (joinItem, []int, bool)
+	if ended {
+		// the pipeline has fewer records, so they are hashed, and each of
+		// Other looked up in turn.
+		index := hashJoin(lefts)
+		matches := make([][]int, len(lefts))
+		for j, right := range rights {
+			if right.ok {
+				for _, i := range index[right.key] {
+					matches[i] = append(matches[i], j)
+				}
+			}
+		}
+		i := 0
+		next = func() (joinItem, []int, bool) {
+			if i == len(lefts) {
+				return joinItem{item: end}, nil, false
+			}
+			i++
+			ee.release(1)
+			return lefts[i-1], matches[i-1], true
+		}
+	} else {
+		index := hashJoin(rights)
+		next = func() (joinItem, []int, bool) {
+			var left joinItem
+			if len(lefts) > 0 {
+				left, lefts = lefts[0], lefts[1:]
+				ee.release(1)
+			} else {
+				item, ok := upstream()
+				if !ok {
+					return joinItem{item: item}, nil, false
+				}
+				left = js.keyed(ee, js.LeftKey, item)
+			}
+			if !left.ok {
+				return left, nil, true
+			}
+			return left, index[left.key], true
+		}
+	}
+
+	var queue []ExecutionResult
+	return func() (ExecutionResult, bool) {
+		for len(queue) == 0 {
+			left, matches, ok := next()
+			if !ok {
+				return left.item, false
+			}
+			switch {
+			case len(matches) == 0 && js.Kind != "inner":
+				queue = append(queue, left.item)
+			case js.Kind != "anti":
+				for _, j := range matches {
+					queue = append(queue, js.merge(ee, left.item, rights[j].item))
+				}
+			}
+		}
+		item := queue[0]
+		queue = queue[1:]
+		return item, true
+	}
+}
+
+// joinRecord returns the Roze of a record being joined.
+func joinRecord(item ExecutionResult) *rozer.Roze {
+	switch item := item.(type) {
+	case RecordValue:
+		return item.Roze
+	case RowValue:
+		return item.Batch.Row(item.Row)
+	default:
+		runtimeErrorf(lexer.Position{}, "join: expecting records, got %s", FormatValue(item))
+		return nil
+	}
+}
+
+// merge returns a record of the fields of left, then those of right.
+func (js JoinStage) merge(ee *ExecutionEnvironment, left, right ExecutionResult) RecordValue {
+	merged := copyRecord(joinRecord(left))
+	for name, value := range joinRecord(right).All() {
+		if name == "" {
+			merged.Append(value)
+			continue
+		}
+		if existing, ok := merged.Lookup(name); ok {
+			value = js.resolve(ee, name, existing, value)
+		}
+		merged.Put(name, value)
+	}
+	return RecordValue{merged}
+}
+
+// resolve returns the value to keep of a field both records have.
+func (js JoinStage) resolve(ee *ExecutionEnvironment, name string, left, right any) any {
+	if js.Resolve == nil {
+		switch js.Conflict {
+		case "right":
+			return right
+		case "error":
+			runtimeErrorf(lexer.Position{}, "join: both records have a field %q", name)
+		}
+		return left
+	}
+
+	ee.step()
+	fnEnv := ee.NewLocalEnvironment()
+	params := js.Resolve.ParameterNames()
+	fnEnv.Set(params[0], StringValue(name))
+	fnEnv.Set(params[1], ValueFromRoze(left))
+	fnEnv.Set(params[2], ValueFromRoze(right))
+	value, err := RozeValue(js.Resolve.Apply(fnEnv))
+	if err != nil {
+		runtimeErrorf(lexer.Position{}, "join: field %q: %s", name, err)
+	}
+	return value
+}
@@ -0,0 +1,72 @@
+package lang
+
+import (
+	"testing"
+
+	"github.com/pdk/rozer"
+)
+
+func TestJoinStream(t *testing.T) {
+	done := make(chan struct{})
+	defer close(done)
+
+	// the other source yields ids 2, 4 and 6, and the pipeline 200 records, of
+	// which only as many are read as are needed.
+	n := 0
+	other := BuiltinFunction{Name: "evens", Func: func(ee *ExecutionEnvironment) ExecutionResult {
+		if n == 3 {
+			return TagComplete
+		}
+		n++
+		return RecordValue{rozer.NewObject().Put("id", int64(n*2)).Put("even", true)}
+	}}
+	js := JoinStage{
+		Other:    other,
+		LeftKey:  keyFn(t, "fn(r) {\n    r.id\n}\n"),
+		RightKey: keyFn(t, "fn(r) {\n    r.id\n}\n"),
+		Kind:     "left",
+		Conflict: "left",
+	}
+
+	read := 0
+	records := recordStream(200)
+	upstream := func() (ExecutionResult, bool) {
+		read++
+		return records()
+	}
+	stream := js.Stream(NewExecutionEnvironment(), upstream, done)
+
+	first, _ := stream()
+	if read > 5 {
+		t.Errorf("read %d records for the first", read)
+	}
+	if got := FormatValue(first); got != `record(["id": 1, "price": 0.5])` {
+		t.Errorf("first is %s", got)
+	}
+
+	items, end := drain(stream)
+	if end != TagComplete {
+		t.Errorf("stream ended with %v, want %v", end, TagComplete)
+	}
+	if len(items) != 199 {
+		t.Fatalf("got %d more items, want 199", len(items))
+	}
+	if got := FormatValue(items[0]); got != `record(["id": 2, "price": 1.0, "even": true])` {
+		t.Errorf("second is %s", got)
+	}
+}
+
+func TestJoinOfNonRecords(t *testing.T) {
+	js := JoinStage{
+		Other:    ListResult{Items: []any{IntegerValue(1)}},
+		LeftKey:  keyFn(t, "fn(x) {\n    x\n}\n"),
+		RightKey: keyFn(t, "fn(x) {\n    x\n}\n"),
+		Kind:     "inner",
+		Conflict: "left",
+	}
+	ee := NewExecutionEnvironment().NewLocalEnvironment()
+	ee.Set("items", ListResult{Items: []any{IntegerValue(1)}})
+	if err := runStage(func() { js.Apply(ee) }); err == nil {
+		t.Errorf("joined integers")
+	}
+}
@@ -0,0 +1,76 @@
+package lang
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+)
+
+// ValueFromJSON decodes a JSON document into language values. Arrays become
+// lists, objects become lists of key-value pairs in document order, whole
+// numbers become integers and null becomes #null.
+func ValueFromJSON(data []byte) (any, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+
+	value, err := decodeValue(dec)
+	if err != nil {
+		return nil, err
+	}
+	if dec.More() {
+		return nil, fmt.Errorf("unexpected data after JSON value at offset %d", dec.InputOffset())
+	}
+	return value, nil
+}
+
+func decodeValue(dec *json.Decoder) (any, error) {
+	token, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+
+	switch t := token.(type) {
+	case nil:
+		return TagNull, nil
+	case bool:
+		return BoolValue(t), nil
+	case string:
+		return StringValue(t), nil
+	case json.Number:
+		if i, err := t.Int64(); err == nil {
+			return IntegerValue(i), nil
+		}
+		f, err := t.Float64()
+		if err != nil {
+			return nil, err
+		}
+		return FloatValue(f), nil
+	case json.Delim:
+		list := ListResult{Items: []any{}}
+		for dec.More() {
+			var key any
+			if t == '{' {
+				k, err := dec.Token()
+				if err != nil {
+					return nil, err
+				}
+				key = StringValue(k.(string))
+			}
+
+			value, err := decodeValue(dec)
+			if err != nil {
+				return nil, err
+			}
+
+			if t == '{' {
+				value = KeyValueResult{Key: key, Value: value}
+			}
+			list.Items = append(list.Items, value)
+		}
+		// the closing delimiter
+		_, err := dec.Token()
+		return list, err
+	}
+
+	return nil, fmt.Errorf("unexpected JSON token %v", token)
+}
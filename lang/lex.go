@@ -86,10 +86,6 @@ var (
 			Name:    "More",
 			Pattern: `>`,
 		},
-		{
-			Name:    "Bang",
-			Pattern: `!`,
-		},
 		{
 			Name:    "ColonEqual",
 			Pattern: `:=`,
@@ -104,7 +100,7 @@ var (
 		},
 		{
 			Name:    "Function",
-			Pattern: `fn`,
+			Pattern: `fn\b`,
 		},
 		{
 			Name:    "FTail",
@@ -159,11 +155,11 @@ var (
 		},
 		{
 			Name:    "Float",
-			Pattern: `[-+]?\d+\.\d+`,
+			Pattern: `\d+\.\d+`,
 		},
 		{
 			Name:    "Integer",
-			Pattern: `[-+]?\d\d*`,
+			Pattern: `\d+`,
 		},
 	})
 )
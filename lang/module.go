@@ -0,0 +1,297 @@
+package lang
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/alecthomas/participle/v2/lexer"
+)
+
+// Module is a compiled file imported by a program. Its named functions run
+// with the module's own functions and imports in scope, rather than those of
+// the program calling them.
+type Module struct {
+	Path    string
+	Program ProgramExecute
+
+	globals map[string]any
+}
+
+func newModule(path string, pe ProgramExecute) *Module {
+	m := &Module{Path: path, Program: pe}
+
+	env := NewExecutionEnvironment()
+	installBuiltins(env)
+	for _, ie := range pe.Imports {
+		env.SetGlobal(ie.Alias, ie.Module)
+	}
+	for _, fe := range pe.NamedFunctions {
+		env.SetGlobal(*fe.NamedFunction.Name, ModuleFunction{fe, m})
+	}
+	m.globals = env.global
+
+	return m
+}
+
+// Function returns the named function of the module.
+func (m *Module) Function(name string) (ModuleFunction, bool) {
+	mf, ok := m.globals[name].(ModuleFunction)
+	return mf, ok
+}
+
+// environment returns an environment with the locals of ee, but the globals of
+// the module.
+func (m *Module) environment(ee *ExecutionEnvironment) *ExecutionEnvironment {
+	return &ExecutionEnvironment{
+		global: m.globals,
+		local:  ee.local,
+		run:    ee.run,
+		depth:  ee.depth,
+	}
+}
+
+// ModuleFunction is a named function of an imported module.
+type ModuleFunction struct {
+	FunctionExecute
+	Module *Module
+}
+
+func (mf ModuleFunction) Apply(ee *ExecutionEnvironment) ExecutionResult {
+	return mf.FunctionExecute.Apply(mf.Module.environment(ee))
+}
+
+func (mf ModuleFunction) Execute(ee *ExecutionEnvironment) ExecutionResult {
+	return mf
+}
+
+func (mf ModuleFunction) ListRep() []any {
+	return []any{"module function", mf.Module.Path, *mf.NamedFunction.Name}
+}
+
+// ModuleMember is a reference to a function of an imported module, such as
+// util.clean.
+type ModuleMember struct {
+	Pos    lexer.Position
+	Module string
+	Name   string
+}
+
+func (mm ModuleMember) Execute(ee *ExecutionEnvironment) ExecutionResult {
+	m, ok := ee.Get(mm.Module).(*Module)
+	if !ok {
+		runtimeErrorf(mm.Pos, "%s is not an imported module", mm.Module)
+	}
+	mf, ok := m.Function(mm.Name)
+	if !ok {
+		runtimeErrorf(mm.Pos, "module %s has no function %s", mm.Module, mm.Name)
+	}
+	return mf
+}
+
+func (mm ModuleMember) Type(typeMap TypeMap) Type {
+	return TypeFunction
+}
+
+func (mm ModuleMember) ListRep() []any {
+	return []any{"module member", mm.Module, mm.Name}
+}
+
+// compileMember compiles a reference to a function of an imported module,
+// such as util.clean, or else an access to the fields and items of a value,
+// such as row.address.city.
+func (b *Base) compileMember(typeMap TypeMap) (Executable, CompileErrors) {
+	var errs CompileErrors
+
+	if typeMap[*b.Ident] != TypeModule {
+		return b.compileFieldAccess(typeMap)
+	}
+	if len(b.Members) != 1 || b.Members[0].Field == nil {
+		return nil, errs.Append(compileErrorf(CodeImport, b.Pos, b.EndPos, "invalid module reference %s", b))
+	}
+
+	return ModuleMember{b.Pos, *b.Ident, *b.Members[0].Field}, errs
+}
+
+// ImportExecute is a compiled import.
+type ImportExecute struct {
+	*Import
+	Module *Module
+}
+
+// Loader finds, parses and compiles imported modules. Import paths are
+// resolved relative to the importing file, then to each directory of the
+// search path in turn. Each module is compiled once per Loader and shared by
+// every program that imports it.
+type Loader struct {
+	SearchPath []string
+
+	mu      sync.Mutex
+	modules map[string]*Module
+}
+
+func NewLoader(searchPath ...string) *Loader {
+	return &Loader{
+		SearchPath: searchPath,
+		modules:    map[string]*Module{},
+	}
+}
+
+// DefaultLoader is used by Program.Compile. Its search path is taken from the
+// ROZERPATH environment variable, a list of directories separated like PATH.
+var DefaultLoader = NewLoader(filepath.SplitList(os.Getenv("ROZERPATH"))...)
+
+// resolve returns the absolute path of the file imported as path from the
+// file named from.
+func (l *Loader) resolve(path, from string) (string, error) {
+	candidates := []string{path}
+	if !filepath.IsAbs(path) {
+		candidates = []string{filepath.Join(filepath.Dir(from), path)}
+		for _, dir := range l.SearchPath {
+			candidates = append(candidates, filepath.Join(dir, path))
+		}
+	}
+
+	for _, candidate := range candidates {
+		info, err := os.Stat(candidate)
+		if err == nil && !info.IsDir() {
+			return filepath.Abs(candidate)
+		}
+	}
+	return "", fmt.Errorf("cannot find module %q", path)
+}
+
+// load returns the module imported as path from the file named from. stack
+// holds the files being compiled, outermost first, to detect import cycles.
+func (l *Loader) load(path, from string, stack []string) (*Module, error) {
+	resolved, err := l.resolve(path, from)
+	if err != nil {
+		return nil, err
+	}
+
+	if i := slices.Index(stack, resolved); i >= 0 {
+		cycle := append(slices.Clone(stack[i:]), resolved)
+		return nil, fmt.Errorf("import cycle: %s", strings.Join(cycle, " -> "))
+	}
+
+	if m, ok := l.modules[resolved]; ok {
+		return m, nil
+	}
+
+	src, err := os.ReadFile(resolved)
+	if err != nil {
+		return nil, err
+	}
+
+	program, diagnostics := ParseAll(resolved, src)
+	if len(diagnostics) > 0 {
+		return nil, firstError(diagnostics[0], len(diagnostics))
+	}
+
+	pe, errs := program.compile(TypeMap{}, l, append(slices.Clone(stack), resolved))
+	if errs.Len() > 0 {
+		return nil, firstError((*errs.Errs)[0], errs.Len())
+	}
+
+	for _, c := range program.Commands {
+		if c.Expression != nil {
+			return nil, fmt.Errorf("modules may only contain imports and functions, found expression at %s", c.Expression.Pos)
+		}
+	}
+
+	m := newModule(resolved, pe)
+	l.modules[resolved] = m
+	return m, nil
+}
+
+func firstError(err error, count int) error {
+	if count > 1 {
+		return fmt.Errorf("%w (and %d more errors)", err, count-1)
+	}
+	return err
+}
+
+// compileImports loads the modules imported by the program, and records their
+// aliases in typeMap.
+func (p Program) compileImports(typeMap TypeMap, loader *Loader, stack []string) ([]ImportExecute, CompileErrors) {
+	var errs CompileErrors
+
+	imports := []ImportExecute{}
+	for _, c := range p.Commands {
+		if c == nil || c.Import == nil {
+			continue
+		}
+		imp := c.Import
+
+		if t, ok := typeMap[imp.Alias]; ok && t != TypeModule {
+			errs.Append(compileErrorf(CodeImport, imp.Pos, imp.EndPos, "cannot import %s as %s, the name is already a %s", imp.Path, imp.Alias, t))
+			continue
+		}
+		// the alias is known even if loading fails, so that its uses are
+		// not reported as well.
+		typeMap[imp.Alias] = TypeModule
+
+		m, err := loader.load(imp.Path, imp.Pos.Filename, stack)
+		if err != nil {
+			errs.Append(compileErrorf(CodeImport, imp.Pos, imp.EndPos, "cannot import %s: %s", imp.Path, err))
+			continue
+		}
+		imports = append(imports, ImportExecute{imp, m})
+	}
+
+	return imports, errs
+}
+
+// checkModuleReferences reports references to functions that the modules
+// imported by the program do not have.
+func (p Program) checkModuleReferences(imports []ImportExecute) CompileErrors {
+	var errs CompileErrors
+
+	modules := map[string]*Module{}
+	for _, ie := range imports {
+		modules[ie.Alias] = ie.Module
+	}
+
+	check := func(module, name string, pos, endPos lexer.Position) {
+		m, ok := modules[module]
+		if !ok {
+			// imported by an earlier program run in the same environment.
+			return
+		}
+		if _, ok := m.Function(name); !ok {
+			errs.Append(compileErrorf(CodeImport, pos, endPos, "module %s has no function %s", module, name))
+		}
+	}
+
+	Walk(&p, func(node any) bool {
+		switch n := node.(type) {
+		case *Invocation:
+			if n.Module != nil {
+				check(*n.Module, *n.Name, n.Pos, n.Pos)
+			}
+		case *Base:
+			if n.Ident != nil && len(n.Members) == 1 && n.Members[0].Field != nil {
+				check(*n.Ident, *n.Members[0].Field, n.Pos, n.EndPos)
+			}
+		}
+		return true
+	})
+
+	return errs
+}
+
+// importStack returns the stack of files being compiled for a program that
+// is not itself imported.
+func importStack(filename string) []string {
+	if filename == "" {
+		return nil
+	}
+	path, err := filepath.Abs(filename)
+	if err != nil {
+		return nil
+	}
+	return []string{path}
+}
@@ -0,0 +1,110 @@
+package lang
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeFiles writes each of files, keyed by slash separated path, under a new
+// temporary directory and returns the directory.
+func writeFiles(t *testing.T, files map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for name, content := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		err := os.MkdirAll(filepath.Dir(path), 0755)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = os.WriteFile(path, []byte(content), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func compileFile(t *testing.T, path string, loader *Loader) (ProgramExecute, CompileErrors) {
+	t.Helper()
+	src, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	program, diagnostics := ParseAll(path, src)
+	if len(diagnostics) > 0 {
+		t.Fatal(diagnostics)
+	}
+	return program.CompileWith(TypeMap{}, loader)
+}
+
+func TestImport(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"lib/util.roz":    "import \"strs.roz\" as strs\n\nfn clean(x) {\n    strs.twice(x) + 1\n}\n\nfn helper(x) {\n    clean(x) * 10\n}\n",
+		"lib/strs.roz":    "fn twice(x) {\n    x * 2\n}\n",
+		"shared/more.roz": "fn inc(x) {\n    x + 1\n}\n",
+		"main.roz":        "import \"lib/util.roz\" as util\nimport \"more.roz\" as more\n\nf := util.clean\nutil.helper(1) + f(10) + more.inc(0)\n",
+	})
+
+	loader := NewLoader(filepath.Join(dir, "shared"))
+	pe, errs := compileFile(t, filepath.Join(dir, "main.roz"), loader)
+	if errs.Len() > 0 {
+		t.Fatal(*errs.Errs)
+	}
+
+	result, err := pe.ExecuteProgramContext(context.Background(), Limits{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result != IntegerValue(30+21+1) {
+		t.Errorf("got %v, want 52", result)
+	}
+
+	// compiling again shares the cached modules.
+	again, _ := compileFile(t, filepath.Join(dir, "main.roz"), loader)
+	if again.Imports[0].Module != pe.Imports[0].Module {
+		t.Errorf("module was compiled twice")
+	}
+}
+
+func TestImportErrors(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"a.roz":       "import \"b.roz\" as b\n\nfn fa() {\n    1\n}\n",
+		"b.roz":       "import \"a.roz\" as a\n\nfn fb() {\n    2\n}\n",
+		"lib.roz":     "fn f() {\n    1\n}\n",
+		"script.roz":  "x := 1\n",
+		"cycle.roz":   "import \"a.roz\" as a\n",
+		"missing.roz": "import \"nowhere.roz\" as n\n",
+		"member.roz":  "import \"lib.roz\" as lib\nlib.g()\n",
+		"unknown.roz": "lib.f()\n",
+		"expr.roz":    "import \"script.roz\" as s\n",
+	})
+
+	tests := []struct {
+		file string
+		want string
+	}{
+		{"cycle.roz", "import cycle: "},
+		{"missing.roz", "cannot find module \"nowhere.roz\""},
+		{"member.roz", "module lib has no function g"},
+		{"unknown.roz", "unknown module lib"},
+		{"expr.roz", "modules may only contain imports and functions"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.file, func(t *testing.T) {
+			_, errs := compileFile(t, filepath.Join(dir, tt.file), NewLoader())
+			if errs.Len() != 1 {
+				t.Fatalf("got %d errors, want 1", errs.Len())
+			}
+			err := (*errs.Errs)[0]
+			if !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("got %q, want it to contain %q", err, tt.want)
+			}
+			if CompileDiagnostic(err).Code != CodeImport {
+				t.Errorf("got code %s, want %s", CompileDiagnostic(err).Code, CodeImport)
+			}
+		})
+	}
+}
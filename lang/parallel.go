@@ -0,0 +1,214 @@
+package lang
+
+import (
+	"sync"
+
+	"github.com/alecthomas/participle/v2/lexer"
+)
+
+func init() {
+	registerBuiltin(BuiltinFunction{
+		Name:   "parallel",
+		Params: []string{"workers", "function"},
+		Func: func(ee *ExecutionEnvironment) ExecutionResult {
+			return newParallelStage(ee, false)
+		},
+	})
+	registerBuiltin(BuiltinFunction{
+		Name:   "parallel_ordered",
+		Params: []string{"workers", "function"},
+		Func: func(ee *ExecutionEnvironment) ExecutionResult {
+			return newParallelStage(ee, true)
+		},
+	})
+}
+
+func newParallelStage(ee *ExecutionEnvironment, ordered bool) ParallelStage {
+	workers, ok := ee.Get("workers").(IntegerValue)
+	if !ok || workers < 1 {
+		runtimeErrorf(lexer.Position{}, "parallel: workers must be a positive integer, got %v", ee.Get("workers"))
+	}
+
+	fn, ok := ee.Get("function").(Parameterized)
+	if !ok || len(fn.ParameterNames()) != 1 {
+		runtimeErrorf(lexer.Position{}, "parallel: expecting a function of 1 argument, got %T", ee.Get("function"))
+	}
+
+	return ParallelStage{
+		Workers:  int(workers),
+		Ordered:  ordered,
+		Function: fn,
+	}
+}
+
+// ParallelStage is a pipeline stage that applies Function to its items using
+// Workers goroutines. Results are yielded as they complete, or in input order
+// if Ordered is set, holding at most orderedWindow items per worker between
+// reading one and yielding its result. Used outside of a pipeline it is
+// simply Function.
+type ParallelStage struct {
+	Workers  int
+	Ordered  bool
+	Function Parameterized
+}
+
+func (ps ParallelStage) Apply(ee *ExecutionEnvironment) ExecutionResult {
+	return ps.Function.Apply(ee)
+}
+
+func (ps ParallelStage) ParameterNames() []string {
+	return ps.Function.ParameterNames()
+}
+
+func (ps ParallelStage) Execute(ee *ExecutionEnvironment) ExecutionResult {
+	return ps
+}
+
+func (ps ParallelStage) Type(typeMap TypeMap) Type {
+	return TypeFunction
+}
+
+func (ps ParallelStage) ListRep() []any {
+	return []any{"parallel", ps.Workers, ps.Ordered, ps.Function.ListRep()}
+}
+
+// orderedWindow is the number of items per worker an ordered ParallelStage
+// may have read but not yet yielded, waiting for a slow one before them.
+const orderedWindow = 2
+
+type parallelItem struct {
+	seq   int
+	value ExecutionResult
+}
+
+// Stream reads upstream in one goroutine and fans the items out to the
+// workers, each of which applies the function in its own local environment.
+// If the upstream or any worker panics, the remaining goroutines are stopped
+// and the panic is raised again in the goroutine reading the stream.
+func (ps ParallelStage) Stream(ee *ExecutionEnvironment, upstream Stream, done <-chan struct{}) Stream {
+	jobs := make(chan parallelItem)
+	results := make(chan parallelItem)
+
+	failed := make(chan struct{})
+	var failure any
+	var failOnce sync.Once
+	fail := func() {
+		if r := recover(); r != nil {
+			failOnce.Do(func() {
+				failure = r
+				close(failed)
+			})
+		}
+	}
+
+	var endTag ExecutionResult
+
+	// inFlight holds a slot for each item read and not yet yielded, when
+	// ordered.
+	var inFlight chan struct{}
+	if ps.Ordered {
+		inFlight = make(chan struct{}, orderedWindow*ps.Workers)
+	}
+
+	go func() {
+		defer close(jobs)
+		defer fail()
+		for seq := 0; ; seq++ {
+			if inFlight != nil {
+				select {
+				case inFlight <- struct{}{}:
+				case <-done:
+					return
+				case <-failed:
+					return
+				}
+			}
+			item, ok := upstream()
+			if !ok {
+				endTag = item
+				return
+			}
+			select {
+			case jobs <- parallelItem{seq, item}:
+			case <-done:
+				return
+			case <-failed:
+				return
+			}
+		}
+	}()
+
+	param := ps.Function.ParameterNames()[0]
+
+	var wg sync.WaitGroup
+	for w := 0; w < ps.Workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer fail()
+			for job := range jobs {
+				fnEnv := ee.NewLocalEnvironment()
+				fnEnv.Set(param, job.value)
+				result := parallelItem{job.seq, ps.Function.Apply(fnEnv)}
+				select {
+				case results <- result:
+				case <-done:
+					return
+				case <-failed:
+					return
+				}
+			}
+		}()
+	}
+
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	pending := map[int]ExecutionResult{}
+	next := 0
+
+	return func() (ExecutionResult, bool) {
+		for {
+			if ps.Ordered {
+				value, ok := pending[next]
+				if ok {
+					delete(pending, next)
+					next++
+					<-inFlight
+					if TagContinue == value {
+						continue
+					}
+					return value, !endsPipeline(value)
+				}
+			}
+
+			var result parallelItem
+			var ok bool
+			select {
+			case <-failed:
+				panic(failure)
+			case result, ok = <-results:
+			}
+
+			if !ok {
+				select {
+				case <-failed:
+					panic(failure)
+				default:
+				}
+				return endTag, false
+			}
+
+			if ps.Ordered {
+				pending[result.seq] = result.value
+				continue
+			}
+			if TagContinue == result.value {
+				continue
+			}
+			return result.value, !endsPipeline(result.value)
+		}
+	}
+}
@@ -0,0 +1,150 @@
+package lang
+
+import (
+	"sort"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func countingStream(n int) Stream {
+	i := 0
+	return func() (ExecutionResult, bool) {
+		if i == n {
+			return TagComplete, false
+		}
+		i++
+		return IntegerValue(i), true
+	}
+}
+
+func doubler(t *testing.T) Parameterized {
+	fn := compileForTest(t, "doubler", "fn(x) {\n    x * 2\n}\n").ExecuteProgram()
+	return fn.(Parameterized)
+}
+
+func drain(stream Stream) ([]ExecutionResult, ExecutionResult) {
+	items := []ExecutionResult{}
+	for {
+		item, ok := stream()
+		if !ok {
+			return items, item
+		}
+		items = append(items, item)
+	}
+}
+
+func TestParallelOrdered(t *testing.T) {
+	done := make(chan struct{})
+	defer close(done)
+
+	ps := ParallelStage{Workers: 8, Ordered: true, Function: doubler(t)}
+	items, end := drain(ps.Stream(NewExecutionEnvironment(), countingStream(200), done))
+
+	if end != TagComplete {
+		t.Errorf("stream ended with %v, want %v", end, TagComplete)
+	}
+	if len(items) != 200 {
+		t.Fatalf("got %d items, want 200", len(items))
+	}
+	for i, item := range items {
+		if item != IntegerValue(2*(i+1)) {
+			t.Fatalf("item %d is %v, want %d", i, item, 2*(i+1))
+		}
+	}
+}
+
+func TestParallelOrderedSlowItem(t *testing.T) {
+	done := make(chan struct{})
+	defer close(done)
+
+	// the first item is held until released, and the others must not pile up
+	// behind it.
+	release := make(chan struct{})
+	slow := BuiltinFunction{
+		Name:   "slow",
+		Params: []string{"x"},
+		Func: func(ee *ExecutionEnvironment) ExecutionResult {
+			if ee.Get("x") == IntegerValue(1) {
+				<-release
+			}
+			return ee.Get("x")
+		},
+	}
+	var read atomic.Int64
+	numbers := countingStream(1000)
+	upstream := func() (ExecutionResult, bool) {
+		read.Add(1)
+		return numbers()
+	}
+
+	ps := ParallelStage{Workers: 4, Ordered: true, Function: slow}
+	stream := ps.Stream(NewExecutionEnvironment(), upstream, done)
+	time.Sleep(50 * time.Millisecond)
+	if n := read.Load(); n > orderedWindow*4 {
+		t.Errorf("read %d items while the first was held", n)
+	}
+
+	close(release)
+	items, _ := drain(stream)
+	if len(items) != 1000 || items[999] != IntegerValue(1000) {
+		t.Errorf("got %d items, the last %v", len(items), items[len(items)-1])
+	}
+}
+
+func TestParallelUnordered(t *testing.T) {
+	done := make(chan struct{})
+	defer close(done)
+
+	ps := ParallelStage{Workers: 8, Function: doubler(t)}
+	items, _ := drain(ps.Stream(NewExecutionEnvironment(), countingStream(200), done))
+
+	if len(items) != 200 {
+		t.Fatalf("got %d items, want 200", len(items))
+	}
+	sort.Slice(items, func(i, j int) bool {
+		return items[i].(IntegerValue) < items[j].(IntegerValue)
+	})
+	for i, item := range items {
+		if item != IntegerValue(2*(i+1)) {
+			t.Fatalf("item %d is %v, want %d", i, item, 2*(i+1))
+		}
+	}
+}
+
+func TestParallelWorkerFailure(t *testing.T) {
+	done := make(chan struct{})
+	defer close(done)
+
+	failing := BuiltinFunction{
+		Name:   "failing",
+		Params: []string{"x"},
+		Func: func(ee *ExecutionEnvironment) ExecutionResult {
+			if ee.Get("x") == IntegerValue(50) {
+				panic("worker failed")
+			}
+			return ee.Get("x")
+		},
+	}
+
+	ps := ParallelStage{Workers: 4, Function: failing}
+	stream := ps.Stream(NewExecutionEnvironment(), countingStream(1000), done)
+
+	defer func() {
+		if r := recover(); r != "worker failed" {
+			t.Errorf("recovered %v, want worker failure", r)
+		}
+	}()
+	drain(stream)
+	t.Errorf("expected the worker failure to be raised")
+}
+
+func TestParallelPipeline(t *testing.T) {
+	for _, builtin := range []string{"parallel", "parallel_ordered"} {
+		source := "1..1000 >> " + builtin + "(8, fn(x) {\n    x * 2\n}) >> fn(y) {\n    y + 1\n}\n"
+		executable := compileForTest(t, builtin, source)
+		if got := executable.ExecuteProgram(); got != TagComplete {
+			t.Errorf("%s: got %v, want %v", builtin, got, TagComplete)
+		}
+	}
+}
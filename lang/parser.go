@@ -0,0 +1,32 @@
+package lang
+
+import (
+	"io"
+
+	"github.com/alecthomas/participle/v2"
+)
+
+var (
+	Parser = participle.MustBuild[Program](
+		participle.Lexer(PipelineLexer),
+		participle.CaseInsensitive("Ident"),
+		participle.Unquote("String"),
+		participle.UseLookahead(4),
+	)
+)
+
+func Parse(filename string, r io.Reader) (*Program, error) {
+	program, err := Parser.Parse(filename, r)
+	if err != nil {
+		return nil, err
+	}
+	return program, nil
+}
+
+func ParseString(filename string, s string) (*Program, error) {
+	program, err := Parser.ParseString(filename, s)
+	if err != nil {
+		return nil, err
+	}
+	return program, nil
+}
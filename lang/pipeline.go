@@ -0,0 +1,63 @@
+package lang
+
+// Stream yields the items flowing through a pipeline, one per call. When the
+// stream is exhausted it returns false, along with the tag that ended it.
+type Stream func() (ExecutionResult, bool)
+
+// StreamStage is a pipeline stage that pulls items from its upstream itself,
+// rather than being applied to one item at a time. done is closed when the
+// pipeline has finished, so that any goroutines started by the stage can exit.
+type StreamStage interface {
+	Parameterized
+	Stream(ee *ExecutionEnvironment, upstream Stream, done <-chan struct{}) Stream
+}
+
+// endsPipeline reports whether a stage result stops the whole pipeline.
+func endsPipeline(result ExecutionResult) bool {
+	return TagComplete == result ||
+		TagBreak == result ||
+		TagNull == result
+}
+
+// sourceStream calls the first function of a pipeline repeatedly, yielding
+// each result.
+func sourceStream(ee *ExecutionEnvironment, fn Parameterized) Stream {
+	return func() (ExecutionResult, bool) {
+		for {
+			ee.step()
+			result := fn.Apply(ee.NewLocalEnvironment())
+			if TagContinue == result {
+				continue
+			}
+			if endsPipeline(result) {
+				return result, false
+			}
+			return result, true
+		}
+	}
+}
+
+// applyStream applies fn to each item of upstream in a new local environment.
+// Items for which fn returns #continue are dropped.
+func applyStream(ee *ExecutionEnvironment, upstream Stream, fn Parameterized) Stream {
+	param := fn.ParameterNames()[0]
+	return func() (ExecutionResult, bool) {
+		for {
+			item, ok := upstream()
+			if !ok {
+				return item, false
+			}
+			ee.step()
+			fnEnv := ee.NewLocalEnvironment()
+			fnEnv.Set(param, item)
+			result := fn.Apply(fnEnv)
+			if TagContinue == result {
+				continue
+			}
+			if endsPipeline(result) {
+				return result, false
+			}
+			return result, true
+		}
+	}
+}
@@ -0,0 +1,133 @@
+package lang
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// FormatValue returns the value as it would be written in the language.
+func FormatValue(v any) string {
+	switch v := v.(type) {
+	case nil:
+		return TagNull.Value
+	case BoolValue:
+		return strconv.FormatBool(bool(v))
+	case FloatValue:
+		return formatFloat(float64(v))
+	case IntegerValue:
+		return strconv.FormatInt(int64(v), 10)
+	case StringValue:
+		return strconv.Quote(string(v))
+	case TagValue:
+		return v.Value
+	case IdentifierValue:
+		return v.Value
+	case KeyValueResult:
+		return FormatValue(v.Key) + ": " + FormatValue(v.Value)
+	case ListResult:
+		items := make([]string, len(v.Items))
+		for i, item := range v.Items {
+			items[i] = FormatValue(item)
+		}
+		return "[" + strings.Join(items, ", ") + "]"
+	case RecordValue:
+		items := make([]string, 0, v.Len())
+		allNamed := len(v.Keys()) == v.Len()
+		v.Each(func(i int, name string, value any) bool {
+			item := FormatValue(ValueFromRoze(value))
+			named := allNamed
+			if !named {
+				_, named = v.NameAt(i)
+			}
+			if named {
+				item = strconv.Quote(name) + ": " + item
+			}
+			items = append(items, item)
+			return true
+		})
+		return "record([" + strings.Join(items, ", ") + "])"
+	case RowValue:
+		return FormatValue(v.Record())
+	case BatchValue:
+		return "batch(" + FormatValue(v.rows()) + ")"
+	case FunctionExecute:
+		name := ""
+		if v.NamedFunction != nil && v.NamedFunction.Name != nil {
+			name = " " + *v.NamedFunction.Name
+		}
+		return "fn" + name + "(" + strings.Join(v.ParameterNames(), ", ") + ")"
+	case ModuleFunction:
+		return FormatValue(v.FunctionExecute)
+	case *Module:
+		return "module " + strconv.Quote(v.Path)
+	case BuiltinFunction:
+		return "fn " + v.Name + "(" + strings.Join(v.Params, ", ") + ")"
+	case ParallelStage:
+		name := "parallel"
+		if v.Ordered {
+			name = "parallel_ordered"
+		}
+		return fmt.Sprintf("%s(%d, %s)", name, v.Workers, FormatValue(v.Function))
+	case BatchStage:
+		return fmt.Sprintf("batch(%d)", v.Size)
+	case UnbatchStage:
+		return "unbatch()"
+	case SortStage:
+		return fmt.Sprintf("%s(%s)", v.name(), FormatValue(v.Key))
+	case TopStage:
+		return fmt.Sprintf("top(%d, %s)", v.N, FormatValue(v.Key))
+	case DistinctStage:
+		var args []string
+		if v.Capacity > 0 {
+			args = append(args, strconv.Itoa(v.Capacity))
+		}
+		if v.Key != nil {
+			args = append(args, FormatValue(v.Key))
+		}
+		return v.name() + "(" + strings.Join(args, ", ") + ")"
+	case DedupeStage:
+		return "dedupe_consecutive()"
+	case RunningStage:
+		switch v.Kind {
+		case "row_number":
+			return fmt.Sprintf("row_number(%q)", v.Field)
+		case "running_sum":
+			return fmt.Sprintf("running_sum(%q, %s)", v.Field, FormatValue(v.Value))
+		default:
+			return fmt.Sprintf("%s(%q, %d, %s)", v.Kind, v.Field, v.N, FormatValue(v.Value))
+		}
+	case CountWindowStage:
+		if v.Size == v.Step {
+			return fmt.Sprintf("tumbling(%d, %s)", v.Size, FormatValue(v.Aggregate))
+		}
+		return fmt.Sprintf("sliding(%d, %d, %s)", v.Size, v.Step, FormatValue(v.Aggregate))
+	case TimeWindowStage:
+		if v.Span == v.Step {
+			return fmt.Sprintf("tumbling_time(%q, %s, %s)", v.Field, FormatValue(timeValue(v.Span)), FormatValue(v.Aggregate))
+		}
+		return fmt.Sprintf("sliding_time(%q, %s, %s, %s)", v.Field, FormatValue(timeValue(v.Span)), FormatValue(timeValue(v.Step)), FormatValue(v.Aggregate))
+	case JoinStage:
+		if v.Resolve != nil {
+			return fmt.Sprintf("join_with(%s, %s, %s, %q, %s)", FormatValue(v.Other), FormatValue(v.LeftKey), FormatValue(v.RightKey), v.Kind, FormatValue(v.Resolve))
+		}
+		if v.Conflict != "left" {
+			return fmt.Sprintf("join_with(%s, %s, %s, %q, %q)", FormatValue(v.Other), FormatValue(v.LeftKey), FormatValue(v.RightKey), v.Kind, v.Conflict)
+		}
+		return fmt.Sprintf("join(%s, %s, %s, %q)", FormatValue(v.Other), FormatValue(v.LeftKey), FormatValue(v.RightKey), v.Kind)
+	case Parameterized:
+		return "fn(" + strings.Join(v.ParameterNames(), ", ") + ")"
+	default:
+		return fmt.Sprintf("%v", v)
+	}
+}
+
+// formatFloat formats f so that it reads back as a float rather than an
+// integer.
+func formatFloat(f float64) string {
+	s := strconv.FormatFloat(f, 'f', -1, 64)
+	if !strings.ContainsAny(s, ".NI") {
+		s += ".0"
+	}
+	return s
+}
@@ -0,0 +1,540 @@
+package lang
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"slices"
+
+	"github.com/alecthomas/participle/v2/lexer"
+	"github.com/pdk/rozer"
+)
+
+func init() {
+	registerBuiltin(BuiltinFunction{
+		Name:   "record",
+		Params: []string{"items"},
+		Func: func(ee *ExecutionEnvironment) ExecutionResult {
+			items, ok := ee.Get("items").(ListResult)
+			if !ok {
+				runtimeErrorf(lexer.Position{}, "record: expecting a list, got %s", FormatValue(ee.Get("items")))
+			}
+			r := rozer.NewObject()
+			err := appendItems(r, items)
+			if err != nil {
+				runtimeErrorf(lexer.Position{}, "record: %s", err)
+			}
+			return RecordValue{r}
+		},
+	})
+	registerBuiltin(BuiltinFunction{
+		Name:   "parse_json",
+		Params: []string{"text"},
+		Func: func(ee *ExecutionEnvironment) ExecutionResult {
+			text, ok := ee.Get("text").(StringValue)
+			if !ok {
+				runtimeErrorf(lexer.Position{}, "parse_json: expecting a string, got %s", FormatValue(ee.Get("text")))
+			}
+			r, err := rozer.Decoding{}.Unmarshal([]byte(text))
+			if err != nil {
+				runtimeErrorf(lexer.Position{}, "parse_json: %s", err)
+			}
+			return ValueFromRoze(r)
+		},
+	})
+	registerBuiltin(BuiltinFunction{
+		Name:   "to_json",
+		Params: []string{"value"},
+		Func: func(ee *ExecutionEnvironment) ExecutionResult {
+			value, err := RozeValue(ee.Get("value"))
+			if err != nil {
+				runtimeErrorf(lexer.Position{}, "to_json: %s", err)
+			}
+			b, err := json.Marshal(value)
+			if err != nil {
+				runtimeErrorf(lexer.Position{}, "to_json: %s", err)
+			}
+			return StringValue(b)
+		},
+	})
+}
+
+// RecordValue is a record: a *rozer.Roze whose values are accessed by name,
+// as in row.name, or by position, as in row[0]. Records are shared, not
+// copied, so changing one changes it everywhere it is used.
+type RecordValue struct {
+	*rozer.Roze
+}
+
+// ValueFromRoze converts a value held in a Roze to a language value. Nested
+// objects become records sharing the nested Roze, and arrays become lists.
+func ValueFromRoze(v any) any {
+	switch v := v.(type) {
+	case nil:
+		return TagNull
+	case bool:
+		return BoolValue(v)
+	case string:
+		return StringValue(v)
+	case int:
+		return IntegerValue(v)
+	case int64:
+		return IntegerValue(v)
+	case float64:
+		return FloatValue(v)
+	case json.Number:
+		if rozer.IsInteger(v) {
+			if i, err := v.Int64(); err == nil {
+				return IntegerValue(i)
+			}
+		}
+		f, _ := v.Float64()
+		return FloatValue(f)
+	case *big.Int:
+		if v.IsInt64() {
+			return IntegerValue(v.Int64())
+		}
+		f, _ := new(big.Float).SetInt(v).Float64()
+		return FloatValue(f)
+	case *big.Float:
+		f, _ := v.Float64()
+		return FloatValue(f)
+	case *rozer.Roze:
+		if v == nil {
+			return TagNull
+		}
+		if !v.IsArray() {
+			return RecordValue{v}
+		}
+		list := ListResult{Items: make([]any, 0, v.Len())}
+		for _, item := range v.Values() {
+			list.Items = append(list.Items, ValueFromRoze(item))
+		}
+		return list
+	default:
+		// already a language value.
+		return v
+	}
+}
+
+// RozeValue converts a language value to one that can be held in a Roze.
+// Lists become Roze arrays, or objects if their items are key-value pairs.
+func RozeValue(v any) (any, error) {
+	switch v := v.(type) {
+	case BoolValue:
+		return bool(v), nil
+	case IntegerValue:
+		return int64(v), nil
+	case FloatValue:
+		return float64(v), nil
+	case StringValue:
+		return string(v), nil
+	case TagValue:
+		if v.Value == TagNull.Value {
+			return nil, nil
+		}
+		return v.Value, nil
+	case RecordValue:
+		return v.Roze, nil
+	case RowValue:
+		return v.Batch.Row(v.Row), nil
+	case BatchValue:
+		r := rozer.New()
+		for _, row := range v.Rows() {
+			r.Append(row)
+		}
+		return r, nil
+	case ListResult:
+		r := rozer.New()
+		err := appendItems(r, v)
+		if err != nil {
+			return nil, err
+		}
+		return r, nil
+	default:
+		return nil, fmt.Errorf("cannot hold %s in a record", FormatValue(v))
+	}
+}
+
+// appendItems adds the items of a list to r: key-value pairs as named values
+// and other items as unnamed ones.
+func appendItems(r *rozer.Roze, list ListResult) error {
+	for _, item := range list.Items {
+		kv, ok := item.(KeyValueResult)
+		if !ok {
+			value, err := RozeValue(item)
+			if err != nil {
+				return err
+			}
+			r.Append(value)
+			continue
+		}
+
+		key, ok := kv.Key.(StringValue)
+		if !ok {
+			return fmt.Errorf("record keys must be strings, got %s", FormatValue(kv.Key))
+		}
+		value, err := RozeValue(kv.Value)
+		if err != nil {
+			return err
+		}
+		r.Put(string(key), value)
+	}
+	return nil
+}
+
+// copyRecord returns a copy of a record that shares its values.
+func copyRecord(r *rozer.Roze) *rozer.Roze {
+	copied := rozer.NewObject()
+	for name, value := range r.All() {
+		if name == "" {
+			copied.Append(value)
+		} else {
+			copied.Add(name, value)
+		}
+	}
+	return copied
+}
+
+// FieldAccess reads a field or item of a value, as in row.address.city,
+// items[2] or items[*].id. A missing field or item is #null. Fields of lists
+// are found among their key-value pairs, and a wildcard yields a list of the
+// rest of the access applied to each item. Fields of batches and their rows
+// are found by column.
+type FieldAccess struct {
+	Base    *Base
+	Name    string
+	Indexes []Executable // for each member, the index expression, if it has one
+	columns *columnCache
+}
+
+func (b *Base) compileFieldAccess(typeMap TypeMap) (Executable, CompileErrors) {
+	var errs CompileErrors
+
+	fa := FieldAccess{Base: b, Name: *b.Ident, Indexes: make([]Executable, len(b.Members)), columns: &columnCache{}}
+	for i, m := range b.Members {
+		if m.Index == nil {
+			continue
+		}
+		index := errs.Collect(m.Index.Compile(typeMap))
+		t := index.Type(typeMap)
+		if t != TypeInteger && t != TypeUnknown {
+			errs.Append(compileErrorf(CodeInvalidType, m.Pos, m.EndPos, "invalid type %s for index (should be integer)", t))
+		}
+		fa.Indexes[i] = index
+	}
+
+	return fa, errs
+}
+
+// path evaluates the members of the access as a rozer.Path.
+func (fa FieldAccess) path(ee *ExecutionEnvironment) rozer.Path {
+	path := make(rozer.Path, len(fa.Base.Members))
+	for i, m := range fa.Base.Members {
+		switch {
+		case m.Field != nil:
+			path[i] = rozer.Field(*m.Field)
+		case m.Wildcard:
+			path[i] = rozer.Wildcard()
+		default:
+			index, ok := fa.Indexes[i].Execute(ee).(IntegerValue)
+			if !ok {
+				runtimeErrorf(m.Pos, "index of %s must be an integer", fa.Base)
+			}
+			path[i] = rozer.Index(int(index))
+		}
+	}
+	return path
+}
+
+func (fa FieldAccess) Execute(ee *ExecutionEnvironment) ExecutionResult {
+	value := ee.Get(fa.Name)
+	if value == nil {
+		runtimeErrorf(fa.Base.Pos, "%s is not defined", fa.Name)
+	}
+	path := fa.path(ee)
+
+	// a module imported by an earlier program run in the same environment.
+	if m, ok := value.(*Module); ok && len(path) == 1 && path[0].Kind == rozer.StepName {
+		mf, ok := m.Function(path[0].Name)
+		if !ok {
+			runtimeErrorf(fa.Base.Pos, "module %s has no function %s", fa.Name, path[0].Name)
+		}
+		return mf
+	}
+
+	result, ok := fa.access(value, path)
+	if !ok {
+		return TagNull
+	}
+	return result
+}
+
+// access returns the value at path in value, and whether there is one.
+func (fa FieldAccess) access(value any, path rozer.Path) (any, bool) {
+	for i, step := range path {
+		switch v := value.(type) {
+		case RecordValue:
+			result, ok := path[i:].Get(v.Roze)
+			if !ok {
+				return nil, false
+			}
+			return ValueFromRoze(result), true
+		case RowValue:
+			if step.Kind != rozer.StepName {
+				return fa.access(v.Record(), path[i:])
+			}
+			c, ok := fa.columns.column(v.Batch, step.Name)
+			if !ok {
+				return nil, false
+			}
+			value, ok = columnValue(v.Batch.Column(c), v.Row)
+			if !ok {
+				return nil, false
+			}
+		case BatchValue:
+			switch step.Kind {
+			case rozer.StepName:
+				c, found := fa.columns.column(v.Batch, step.Name)
+				list := ListResult{Items: make([]any, v.Len())}
+				for r := range list.Items {
+					list.Items[r] = TagNull
+					if !found {
+						continue
+					}
+					if item, ok := columnValue(v.Batch.Column(c), r); ok {
+						list.Items[r] = item
+					}
+				}
+				value = list
+			case rozer.StepIndex:
+				index := step.Index
+				if index < 0 {
+					index += v.Len()
+				}
+				if index < 0 || index >= v.Len() {
+					return nil, false
+				}
+				value = RowValue{v.Batch, index}
+			default:
+				return fa.access(v.rows(), path[i:])
+			}
+		case ListResult:
+			switch step.Kind {
+			case rozer.StepWildcard:
+				list := ListResult{Items: []any{}}
+				for _, item := range v.Items {
+					if result, ok := fa.access(item, path[i+1:]); ok {
+						list.Items = append(list.Items, result)
+					}
+				}
+				return list, true
+			case rozer.StepIndex:
+				index := step.Index
+				if index < 0 {
+					index += len(v.Items)
+				}
+				if index < 0 || index >= len(v.Items) {
+					return nil, false
+				}
+				value = v.Items[index]
+			case rozer.StepName:
+				found := false
+				for _, item := range v.Items {
+					kv, ok := item.(KeyValueResult)
+					if ok && kv.Key == StringValue(step.Name) {
+						value, found = kv.Value, true
+					}
+				}
+				if !found {
+					return nil, false
+				}
+			}
+		case TagValue:
+			if v.Value == TagNull.Value {
+				return nil, false
+			}
+			runtimeErrorf(fa.Base.Pos, "cannot access %s of %s (tag)", path[i:], FormatValue(v))
+		default:
+			runtimeErrorf(fa.Base.Pos, "cannot access %s of %s (%s)", path[i:], FormatValue(value), TypeOf(value))
+		}
+	}
+	return value, true
+}
+
+func (fa FieldAccess) Type(typeMap TypeMap) Type {
+	return TypeUnknown
+}
+
+func (fa FieldAccess) ListRep() []any {
+	members := []any{}
+	for i, m := range fa.Base.Members {
+		switch {
+		case m.Field != nil:
+			members = append(members, *m.Field)
+		case m.Wildcard:
+			members = append(members, "*")
+		default:
+			members = append(members, fa.Indexes[i].ListRep())
+		}
+	}
+	return []any{"member", fa.Name, members}
+}
+
+// compileFieldAssignment compiles an assignment to a field or item of a
+// variable, as in row.total := 1 or items[0] += 2.
+func (a *Assignment) compileFieldAssignment(typeMap TypeMap, fa FieldAccess, operand Executable, errs CompileErrors) (Executable, CompileErrors) {
+	for _, m := range fa.Base.Members {
+		if m.Wildcard {
+			errs.Append(compileErrorf(CodeInvalidAssignment, a.Pos, a.EndPos, "cannot assign to %s, which has a wildcard", fa.Base))
+			return nil, errs
+		}
+	}
+	switch t := typeMap[fa.Name]; t {
+	case TypeUnknown, TypeList, TypeRecord:
+	default:
+		errs.Append(compileErrorf(CodeInvalidAssignment, a.Pos, a.EndPos, "cannot assign to a member of %s, which is %s", fa.Name, t))
+		return nil, errs
+	}
+	if a.Operation.Op != ":=" && a.Operation.Op != "+=" {
+		errs.Append(compileErrorf(CodeInvalidAssignment, a.Pos, a.EndPos, "invalid assignment operator %s", a.Operation.Op))
+		return nil, errs
+	}
+	return FieldAssignmentExecute{a, fa, operand}, errs
+}
+
+// FieldAssignmentExecute sets a field or item of a variable, as in
+// row.total := 1 or items[0] += 2. Records are changed in place, so every
+// variable holding one sees the change. Lists are copied, and the variable
+// given the new one. Setting a missing field adds it, but an item must be in
+// the list.
+type FieldAssignmentExecute struct {
+	Assignment *Assignment
+	Left       FieldAccess
+	Right      Executable
+}
+
+func (fae FieldAssignmentExecute) Execute(ee *ExecutionEnvironment) ExecutionResult {
+	right := fae.Right.Execute(ee)
+
+	value := ee.Get(fae.Left.Name)
+	if value == nil {
+		runtimeErrorf(fae.Left.Base.Pos, "%s is not defined", fae.Left.Name)
+	}
+	path := fae.Left.path(ee)
+
+	if fae.Assignment.Operation.Op == "+=" {
+		left, ok := fae.Left.access(value, path)
+		if !ok {
+			left = TagNull
+		}
+		right = plus(fae.Assignment.Pos, left, right)
+	}
+
+	if _, ok := value.(ListResult); ok {
+		ee.Set(fae.Left.Name, fae.assign(value, path, right))
+	} else {
+		fae.assign(value, path, right)
+	}
+	return right
+}
+
+// assign returns value with the member at path set to right.
+func (fae FieldAssignmentExecute) assign(value any, path rozer.Path, right ExecutionResult) any {
+	if len(path) == 0 {
+		return right
+	}
+
+	switch v := value.(type) {
+	case RecordValue:
+		rv, err := RozeValue(right)
+		if err != nil {
+			runtimeErrorf(fae.Assignment.Pos, "cannot assign to %s: %s", fae.Left.Base, err)
+		}
+		if holdsAny(rv, containers(v.Roze, path)) {
+			runtimeErrorf(fae.Assignment.Pos, "cannot assign to %s: a record cannot hold itself", fae.Left.Base)
+		}
+		if err := path.Set(v.Roze, rv); err != nil {
+			runtimeErrorf(fae.Assignment.Pos, "cannot assign to %s: %s", fae.Left.Base, err)
+		}
+		return v
+	case ListResult:
+		items := slices.Clone(v.Items)
+		step := path[0]
+		switch step.Kind {
+		case rozer.StepIndex:
+			index := step.Index
+			if index < 0 {
+				index += len(items)
+			}
+			if index < 0 || index >= len(items) {
+				runtimeErrorf(fae.Assignment.Pos, "cannot assign to %s: index %d is out of range for %d items", fae.Left.Base, step.Index, len(items))
+			}
+			items[index] = fae.assign(items[index], path[1:], right)
+		case rozer.StepName:
+			found := -1
+			for i, item := range items {
+				if kv, ok := item.(KeyValueResult); ok && kv.Key == StringValue(step.Name) {
+					found = i
+				}
+			}
+			if found < 0 {
+				items = append(items, KeyValueResult{StringValue(step.Name), fae.assign(TagNull, path[1:], right)})
+			} else {
+				kv := items[found].(KeyValueResult)
+				kv.Value = fae.assign(kv.Value, path[1:], right)
+				items[found] = kv
+			}
+		}
+		return ListResult{Items: items}
+	default:
+		runtimeErrorf(fae.Assignment.Pos, "cannot assign to %s of %s (%s)", path, FormatValue(value), TypeOf(value))
+		return nil
+	}
+}
+
+func (fae FieldAssignmentExecute) Type(typeMap TypeMap) Type {
+	return fae.Right.Type(typeMap)
+}
+
+func (fae FieldAssignmentExecute) ListRep() []any {
+	return []any{fae.Assignment.Operation.Op, fae.Left.ListRep(), fae.Right.ListRep()}
+}
+
+// containers returns r and the records in it along path, up to the one the
+// last step of path is set in.
+func containers(r *rozer.Roze, path rozer.Path) map[*rozer.Roze]bool {
+	found := map[*rozer.Roze]bool{r: true}
+	for _, step := range path[:len(path)-1] {
+		var child any
+		if step.Kind == rozer.StepName {
+			child, _ = r.Lookup(step.Name)
+		} else {
+			child = r.At(step.Index)
+		}
+		next, ok := child.(*rozer.Roze)
+		if !ok {
+			break
+		}
+		r = next
+		found[r] = true
+	}
+	return found
+}
+
+// holdsAny reports whether value is, or holds, one of the records.
+func holdsAny(value any, records map[*rozer.Roze]bool) bool {
+	r, ok := value.(*rozer.Roze)
+	if !ok {
+		return false
+	}
+	if records[r] {
+		return true
+	}
+	for _, v := range r.All() {
+		if holdsAny(v, records) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,174 @@
+package lang
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/alecthomas/participle/v2/lexer"
+)
+
+// RuntimeError is an error found while executing a program.
+type RuntimeError struct {
+	Pos lexer.Position
+	Msg string
+}
+
+func (re *RuntimeError) Error() string {
+	if re.Pos.Line == 0 {
+		return re.Msg
+	}
+	return fmt.Sprintf("%s at %s", re.Msg, re.Pos)
+}
+
+var ErrLimitExceeded = errors.New("limit exceeded")
+
+// LimitError is returned when execution exceeds one of its Limits. It matches
+// ErrLimitExceeded with errors.Is.
+type LimitError struct {
+	Limit string
+	Max   any
+}
+
+func (le *LimitError) Error() string {
+	return fmt.Sprintf("%s limit of %v exceeded", le.Limit, le.Max)
+}
+
+func (le *LimitError) Is(target error) bool {
+	return target == ErrLimitExceeded
+}
+
+// abort carries an error out of a running program. Execution panics with an
+// abort, and ProgramExecute.ExecuteProgramContext recovers it and returns the
+// error.
+type abort struct {
+	err error
+}
+
+func runtimeErrorf(pos lexer.Position, format string, args ...any) {
+	panic(abort{&RuntimeError{pos, fmt.Sprintf(format, args...)}})
+}
+
+// recoverAbort stores the error of an aborted run in err. Other panics are
+// passed on.
+func recoverAbort(err *error) {
+	r := recover()
+	if r == nil {
+		return
+	}
+	if a, ok := r.(abort); ok {
+		*err = a.err
+		return
+	}
+	panic(r)
+}
+
+// locateError gives errors raised without a position, such as those from
+// builtin functions, the position of the invocation that raised them.
+func locateError(pos lexer.Position) {
+	r := recover()
+	if r == nil {
+		return
+	}
+	if a, ok := r.(abort); ok {
+		switch err := a.err.(type) {
+		case *RuntimeError:
+			if err.Pos.Line == 0 {
+				err.Pos = pos
+			}
+		case *AssertionError:
+			if err.Pos.Line == 0 {
+				err.Pos = pos
+			}
+		}
+	}
+	panic(r)
+}
+
+// Limits bounds the resources one run of a program may use. A zero field
+// means no limit.
+type Limits struct {
+	// MaxSteps limits the number of commands, invocations and pipeline items
+	// executed.
+	MaxSteps int64
+	// MaxDepth limits the nesting of function invocations.
+	MaxDepth int
+	// MaxWallTime limits the elapsed time of the run.
+	MaxWallTime time.Duration
+	// MaxHeldItems limits the number of items held in memory at once by
+	// lists and by pipeline stages that buffer items, such as sort_by and
+	// top. Each item of a list literal counts from when it is evaluated until
+	// the run ends, and each item a stage buffers while the stage holds it.
+	// An item counts as one however large it is.
+	MaxHeldItems int64
+}
+
+// context returns ctx with the wall time limit applied.
+func (l Limits) context(ctx context.Context) (context.Context, context.CancelFunc) {
+	if l.MaxWallTime > 0 {
+		return context.WithTimeoutCause(ctx, l.MaxWallTime, &LimitError{"wall time", l.MaxWallTime})
+	}
+	return context.WithCancel(ctx)
+}
+
+// runState is shared by every environment of one program run, including those
+// used by parallel pipeline workers.
+type runState struct {
+	ctx    context.Context
+	limits Limits
+
+	steps atomic.Int64
+	held  atomic.Int64
+}
+
+func newRunState(ctx context.Context, limits Limits) *runState {
+	return &runState{
+		ctx:    ctx,
+		limits: limits,
+	}
+}
+
+// step is called for each command, invocation and pipeline item executed. It
+// stops the run if the context is done or the step limit is exceeded.
+func (ee *ExecutionEnvironment) step() {
+	select {
+	case <-ee.run.ctx.Done():
+		panic(abort{context.Cause(ee.run.ctx)})
+	default:
+	}
+
+	max := ee.run.limits.MaxSteps
+	if max > 0 && ee.run.steps.Add(1) > max {
+		panic(abort{&LimitError{"step", max}})
+	}
+}
+
+// collect is called when n more items are held in memory. It stops the run if
+// the held items limit is exceeded.
+func (ee *ExecutionEnvironment) collect(n int) {
+	max := ee.run.limits.MaxHeldItems
+	if max > 0 && ee.run.held.Add(int64(n)) > max {
+		panic(abort{&LimitError{"held items", max}})
+	}
+}
+
+// release is called when n items counted by collect are no longer held.
+func (ee *ExecutionEnvironment) release(n int) {
+	ee.run.held.Add(-int64(n))
+}
+
+// enter returns a new local environment for a function invocation, one level
+// deeper than ee.
+func (ee *ExecutionEnvironment) enter() *ExecutionEnvironment {
+	local := ee.NewLocalEnvironment()
+	local.depth = ee.depth + 1
+
+	max := ee.run.limits.MaxDepth
+	if max > 0 && local.depth > max {
+		panic(abort{&LimitError{"recursion depth", max}})
+	}
+
+	return local
+}
@@ -0,0 +1,179 @@
+package lang
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+const endlessPipeline = "fn() {\n    1\n} >> fn(x) {\n    x\n}\n"
+
+func TestLimits(t *testing.T) {
+	tests := []struct {
+		name   string
+		source string
+		limits Limits
+		limit  string
+	}{
+		{
+			name:   "steps",
+			source: endlessPipeline,
+			limits: Limits{MaxSteps: 1000},
+			limit:  "step",
+		},
+		{
+			name:   "parallel steps",
+			source: "fn() {\n    1\n} >> parallel(4, fn(x) {\n    x\n})\n",
+			limits: Limits{MaxSteps: 1000},
+			limit:  "step",
+		},
+		{
+			name:   "depth",
+			source: "fn f(x) {\n    f(x)\n}\nf(1)\n",
+			limits: Limits{MaxDepth: 100},
+			limit:  "recursion depth",
+		},
+		{
+			name:   "wall time",
+			source: endlessPipeline,
+			limits: Limits{MaxWallTime: 20 * time.Millisecond},
+			limit:  "wall time",
+		},
+		{
+			name:   "list items",
+			source: "[1, 2]\n[3, 4]\n",
+			limits: Limits{MaxHeldItems: 3},
+			limit:  "held items",
+		},
+		{
+			name:   "sorted items",
+			source: "fn() {\n    1\n} >> sort_by(fn(x) {\n    x\n})\n",
+			limits: Limits{MaxHeldItems: 1000},
+			limit:  "held items",
+		},
+		{
+			name:   "top items",
+			source: "fn() {\n    1\n} >> top(1000000, fn(x) {\n    x\n})\n",
+			limits: Limits{MaxHeldItems: 1000},
+			limit:  "held items",
+		},
+		{
+			name:   "lead items",
+			source: "fn() {\n    1\n} >> lead(\"next\", 1000000, fn(x) {\n    x\n})\n",
+			limits: Limits{MaxHeldItems: 1000},
+			limit:  "held items",
+		},
+		{
+			name:   "windowed items",
+			source: "fn() {\n    1\n} >> tumbling(1000000, fn(w) {\n    w\n})\n",
+			limits: Limits{MaxHeldItems: 1000},
+			limit:  "held items",
+		},
+		{
+			name:   "time windowed items",
+			source: "fn() {\n    parse_json(\"{\\\"t\\\": 1}\")\n} >> tumbling_time(\"t\", 10, fn(w) {\n    w\n})\n",
+			limits: Limits{MaxHeldItems: 1000},
+			limit:  "held items",
+		},
+		{
+			name:   "distinct keys",
+			source: "1 .. 100000 >> distinct() >> fn(x) {\n    x\n}\n",
+			limits: Limits{MaxHeldItems: 1000},
+			limit:  "held items",
+		},
+		{
+			name:   "joined items",
+			source: "rows := fn() {\n    parse_json(\"{\\\"id\\\": 1}\")\n}\nrows >> join(rows, fn(r) {\n    r.id\n}, fn(r) {\n    r.id\n}, \"inner\")\n",
+			limits: Limits{MaxHeldItems: 1000, MaxWallTime: 5 * time.Second},
+			limit:  "held items",
+		},
+	}
+
+	for _, test := range tests {
+		executable := compileForTest(t, test.name, test.source)
+		_, err := executable.ExecuteProgramContext(context.Background(), test.limits)
+		if !errors.Is(err, ErrLimitExceeded) {
+			t.Errorf("%s: got error %v, want limit exceeded", test.name, err)
+			continue
+		}
+		var le *LimitError
+		if errors.As(err, &le) && le.Limit != test.limit {
+			t.Errorf("%s: exceeded %s limit, want %s", test.name, le.Limit, test.limit)
+		}
+	}
+}
+
+func TestHeldItemsReleased(t *testing.T) {
+	source := "1..5000 >> top(90, fn(x) {\n    x\n}) >> sort_by(fn(x) {\n    x\n})\n" +
+		"1..5000 >> sliding(40, 1, fn(w) {\n    w\n}) >> lead(\"next\", 40, fn(w) {\n    w\n})\n"
+	executable := compileForTest(t, "released", source)
+	if _, err := executable.ExecuteProgramContext(context.Background(), Limits{MaxHeldItems: 100}); err != nil {
+		t.Errorf("got error %v", err)
+	}
+}
+
+func TestContextCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(20*time.Millisecond, cancel)
+
+	executable := compileForTest(t, "cancel", endlessPipeline)
+	_, err := executable.ExecuteProgramContext(ctx, Limits{})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("got error %v, want %v", err, context.Canceled)
+	}
+}
+
+func TestRuntimeError(t *testing.T) {
+	executable := compileForTest(t, "runtime", "fn f(x) {\n    x + \"a\"\n}\nf(1)\n")
+	_, err := executable.ExecuteProgramContext(context.Background(), Limits{})
+
+	var re *RuntimeError
+	if !errors.As(err, &re) {
+		t.Fatalf("got error %v, want a runtime error", err)
+	}
+	if re.Pos.Line != 2 {
+		t.Errorf("error reported at line %d, want 2", re.Pos.Line)
+	}
+	if errors.Is(err, ErrLimitExceeded) {
+		t.Errorf("runtime error %v should not be a limit error", err)
+	}
+}
+
+func TestAssignmentErrors(t *testing.T) {
+	for _, source := range []string{
+		"list := [1, 2]\nlist[2] := 3\n",
+		"n := f()\nn.a := 1\n",
+		"row := record([\"a\": 1])\nrow.a += \"b\"\n",
+		"row := record([\"a\": 1])\nrow.a.b := 2\n",
+		"row := record([\"a\": 1])\nrow.self := row\ns := to_json(row)\n",
+		"row := record([\"a\": record([])])\nrow.a.b := [1, row.a]\n",
+	} {
+		executable := compileForTest(t, "assignment", "fn f() {\n    1\n}\n"+source)
+		_, err := executable.ExecuteProgramContext(context.Background(), Limits{})
+
+		var re *RuntimeError
+		if !errors.As(err, &re) {
+			t.Errorf("%q: got error %v, want a runtime error", source, err)
+			continue
+		}
+		if re.Pos.Line != 5 {
+			t.Errorf("%q: error reported at line %d, want 5", source, re.Pos.Line)
+		}
+	}
+}
+
+func TestLogicalOperandErrors(t *testing.T) {
+	// operands whose type is only known at run time are checked then.
+	executable := compileForTest(t, "logical", "r := parse_json(\"{\\\"n\\\": 1}\")\ntrue || false\nr.n && true\n")
+	_, err := executable.ExecuteProgramContext(context.Background(), Limits{})
+
+	var re *RuntimeError
+	if !errors.As(err, &re) || !strings.Contains(re.Msg, "invalid type integer for logical operation") {
+		t.Fatalf("got error %v, want a runtime error", err)
+	}
+	if re.Pos.Line != 3 {
+		t.Errorf("error reported at line %d, want 3", re.Pos.Line)
+	}
+}
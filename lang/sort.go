@@ -0,0 +1,540 @@
+package lang
+
+import (
+	"bufio"
+	"cmp"
+	"container/heap"
+	"errors"
+	"io"
+	"os"
+	"slices"
+	"sync"
+
+	"github.com/alecthomas/participle/v2/lexer"
+)
+
+func init() {
+	registerBuiltin(BuiltinFunction{
+		Name:   "sort_by",
+		Params: []string{"key"},
+		Func: func(ee *ExecutionEnvironment) ExecutionResult {
+			return SortStage{Key: keyFunction(ee, "sort_by"), Limit: sortLimit}
+		},
+	})
+	registerBuiltin(BuiltinFunction{
+		Name:   "sort_by_desc",
+		Params: []string{"key"},
+		Func: func(ee *ExecutionEnvironment) ExecutionResult {
+			return SortStage{Key: keyFunction(ee, "sort_by_desc"), Descending: true, Limit: sortLimit}
+		},
+	})
+	registerBuiltin(BuiltinFunction{
+		Name:   "top",
+		Params: []string{"n", "key"},
+		Func: func(ee *ExecutionEnvironment) ExecutionResult {
+			n, ok := ee.Get("n").(IntegerValue)
+			if !ok || n < 0 {
+				runtimeErrorf(lexer.Position{}, "top: n must be an integer of at least 0, got %s", FormatValue(ee.Get("n")))
+			}
+			return TopStage{N: int(n), Key: keyFunction(ee, "top")}
+		},
+	})
+}
+
+// sortLimit is how many items sort_by holds in memory before it spills them,
+// sorted, to a temporary file.
+const sortLimit = 100_000
+
+// keyFunction returns the function of one argument given as "key".
+func keyFunction(ee *ExecutionEnvironment, name string) Parameterized {
+	fn, ok := ee.Get("key").(Parameterized)
+	if !ok || len(fn.ParameterNames()) != 1 {
+		runtimeErrorf(lexer.Position{}, "%s: expecting a key function of 1 argument, got %s", name, FormatValue(ee.Get("key")))
+	}
+	return fn
+}
+
+// keyed is an item and its key.
+type keyed struct {
+	key  ExecutionResult
+	item ExecutionResult
+}
+
+// keyOf applies the key function to an item.
+func keyOf(ee *ExecutionEnvironment, fn Parameterized, item ExecutionResult) keyed {
+	ee.step()
+	fnEnv := ee.NewLocalEnvironment()
+	fnEnv.Set(fn.ParameterNames()[0], item)
+	return keyed{fn.Apply(fnEnv), item}
+}
+
+// compareKeys orders two keys. Integers, floats and strings are ordered as
+// the comparison operators order them, and integers and floats with each
+// other. false is before true, and #null is before everything else. Lists
+// are ordered by their first items that differ, then by length, so that a
+// list of keys sorts by each key in turn. Keys of other types cannot be
+// ordered.
+func compareKeys(name string, a, b ExecutionResult) int {
+	aType, bType := TypeOf(a), TypeOf(b)
+	switch {
+	case isNull(a) || isNull(b):
+		return cmp.Compare(btoi(!isNull(a)), btoi(!isNull(b)))
+	case aType == TypeInteger && bType == TypeFloat:
+		return cmp.Compare(float64(a.(IntegerValue)), float64(b.(FloatValue)))
+	case aType == TypeFloat && bType == TypeInteger:
+		return cmp.Compare(float64(a.(FloatValue)), float64(b.(IntegerValue)))
+	case aType != bType:
+		runtimeErrorf(lexer.Position{}, "%s: cannot order keys of types %s, %s", name, aType, bType)
+	case aType == TypeBool:
+		return cmp.Compare(btoi(bool(a.(BoolValue))), btoi(bool(b.(BoolValue))))
+	case aType == TypeList:
+		as, bs := a.(ListResult).Items, b.(ListResult).Items
+		for i := range min(len(as), len(bs)) {
+			if c := compareKeys(name, as[i], bs[i]); c != 0 {
+				return c
+			}
+		}
+		return cmp.Compare(len(as), len(bs))
+	case LessThanOpMap[aType] == nil || aType == TypeUnknown:
+		runtimeErrorf(lexer.Position{}, "%s: cannot order keys of type %s", name, aType)
+	}
+	switch {
+	case bool(LessThanOpMap[aType](a, b)):
+		return -1
+	case bool(LessThanOpMap[aType](b, a)):
+		return 1
+	default:
+		return 0
+	}
+}
+
+func isNull(v ExecutionResult) bool {
+	tag, ok := v.(TagValue)
+	return ok && tag.Value == TagNull.Value
+}
+
+func btoi(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// SortStage is a pipeline stage that yields its items ordered by the keys the
+// Key function gives them, greatest first if Descending. Items with equal
+// keys keep their order, and a list key sorts by each of its items in turn.
+// It reads every item before it yields any, holding at most Limit of them in
+// memory and spilling the rest, sorted, to temporary files that are merged
+// as the items are yielded. Records read back from a file are copies of
+// those spilled. If an item cannot be spilled, such as a function or a
+// record with an integer beyond 64 bits, the items from there on are all
+// held in memory instead. Used outside of a pipeline it sorts a list.
+type SortStage struct {
+	Key        Parameterized
+	Descending bool
+	Limit      int
+}
+
+func (ss SortStage) name() string {
+	if ss.Descending {
+		return "sort_by_desc"
+	}
+	return "sort_by"
+}
+
+func (ss SortStage) compare(a, b keyed) int {
+	if ss.Descending {
+		return compareKeys(ss.name(), b.key, a.key)
+	}
+	return compareKeys(ss.name(), a.key, b.key)
+}
+
+func (ss SortStage) Apply(ee *ExecutionEnvironment) ExecutionResult {
+	list, ok := ee.Get("items").(ListResult)
+	if !ok {
+		runtimeErrorf(lexer.Position{}, "%s: expecting a list, got %s", ss.name(), FormatValue(ee.Get("items")))
+	}
+	ee.collect(len(list.Items))
+	run := make([]keyed, len(list.Items))
+	for i, item := range list.Items {
+		run[i] = keyOf(ee, ss.Key, item)
+	}
+	slices.SortStableFunc(run, ss.compare)
+	sorted := ListResult{Items: make([]any, len(run))}
+	for i, k := range run {
+		sorted.Items[i] = k.item
+	}
+	return sorted
+}
+
+func (ss SortStage) ParameterNames() []string {
+	return []string{"items"}
+}
+
+func (ss SortStage) Execute(ee *ExecutionEnvironment) ExecutionResult {
+	return ss
+}
+
+func (ss SortStage) Type(typeMap TypeMap) Type {
+	return TypeFunction
+}
+
+func (ss SortStage) ListRep() []any {
+	return []any{ss.name(), ss.Key.ListRep()}
+}
+
+func (ss SortStage) Stream(ee *ExecutionEnvironment, upstream Stream, done <-chan struct{}) Stream {
+	var sorted Stream
+	return func() (ExecutionResult, bool) {
+		if sorted == nil {
+			sorted = ss.sort(ee, upstream, done)
+		}
+		return sorted()
+	}
+}
+
+// sort reads every item of upstream and returns a stream of them in order.
+func (ss SortStage) sort(ee *ExecutionEnvironment, upstream Stream, done <-chan struct{}) Stream {
+	var runs []*spillFile
+	var once sync.Once
+	cleanup := func() {
+		once.Do(func() {
+			for _, run := range runs {
+				run.remove()
+			}
+		})
+	}
+
+	var run []keyed
+	var end ExecutionResult
+	spilling := ss.Limit > 0
+	for {
+		item, ok := upstream()
+		if !ok {
+			end = item
+			break
+		}
+		ee.collect(1)
+		run = append(run, keyOf(ee, ss.Key, item))
+		if spilling && len(run) >= ss.Limit {
+			slices.SortStableFunc(run, ss.compare)
+			spilled, err := spill(run)
+			if errors.Is(err, errCannotSpill) {
+				spilling = false
+				continue
+			}
+			if err != nil {
+				cleanup()
+				runtimeErrorf(lexer.Position{}, "%s: %s", ss.name(), err)
+			}
+			if len(runs) == 0 && done != nil {
+				// the files are removed when the pipeline is done, however
+				// far it read.
+				go func() {
+					<-done
+					cleanup()
+				}()
+			}
+			runs = append(runs, spilled)
+			ee.release(len(run))
+			run = run[:0]
+		}
+	}
+	slices.SortStableFunc(run, ss.compare)
+
+	if len(runs) == 0 {
+		return func() (ExecutionResult, bool) {
+			if len(run) == 0 {
+				return end, false
+			}
+			item := run[0].item
+			run = run[1:]
+			ee.release(1)
+			return item, true
+		}
+	}
+
+	m := &merge{compare: ss.compare}
+	for i, r := range runs {
+		if err := m.add(i, r.next); err != nil {
+			cleanup()
+			runtimeErrorf(lexer.Position{}, "%s: %s", ss.name(), err)
+		}
+	}
+	m.add(len(runs), func() (keyed, bool, error) {
+		if len(run) == 0 {
+			return keyed{}, false, nil
+		}
+		k := run[0]
+		run = run[1:]
+		ee.release(1)
+		return k, true, nil
+	})
+	return func() (ExecutionResult, bool) {
+		k, ok, err := m.next()
+		if err != nil {
+			runtimeErrorf(lexer.Position{}, "%s: %s", ss.name(), err)
+		}
+		if !ok {
+			cleanup()
+			return end, false
+		}
+		return k.item, true
+	}
+}
+
+// merge yields the items of several sorted runs in order. Of items with
+// equal keys, those of earlier runs come first, so that a merge of the runs
+// of a stable sort is stable.
+type merge struct {
+	compare func(a, b keyed) int
+	heads   []mergeHead
+}
+
+type mergeHead struct {
+	keyed
+	run  int
+	next func() (keyed, bool, error)
+}
+
+func (m *merge) Len() int { return len(m.heads) }
+
+func (m *merge) Less(i, j int) bool {
+	if c := m.compare(m.heads[i].keyed, m.heads[j].keyed); c != 0 {
+		return c < 0
+	}
+	return m.heads[i].run < m.heads[j].run
+}
+
+func (m *merge) Swap(i, j int) { m.heads[i], m.heads[j] = m.heads[j], m.heads[i] }
+
+func (m *merge) Push(x any) { m.heads = append(m.heads, x.(mergeHead)) }
+
+func (m *merge) Pop() any {
+	last := m.heads[len(m.heads)-1]
+	m.heads = m.heads[:len(m.heads)-1]
+	return last
+}
+
+// add adds a run, which is read with next.
+func (m *merge) add(run int, next func() (keyed, bool, error)) error {
+	k, ok, err := next()
+	if err != nil || !ok {
+		return err
+	}
+	heap.Push(m, mergeHead{k, run, next})
+	return nil
+}
+
+func (m *merge) next() (keyed, bool, error) {
+	if len(m.heads) == 0 {
+		return keyed{}, false, nil
+	}
+	head := m.heads[0]
+	k, ok, err := head.next()
+	if err != nil {
+		return keyed{}, false, err
+	}
+	if ok {
+		m.heads[0].keyed = k
+		heap.Fix(m, 0)
+	} else {
+		heap.Pop(m)
+	}
+	return head.keyed, true, nil
+}
+
+// spillFile is a run of keyed items written to a temporary file.
+type spillFile struct {
+	f  *os.File
+	in *bufio.Reader
+}
+
+// spill writes a run of keyed items to a temporary file.
+func spill(run []keyed) (*spillFile, error) {
+	f, err := os.CreateTemp("", "rozer-sort-*")
+	if err != nil {
+		return nil, err
+	}
+	sf := &spillFile{f: f}
+	out := bufio.NewWriter(f)
+	for _, k := range run {
+		err = encodeSpilled(out, k.key)
+		if err == nil {
+			err = encodeSpilled(out, k.item)
+		}
+		if err != nil {
+			sf.remove()
+			return nil, err
+		}
+	}
+	if err = out.Flush(); err == nil {
+		_, err = f.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		sf.remove()
+		return nil, err
+	}
+	sf.in = bufio.NewReader(f)
+	return sf, nil
+}
+
+// next reads the next keyed item of the run.
+func (sf *spillFile) next() (keyed, bool, error) {
+	key, err := decodeSpilled(sf.in)
+	if err == io.EOF {
+		return keyed{}, false, nil
+	}
+	if err != nil {
+		return keyed{}, false, err
+	}
+	item, err := decodeSpilled(sf.in)
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		return keyed{}, false, err
+	}
+	return keyed{key, item}, true, nil
+}
+
+func (sf *spillFile) remove() {
+	sf.f.Close()
+	os.Remove(sf.f.Name())
+}
+
+// TopStage is a pipeline stage that yields the N items with the greatest keys
+// the Key function gives them, greatest first, once it has read every item.
+// Of items with equal keys the earliest are kept, in their order. Only N items
+// are held at once. Used outside of a pipeline it finds the top N items of a
+// list.
+type TopStage struct {
+	N   int
+	Key Parameterized
+}
+
+func (ts TopStage) Apply(ee *ExecutionEnvironment) ExecutionResult {
+	list, ok := ee.Get("items").(ListResult)
+	if !ok {
+		runtimeErrorf(lexer.Position{}, "top: expecting a list, got %s", FormatValue(ee.Get("items")))
+	}
+	items, _ := drainStream(ts.Stream(ee, listStream(list), nil))
+	return ListResult{Items: items}
+}
+
+func (ts TopStage) ParameterNames() []string {
+	return []string{"items"}
+}
+
+func (ts TopStage) Execute(ee *ExecutionEnvironment) ExecutionResult {
+	return ts
+}
+
+func (ts TopStage) Type(typeMap TypeMap) Type {
+	return TypeFunction
+}
+
+func (ts TopStage) ListRep() []any {
+	return []any{"top", ts.N, ts.Key.ListRep()}
+}
+
+func (ts TopStage) Stream(ee *ExecutionEnvironment, upstream Stream, done <-chan struct{}) Stream {
+	var top []ExecutionResult
+	var end ExecutionResult
+	read := false
+	return func() (ExecutionResult, bool) {
+		if !read {
+			top, end = ts.read(ee, upstream)
+			read = true
+		}
+		if len(top) == 0 {
+			return end, false
+		}
+		item := top[0]
+		top = top[1:]
+		ee.release(1)
+		return item, true
+	}
+}
+
+// read reads every item of upstream, returning the top items in order and
+// the tag that ended the stream.
+func (ts TopStage) read(ee *ExecutionEnvironment, upstream Stream) ([]ExecutionResult, ExecutionResult) {
+	// h holds the top items so far, the least of them first: the one with
+	// the least key and, of those, the latest.
+	h := &topHeap{}
+	for seq := 0; ; seq++ {
+		item, ok := upstream()
+		if !ok {
+			top := make([]ExecutionResult, h.Len())
+			for i := len(top) - 1; i >= 0; i-- {
+				top[i] = heap.Pop(h).(topItem).item
+			}
+			return top, item
+		}
+		if ts.N == 0 {
+			continue
+		}
+		t := topItem{keyOf(ee, ts.Key, item), seq}
+		if h.Len() < ts.N {
+			ee.collect(1)
+			heap.Push(h, t)
+		} else if compareKeys("top", t.key, h.items[0].key) > 0 {
+			h.items[0] = t
+			heap.Fix(h, 0)
+		}
+	}
+}
+
+type topItem struct {
+	keyed
+	seq int
+}
+
+type topHeap struct {
+	items []topItem
+}
+
+func (h *topHeap) Len() int { return len(h.items) }
+
+func (h *topHeap) Less(i, j int) bool {
+	if c := compareKeys("top", h.items[i].key, h.items[j].key); c != 0 {
+		return c < 0
+	}
+	return h.items[i].seq > h.items[j].seq
+}
+
+func (h *topHeap) Swap(i, j int) { h.items[i], h.items[j] = h.items[j], h.items[i] }
+
+func (h *topHeap) Push(x any) { h.items = append(h.items, x.(topItem)) }
+
+func (h *topHeap) Pop() any {
+	last := h.items[len(h.items)-1]
+	h.items = h.items[:len(h.items)-1]
+	return last
+}
+
+// listStream yields the items of a list.
+func listStream(list ListResult) Stream {
+	i := 0
+	return func() (ExecutionResult, bool) {
+		if i == len(list.Items) {
+			return TagComplete, false
+		}
+		i++
+		return list.Items[i-1], true
+	}
+}
+
+// drainStream reads every item of a stream, returning them and the tag that
+// ended it.
+func drainStream(stream Stream) ([]any, ExecutionResult) {
+	items := []any{}
+	for {
+		item, ok := stream()
+		if !ok {
+			return items, item
+		}
+		items = append(items, item)
+	}
+}
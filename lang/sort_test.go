@@ -0,0 +1,177 @@
+package lang
+
+import (
+	"bufio"
+	"bytes"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/pdk/rozer"
+)
+
+func keyFn(t *testing.T, src string) Parameterized {
+	return compileForTest(t, "key", src).ExecuteProgram().(Parameterized)
+}
+
+func TestSortSpills(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("TMPDIR", dir)
+
+	for _, descending := range []bool{false, true} {
+		done := make(chan struct{})
+		ss := SortStage{Key: keyFn(t, "fn(x) {\n    x % 7\n}\n"), Descending: descending, Limit: 10}
+		items, end := drain(ss.Stream(NewExecutionEnvironment(), countingStream(100), done))
+
+		if end != TagComplete {
+			t.Errorf("stream ended with %v, want %v", end, TagComplete)
+		}
+		if len(items) != 100 {
+			t.Fatalf("got %d items, want 100", len(items))
+		}
+		for i := 1; i < len(items); i++ {
+			a, b := items[i-1].(IntegerValue), items[i].(IntegerValue)
+			if descending {
+				a, b = b, a
+			}
+			if a%7 > b%7 || (a%7 == b%7 && items[i-1].(IntegerValue) > items[i].(IntegerValue)) {
+				t.Fatalf("descending %v: %d is before %d", descending, items[i-1], items[i])
+			}
+		}
+		close(done)
+	}
+
+	// the files are removed by the time the last item is read.
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 0 {
+		t.Errorf("%d spilled files left", len(files))
+	}
+}
+
+func TestSortRemovesFilesWhenDone(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("TMPDIR", dir)
+
+	done := make(chan struct{})
+	ss := SortStage{Key: keyFn(t, "fn(x) {\n    0 - x\n}\n"), Limit: 10}
+	stream := ss.Stream(NewExecutionEnvironment(), countingStream(50), done)
+	if item, _ := stream(); item != IntegerValue(50) {
+		t.Errorf("first item is %v, want 50", item)
+	}
+	if files, _ := os.ReadDir(dir); len(files) != 5 {
+		t.Errorf("%d spilled files, want 5", len(files))
+	}
+
+	close(done)
+	for range 100 {
+		if files, _ := os.ReadDir(dir); len(files) == 0 {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Errorf("spilled files not removed when the pipeline was done")
+}
+
+func TestSortKeepsUnspillableItems(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("TMPDIR", dir)
+
+	done := make(chan struct{})
+	defer close(done)
+
+	// item 25 is a function, so the run holding it cannot be spilled.
+	upstream := countingStream(50)
+	stream := func() (ExecutionResult, bool) {
+		item, ok := upstream()
+		if ok && item == IntegerValue(25) {
+			return BuiltinFunction{Name: "f"}, true
+		}
+		return item, ok
+	}
+	ss := SortStage{Key: keyFn(t, "fn(x) {\n    0\n}\n"), Limit: 10}
+	items, end := drain(ss.Stream(NewExecutionEnvironment(), stream, done))
+	if end != TagComplete {
+		t.Errorf("stream ended with %v, want %v", end, TagComplete)
+	}
+	if len(items) != 50 {
+		t.Fatalf("got %d items, want 50", len(items))
+	}
+	for i, item := range items {
+		if i == 24 {
+			if _, ok := item.(BuiltinFunction); !ok {
+				t.Errorf("item 24 is %s, want the function", FormatValue(item))
+			}
+		} else if item != IntegerValue(i+1) {
+			t.Errorf("item %d is %s, want %d", i, FormatValue(item), i+1)
+		}
+	}
+}
+
+func TestTop(t *testing.T) {
+	done := make(chan struct{})
+	defer close(done)
+
+	ts := TopStage{N: 3, Key: keyFn(t, "fn(x) {\n    x % 10\n}\n")}
+	items, end := drain(ts.Stream(NewExecutionEnvironment(), countingStream(25), done))
+	if end != TagComplete {
+		t.Errorf("stream ended with %v, want %v", end, TagComplete)
+	}
+	want := []ExecutionResult{IntegerValue(9), IntegerValue(19), IntegerValue(8)}
+	if len(items) != len(want) {
+		t.Fatalf("got %v, want %v", items, want)
+	}
+	for i := range want {
+		if items[i] != want[i] {
+			t.Fatalf("got %v, want %v", items, want)
+		}
+	}
+}
+
+func TestSortKeysOfOtherTypes(t *testing.T) {
+	ss := SortStage{Key: keyFn(t, "fn(x) {\n    x\n}\n")}
+	ee := NewExecutionEnvironment().NewLocalEnvironment()
+	ee.Set("items", ListResult{Items: []any{IntegerValue(1), StringValue("a")}})
+	err := runStage(func() { ss.Apply(ee) })
+	if err == nil {
+		t.Errorf("ordered an integer and a string")
+	}
+}
+
+func TestSpilledValues(t *testing.T) {
+	values := []ExecutionResult{
+		BoolValue(true),
+		IntegerValue(-300),
+		FloatValue(2.5),
+		StringValue("abc"),
+		TagNull,
+		ListResult{Items: []any{IntegerValue(1), KeyValueResult{StringValue("a"), ListResult{}}}},
+		RecordValue{rozer.NewObject().Put("a", int64(1)).Put("b", rozer.New().Append("x"))},
+	}
+
+	var buf bytes.Buffer
+	out := bufio.NewWriter(&buf)
+	for _, v := range values {
+		if err := encodeSpilled(out, v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	out.Flush()
+
+	in := bufio.NewReader(&buf)
+	for _, want := range values {
+		got, err := decodeSpilled(in)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !Equal(got, want) {
+			t.Errorf("got %s, want %s", FormatValue(got), FormatValue(want))
+		}
+	}
+
+	if err := encodeSpilled(out, BuiltinFunction{Name: "f"}); err == nil {
+		t.Errorf("spilled a function")
+	}
+}
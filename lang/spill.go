@@ -0,0 +1,165 @@
+package lang
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+
+	"github.com/pdk/rozer"
+)
+
+// The kinds of spilled values.
+const (
+	spillBool byte = iota
+	spillInteger
+	spillFloat
+	spillString
+	spillTag
+	spillList
+	spillKeyValue
+	spillRecord
+)
+
+// errCannotSpill is returned by encodeSpilled for a value it cannot write.
+var errCannotSpill = errors.New("cannot spill to disk")
+
+// encodeSpilled writes a value to a file of spilled items. Records are
+// written in MessagePack, and rows of batches as records. Functions, modules,
+// batches and records that MessagePack cannot hold, such as those with an
+// integer beyond 64 bits, cannot be spilled.
+func encodeSpilled(out *bufio.Writer, value ExecutionResult) error {
+	switch v := value.(type) {
+	case BoolValue:
+		b := byte(0)
+		if v {
+			b = 1
+		}
+		out.Write([]byte{spillBool, b})
+	case IntegerValue:
+		out.WriteByte(spillInteger)
+		out.Write(binary.AppendVarint(nil, int64(v)))
+	case FloatValue:
+		out.WriteByte(spillFloat)
+		out.Write(binary.LittleEndian.AppendUint64(nil, math.Float64bits(float64(v))))
+	case StringValue:
+		out.WriteByte(spillString)
+		writeSpilledString(out, string(v))
+	case TagValue:
+		out.WriteByte(spillTag)
+		writeSpilledString(out, v.Value)
+	case ListResult:
+		out.WriteByte(spillList)
+		out.Write(binary.AppendUvarint(nil, uint64(len(v.Items))))
+		for _, item := range v.Items {
+			if err := encodeSpilled(out, item); err != nil {
+				return err
+			}
+		}
+	case KeyValueResult:
+		out.WriteByte(spillKeyValue)
+		if err := encodeSpilled(out, v.Key); err != nil {
+			return err
+		}
+		return encodeSpilled(out, v.Value)
+	case RecordValue:
+		return encodeSpilledRecord(out, v.Roze)
+	case RowValue:
+		return encodeSpilledRecord(out, v.Batch.Row(v.Row))
+	default:
+		return fmt.Errorf("%w: %s", errCannotSpill, FormatValue(value))
+	}
+	return nil
+}
+
+func encodeSpilledRecord(out *bufio.Writer, r *rozer.Roze) error {
+	data, err := rozer.MessagePack.Marshal(r)
+	if err != nil {
+		return fmt.Errorf("%w: %s", errCannotSpill, err)
+	}
+	out.WriteByte(spillRecord)
+	writeSpilledString(out, string(data))
+	return nil
+}
+
+func writeSpilledString(out *bufio.Writer, s string) {
+	out.Write(binary.AppendUvarint(nil, uint64(len(s))))
+	out.WriteString(s)
+}
+
+// decodeSpilled reads a value written by encodeSpilled. It returns io.EOF if
+// there are no more.
+func decodeSpilled(in *bufio.Reader) (ExecutionResult, error) {
+	kind, err := in.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	value, err := decodeSpilledKind(in, kind)
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return value, err
+}
+
+func decodeSpilledKind(in *bufio.Reader, kind byte) (ExecutionResult, error) {
+	switch kind {
+	case spillBool:
+		b, err := in.ReadByte()
+		return BoolValue(b == 1), err
+	case spillInteger:
+		i, err := binary.ReadVarint(in)
+		return IntegerValue(i), err
+	case spillFloat:
+		var b [8]byte
+		_, err := io.ReadFull(in, b[:])
+		return FloatValue(math.Float64frombits(binary.LittleEndian.Uint64(b[:]))), err
+	case spillString:
+		s, err := readSpilledString(in)
+		return StringValue(s), err
+	case spillTag:
+		s, err := readSpilledString(in)
+		return TagValue{Value: s}, err
+	case spillList:
+		n, err := binary.ReadUvarint(in)
+		if err != nil {
+			return nil, err
+		}
+		list := ListResult{Items: make([]any, 0, min(n, 1024))}
+		for range n {
+			item, err := decodeSpilled(in)
+			if err != nil {
+				return nil, err
+			}
+			list.Items = append(list.Items, item)
+		}
+		return list, nil
+	case spillKeyValue:
+		key, err := decodeSpilled(in)
+		if err != nil {
+			return nil, err
+		}
+		value, err := decodeSpilled(in)
+		return KeyValueResult{Key: key, Value: value}, err
+	case spillRecord:
+		s, err := readSpilledString(in)
+		if err != nil {
+			return nil, err
+		}
+		r, err := rozer.MessagePack.Unmarshal([]byte(s))
+		return RecordValue{r}, err
+	default:
+		return nil, fmt.Errorf("invalid spilled value of kind %d", kind)
+	}
+}
+
+func readSpilledString(in *bufio.Reader) (string, error) {
+	n, err := binary.ReadUvarint(in)
+	if err != nil {
+		return "", err
+	}
+	b := make([]byte, n)
+	_, err = io.ReadFull(in, b)
+	return string(b), err
+}
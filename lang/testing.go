@@ -0,0 +1,152 @@
+package lang
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/alecthomas/participle/v2/lexer"
+)
+
+func init() {
+	registerBuiltin(BuiltinFunction{
+		Name:   "assert_eq",
+		Params: []string{"expected", "actual"},
+		Func: func(ee *ExecutionEnvironment) ExecutionResult {
+			expected, actual := ee.Get("expected"), ee.Get("actual")
+			if !Equal(expected, actual) {
+				panic(abort{&AssertionError{
+					Expected: expected,
+					Actual:   actual,
+					Diff:     Diff(expected, actual),
+				}})
+			}
+			return BoolValue(true)
+		},
+	})
+}
+
+// AssertionError is raised by assert_eq when its arguments are not Equal.
+type AssertionError struct {
+	Pos      lexer.Position
+	Expected any
+	Actual   any
+	Diff     []string
+}
+
+func (ae *AssertionError) Error() string {
+	msg := fmt.Sprintf("assertion failed: expected %s, got %s", FormatValue(ae.Expected), FormatValue(ae.Actual))
+	if ae.Pos.Line == 0 {
+		return msg
+	}
+	return fmt.Sprintf("%s at %s", msg, ae.Pos)
+}
+
+// TestExecute is a compiled #test block.
+type TestExecute struct {
+	*TestBlock
+	ExecutableBlock
+}
+
+func (te TestExecute) Apply(ee *ExecutionEnvironment) ExecutionResult {
+	return te.ExecutableBlock.Execute(ee)
+}
+
+func (te TestExecute) ParameterNames() []string {
+	return []string{}
+}
+
+func (tb *TestBlock) Compile(typeMap TypeMap) (Executable, CompileErrors) {
+	var errs CompileErrors
+
+	ex := errs.Collect(tb.Body.Compile(typeMap))
+
+	return TestExecute{tb, ex.(ExecutableBlock)}, errs
+}
+
+// TestCase is a test found in a program: a named function whose name starts
+// with test_, or a #test block. Each parameter of a test function is given
+// the value of the fixture of the same name.
+type TestCase struct {
+	Name     string
+	Pos      lexer.Position
+	Function Parameterized
+}
+
+// TestCases returns the tests of the program in source order.
+func (pe ProgramExecute) TestCases() []TestCase {
+	cases := []TestCase{}
+	for _, fe := range pe.NamedFunctions {
+		if strings.HasPrefix(*fe.NamedFunction.Name, "test_") {
+			cases = append(cases, TestCase{*fe.NamedFunction.Name, fe.NamedFunction.Pos, fe})
+		}
+	}
+	for _, te := range pe.Tests {
+		name := fmt.Sprintf("test at line %d", te.TestBlock.Pos.Line)
+		if te.TestBlock.Name != nil {
+			name = *te.TestBlock.Name
+		}
+		cases = append(cases, TestCase{name, te.TestBlock.Pos, te})
+	}
+	sort.SliceStable(cases, func(i, j int) bool {
+		return cases[i].Pos.Offset < cases[j].Pos.Offset
+	})
+	return cases
+}
+
+// Fixtures supplies the value of the fixture with the given name, for fixtures
+// the program does not define a fixture_ function for.
+type Fixtures func(name string) (any, error)
+
+// TestResult is the outcome of running a TestCase. Err is nil if the test
+// passed.
+type TestResult struct {
+	TestCase
+	Err     error
+	Elapsed time.Duration
+}
+
+// RunTest runs one test of the program in a new environment holding the
+// program's imports and named functions. Top level commands of the program
+// are not run. The value of each fixture is the result of calling the
+// program's fixture_<name> function, if it has one, and otherwise comes from
+// fixtures.
+func (pe ProgramExecute) RunTest(ctx context.Context, limits Limits, tc TestCase, fixtures Fixtures) TestResult {
+	start := time.Now()
+	err := pe.runTest(ctx, limits, tc, fixtures)
+	return TestResult{tc, err, time.Since(start)}
+}
+
+func (pe ProgramExecute) runTest(ctx context.Context, limits Limits, tc TestCase, fixtures Fixtures) (err error) {
+	ctx, cancel := limits.context(ctx)
+	defer cancel()
+
+	env := NewProgramEnvironment(ctx, limits)
+	defer recoverAbort(&err)
+	pe.define(env)
+
+	local := env.enter()
+	for _, param := range tc.Function.ParameterNames() {
+		value, err := fixture(env, param, fixtures)
+		if err != nil {
+			return fmt.Errorf("fixture %s: %w", param, err)
+		}
+		local.Set(param, value)
+	}
+
+	tc.Function.Apply(local)
+	return nil
+}
+
+func fixture(env *ExecutionEnvironment, name string, fixtures Fixtures) (any, error) {
+	fn, ok := env.Get("fixture_" + name).(Parameterized)
+	if ok && len(fn.ParameterNames()) == 0 {
+		return fn.Apply(env.enter()), nil
+	}
+	if fixtures == nil {
+		return nil, fmt.Errorf("no fixture_%s function", name)
+	}
+	return fixtures(name)
+}
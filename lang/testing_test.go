@@ -0,0 +1,87 @@
+package lang
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDiff(t *testing.T) {
+	expected := ListResult{Items: []any{
+		IntegerValue(1),
+		KeyValueResult{StringValue("name"), StringValue("a")},
+		IntegerValue(3),
+	}}
+	actual := ListResult{Items: []any{
+		FloatValue(1),
+		KeyValueResult{StringValue("name"), StringValue("b")},
+	}}
+
+	want := []string{
+		"[0]: expected 1, got 1.0",
+		`[1].name: expected "a", got "b"`,
+		"[2]: missing 3",
+	}
+	if got := Diff(expected, actual); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if Diff(expected, expected) != nil {
+		t.Errorf("expected no differences between equal values")
+	}
+}
+
+func TestRunTest(t *testing.T) {
+	source := `fn double(x) {
+    x * 2
+}
+
+fn fixture_n() {
+    21
+}
+
+fn test_fixture(n, rows) {
+    assert_eq(42, double(n))
+    assert_eq([1, 2], rows)
+}
+
+#test "fails" {
+    assert_eq(1, double(1))
+}
+
+double(1) >> fn(x) {
+    assert_eq(0, 1)
+}
+`
+	program, diagnostics := ParseAll("tests.roz", []byte(source))
+	if len(diagnostics) > 0 {
+		t.Fatal(diagnostics)
+	}
+	pe, errs := program.Compile(TypeMap{})
+	if errs.Len() > 0 {
+		t.Fatal(*errs.Errs)
+	}
+
+	fixtures := func(name string) (any, error) {
+		return ValueFromJSON([]byte("[1, 2]"))
+	}
+
+	cases := pe.TestCases()
+	if len(cases) != 2 || cases[0].Name != "test_fixture" || cases[1].Name != "fails" {
+		t.Fatalf("got test cases %v", cases)
+	}
+
+	result := pe.RunTest(context.Background(), Limits{}, cases[0], fixtures)
+	if result.Err != nil {
+		t.Errorf("test_fixture: %s", result.Err)
+	}
+
+	result = pe.RunTest(context.Background(), Limits{}, cases[1], fixtures)
+	ae, ok := result.Err.(*AssertionError)
+	if !ok {
+		t.Fatalf("fails: got %v, want an assertion error", result.Err)
+	}
+	if ae.Pos.Line != 15 || !strings.Contains(ae.Error(), "expected 1, got 2") {
+		t.Errorf("fails: got %s", ae)
+	}
+}
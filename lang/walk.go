@@ -0,0 +1,35 @@
+package lang
+
+import (
+	"reflect"
+)
+
+// Walk calls fn for node and then for each syntax tree node beneath it, depth
+// first and in source order. Nodes are pointers to the grammar structs, such as
+// *NamedFunction or *Invocation. If fn returns false the nodes beneath that
+// node are skipped.
+func Walk(node any, fn func(node any) bool) {
+	walk(reflect.ValueOf(node), fn)
+}
+
+func walk(v reflect.Value, fn func(node any) bool) {
+	if v.Kind() != reflect.Pointer || v.IsNil() || v.Elem().Kind() != reflect.Struct {
+		return
+	}
+	if !fn(v.Interface()) {
+		return
+	}
+
+	v = v.Elem()
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Field(i)
+		switch field.Kind() {
+		case reflect.Pointer:
+			walk(field, fn)
+		case reflect.Slice:
+			for j := 0; j < field.Len(); j++ {
+				walk(field.Index(j), fn)
+			}
+		}
+	}
+}
@@ -0,0 +1,546 @@
+package lang
+
+import (
+	"math"
+	"slices"
+	"time"
+
+	"github.com/alecthomas/participle/v2/lexer"
+	"github.com/pdk/rozer"
+)
+
+func init() {
+	registerBuiltin(BuiltinFunction{
+		Name:   "row_number",
+		Params: []string{"field"},
+		Func: func(ee *ExecutionEnvironment) ExecutionResult {
+			return RunningStage{Kind: "row_number", Field: fieldArgument(ee, "row_number")}
+		},
+	})
+	registerBuiltin(BuiltinFunction{
+		Name:   "running_sum",
+		Params: []string{"field", "value"},
+		Func: func(ee *ExecutionEnvironment) ExecutionResult {
+			return RunningStage{Kind: "running_sum", Field: fieldArgument(ee, "running_sum"), Value: functionArgument(ee, "running_sum", "value")}
+		},
+	})
+	for _, kind := range []string{"lag", "lead"} {
+		registerBuiltin(BuiltinFunction{
+			Name:   kind,
+			Params: []string{"field", "n", "value"},
+			Func: func(ee *ExecutionEnvironment) ExecutionResult {
+				return RunningStage{Kind: kind, Field: fieldArgument(ee, kind), N: countArgument(ee, kind, "n"), Value: functionArgument(ee, kind, "value")}
+			},
+		})
+	}
+	registerBuiltin(BuiltinFunction{
+		Name:   "tumbling",
+		Params: []string{"n", "aggregate"},
+		Func: func(ee *ExecutionEnvironment) ExecutionResult {
+			n := countArgument(ee, "tumbling", "n")
+			return CountWindowStage{Size: n, Step: n, Aggregate: functionArgument(ee, "tumbling", "aggregate")}
+		},
+	})
+	registerBuiltin(BuiltinFunction{
+		Name:   "sliding",
+		Params: []string{"n", "step", "aggregate"},
+		Func: func(ee *ExecutionEnvironment) ExecutionResult {
+			return CountWindowStage{Size: countArgument(ee, "sliding", "n"), Step: countArgument(ee, "sliding", "step"), Aggregate: functionArgument(ee, "sliding", "aggregate")}
+		},
+	})
+	registerBuiltin(BuiltinFunction{
+		Name:   "tumbling_time",
+		Params: []string{"field", "span", "aggregate"},
+		Func: func(ee *ExecutionEnvironment) ExecutionResult {
+			span := spanArgument(ee, "tumbling_time", "span")
+			return TimeWindowStage{Field: fieldArgument(ee, "tumbling_time"), Span: span, Step: span, Aggregate: functionArgument(ee, "tumbling_time", "aggregate")}
+		},
+	})
+	registerBuiltin(BuiltinFunction{
+		Name:   "sliding_time",
+		Params: []string{"field", "span", "step", "aggregate"},
+		Func: func(ee *ExecutionEnvironment) ExecutionResult {
+			return TimeWindowStage{Field: fieldArgument(ee, "sliding_time"), Span: spanArgument(ee, "sliding_time", "span"), Step: spanArgument(ee, "sliding_time", "step"), Aggregate: functionArgument(ee, "sliding_time", "aggregate")}
+		},
+	})
+}
+
+func fieldArgument(ee *ExecutionEnvironment, name string) string {
+	field, ok := ee.Get("field").(StringValue)
+	if !ok {
+		runtimeErrorf(lexer.Position{}, "%s: field must be a string, got %s", name, FormatValue(ee.Get("field")))
+	}
+	return string(field)
+}
+
+func countArgument(ee *ExecutionEnvironment, name, param string) int {
+	n, ok := ee.Get(param).(IntegerValue)
+	if !ok || n < 1 {
+		runtimeErrorf(lexer.Position{}, "%s: %s must be a positive integer, got %s", name, param, FormatValue(ee.Get(param)))
+	}
+	return int(n)
+}
+
+func spanArgument(ee *ExecutionEnvironment, name, param string) float64 {
+	var span float64
+	switch v := ee.Get(param).(type) {
+	case IntegerValue:
+		span = float64(v)
+	case FloatValue:
+		span = float64(v)
+	}
+	if !(span > 0) {
+		runtimeErrorf(lexer.Position{}, "%s: %s must be a positive number, got %s", name, param, FormatValue(ee.Get(param)))
+	}
+	return span
+}
+
+func functionArgument(ee *ExecutionEnvironment, name, param string) Parameterized {
+	fn, ok := ee.Get(param).(Parameterized)
+	if !ok || len(fn.ParameterNames()) != 1 {
+		runtimeErrorf(lexer.Position{}, "%s: expecting a %s function of 1 argument, got %s", name, param, FormatValue(ee.Get(param)))
+	}
+	return fn
+}
+
+// call applies a function of one argument.
+func call(ee *ExecutionEnvironment, fn Parameterized, arg ExecutionResult) ExecutionResult {
+	return keyOf(ee, fn, arg).key
+}
+
+// withField returns a copy of a record with a field added, or of a record of
+// the item as "value" if it is not a record.
+func withField(name string, item ExecutionResult, field string, value ExecutionResult) RecordValue {
+	v, err := RozeValue(value)
+	if err != nil {
+		runtimeErrorf(lexer.Position{}, "%s: %s", name, err)
+	}
+	var r *rozer.Roze
+	switch item := item.(type) {
+	case RecordValue:
+		r = copyRecord(item.Roze)
+	case RowValue:
+		r = item.Batch.Row(item.Row)
+	default:
+		iv, err := RozeValue(item)
+		if err != nil {
+			runtimeErrorf(lexer.Position{}, "%s: %s", name, err)
+		}
+		r = rozer.NewObject().Put("value", iv)
+	}
+	return RecordValue{r.Put(field, v)}
+}
+
+// RunningStage is a pipeline stage that yields each item as a record with a
+// Field added, found from the items so far. The Kind of stage says what the
+// field holds:
+//
+//   - "row_number": the number of the item, from 1.
+//   - "running_sum": the sum of Value, applied to each item so far. #null
+//     values are skipped.
+//   - "lag": Value applied to the item N before, or #null if there is none.
+//   - "lead": Value applied to the item N after, or #null if there is none.
+//     The last N items are only yielded once the stream is #complete.
+//
+// An item that is not a record is yielded as a record of it named "value"
+// and the field. Used outside of a pipeline it does so to the items of a
+// list.
+type RunningStage struct {
+	Kind  string
+	Field string
+	N     int
+	Value Parameterized
+}
+
+func (rs RunningStage) Apply(ee *ExecutionEnvironment) ExecutionResult {
+	list, ok := ee.Get("items").(ListResult)
+	if !ok {
+		runtimeErrorf(lexer.Position{}, "%s: expecting a list, got %s", rs.Kind, FormatValue(ee.Get("items")))
+	}
+	items, _ := drainStream(rs.Stream(ee, listStream(list), nil))
+	return ListResult{Items: items}
+}
+
+func (rs RunningStage) ParameterNames() []string {
+	return []string{"items"}
+}
+
+func (rs RunningStage) Execute(ee *ExecutionEnvironment) ExecutionResult {
+	return rs
+}
+
+func (rs RunningStage) Type(typeMap TypeMap) Type {
+	return TypeFunction
+}
+
+func (rs RunningStage) ListRep() []any {
+	rep := []any{rs.Kind, rs.Field}
+	if rs.Kind == "lag" || rs.Kind == "lead" {
+		rep = append(rep, rs.N)
+	}
+	if rs.Value != nil {
+		rep = append(rep, rs.Value.ListRep())
+	}
+	return rep
+}
+
+func (rs RunningStage) Stream(ee *ExecutionEnvironment, upstream Stream, done <-chan struct{}) Stream {
+	if rs.Kind == "lead" {
+		return rs.lead(ee, upstream)
+	}
+
+	n := 0
+	var sum ExecutionResult = IntegerValue(0)
+	// previous holds the values of the last N items, for lag.
+	var previous []ExecutionResult
+	return func() (ExecutionResult, bool) {
+		item, ok := upstream()
+		if !ok {
+			return item, false
+		}
+		n++
+		var value ExecutionResult
+		switch rs.Kind {
+		case "row_number":
+			value = IntegerValue(n)
+		case "running_sum":
+			sum = addNumbers(rs.Kind, sum, call(ee, rs.Value, item))
+			value = sum
+		case "lag":
+			value = TagNull
+			if len(previous) == rs.N {
+				value, previous = previous[0], previous[1:]
+				ee.release(1)
+			}
+			ee.collect(1)
+			previous = append(previous, call(ee, rs.Value, item))
+		}
+		return withField(rs.Kind, item, rs.Field, value), true
+	}
+}
+
+// lead yields each item once the N after it have been read, or the stream is
+// #complete.
+func (rs RunningStage) lead(ee *ExecutionEnvironment, upstream Stream) Stream {
+	var items, values []ExecutionResult
+	var end ExecutionResult
+	ended := false
+	return func() (ExecutionResult, bool) {
+		for !ended && len(items) <= rs.N {
+			item, ok := upstream()
+			if !ok {
+				end, ended = item, true
+				if end != TagComplete {
+					ee.release(len(items))
+					items = nil
+				}
+				break
+			}
+			ee.collect(1)
+			items = append(items, item)
+			values = append(values, call(ee, rs.Value, item))
+		}
+		if len(items) == 0 {
+			return end, false
+		}
+		var value ExecutionResult = TagNull
+		if len(values) > rs.N {
+			value = values[rs.N]
+		}
+		item := items[0]
+		items, values = items[1:], values[1:]
+		ee.release(1)
+		return withField(rs.Kind, item, rs.Field, value), true
+	}
+}
+
+// addNumbers adds b to the sum a, as a float once either is one.
+func addNumbers(name string, a, b ExecutionResult) ExecutionResult {
+	switch b := b.(type) {
+	case IntegerValue:
+		if a, ok := a.(IntegerValue); ok {
+			return a + b
+		}
+		return a.(FloatValue) + FloatValue(b)
+	case FloatValue:
+		if a, ok := a.(IntegerValue); ok {
+			return FloatValue(a) + b
+		}
+		return a.(FloatValue) + b
+	default:
+		if isNull(b) {
+			return a
+		}
+		runtimeErrorf(lexer.Position{}, "%s: expecting a number, got %s", name, FormatValue(b))
+		return nil
+	}
+}
+
+// window returns the value an aggregate function is given for a window.
+func window(start, end ExecutionResult, items []any) ListResult {
+	return ListResult{Items: []any{
+		KeyValueResult{StringValue("start"), start},
+		KeyValueResult{StringValue("end"), end},
+		KeyValueResult{StringValue("items"), ListResult{Items: slices.Clone(items)}},
+	}}
+}
+
+// CountWindowStage is a pipeline stage that yields Aggregate applied to
+// each window of Size items, a window starting every Step items; it is
+// tumbling if Step is Size, and sliding if less. Aggregate is given the
+// window as ["start": s, "end": e, "items": [...]], where s and e are the
+// positions of its first item and of the item after its last, counting from
+// 0. When the stream is #complete, the items no window has held yet are
+// given to Aggregate as a last, smaller, window. Used outside of a pipeline
+// it does so to the items of a list.
+type CountWindowStage struct {
+	Size      int
+	Step      int
+	Aggregate Parameterized
+}
+
+func (cs CountWindowStage) name() string {
+	if cs.Size == cs.Step {
+		return "tumbling"
+	}
+	return "sliding"
+}
+
+func (cs CountWindowStage) Apply(ee *ExecutionEnvironment) ExecutionResult {
+	list, ok := ee.Get("items").(ListResult)
+	if !ok {
+		runtimeErrorf(lexer.Position{}, "%s: expecting a list, got %s", cs.name(), FormatValue(ee.Get("items")))
+	}
+	items, _ := drainStream(cs.Stream(ee, listStream(list), nil))
+	return ListResult{Items: items}
+}
+
+func (cs CountWindowStage) ParameterNames() []string {
+	return []string{"items"}
+}
+
+func (cs CountWindowStage) Execute(ee *ExecutionEnvironment) ExecutionResult {
+	return cs
+}
+
+func (cs CountWindowStage) Type(typeMap TypeMap) Type {
+	return TypeFunction
+}
+
+func (cs CountWindowStage) ListRep() []any {
+	return []any{cs.name(), cs.Size, cs.Step, cs.Aggregate.ListRep()}
+}
+
+func (cs CountWindowStage) Stream(ee *ExecutionEnvironment, upstream Stream, done <-chan struct{}) Stream {
+	// items holds the items from position start, where the next window
+	// starts, up to position read.
+	var items []any
+	start, read, yielded := 0, 0, 0
+	var end ExecutionResult
+	ended := false
+	return func() (ExecutionResult, bool) {
+		for !ended {
+			item, ok := upstream()
+			if !ok {
+				end, ended = item, true
+				break
+			}
+			if read >= start {
+				// items between windows, when Step is more than Size, are
+				// in none.
+				ee.collect(1)
+				items = append(items, item)
+			}
+			read++
+			if read == start+cs.Size {
+				w := window(IntegerValue(start), IntegerValue(read), items)
+				yielded = read
+				start += cs.Step
+				dropped := min(cs.Step, len(items))
+				ee.release(dropped)
+				items = items[dropped:]
+				return call(ee, cs.Aggregate, w), true
+			}
+		}
+		if end == TagComplete && len(items) > 0 && read > yielded {
+			w := window(IntegerValue(start), IntegerValue(read), items)
+			ee.release(len(items))
+			items = nil
+			return call(ee, cs.Aggregate, w), true
+		}
+		return end, false
+	}
+}
+
+// TimeWindowStage is a pipeline stage that yields Aggregate applied to each
+// window of records whose Field, a timestamp, is at least its start and less
+// than its start plus Span. Windows start at each multiple of Step; they are
+// tumbling if Step is Span, and sliding if less. A timestamp is a number, or
+// an RFC 3339 string, which is taken as seconds since 1970, and Span and
+// Step are in the same units. Records must be in order of time.
+//
+// Aggregate is given the window as ["start": s, "end": e, "items": [...]].
+// Windows without records are skipped. A window is yielded once a record
+// after its end is read, or when the stream is #complete. Used outside of a
+// pipeline it does so to the items of a list.
+type TimeWindowStage struct {
+	Field     string
+	Span      float64
+	Step      float64
+	Aggregate Parameterized
+}
+
+func (ts TimeWindowStage) name() string {
+	if ts.Span == ts.Step {
+		return "tumbling_time"
+	}
+	return "sliding_time"
+}
+
+func (ts TimeWindowStage) Apply(ee *ExecutionEnvironment) ExecutionResult {
+	list, ok := ee.Get("items").(ListResult)
+	if !ok {
+		runtimeErrorf(lexer.Position{}, "%s: expecting a list, got %s", ts.name(), FormatValue(ee.Get("items")))
+	}
+	items, _ := drainStream(ts.Stream(ee, listStream(list), nil))
+	return ListResult{Items: items}
+}
+
+func (ts TimeWindowStage) ParameterNames() []string {
+	return []string{"items"}
+}
+
+func (ts TimeWindowStage) Execute(ee *ExecutionEnvironment) ExecutionResult {
+	return ts
+}
+
+func (ts TimeWindowStage) Type(typeMap TypeMap) Type {
+	return TypeFunction
+}
+
+func (ts TimeWindowStage) ListRep() []any {
+	return []any{ts.name(), ts.Field, ts.Span, ts.Step, ts.Aggregate.ListRep()}
+}
+
+// timestamp returns the time of a record.
+func (ts TimeWindowStage) timestamp(item ExecutionResult) float64 {
+	var r *rozer.Roze
+	switch item := item.(type) {
+	case RecordValue:
+		r = item.Roze
+	case RowValue:
+		r = item.Batch.Row(item.Row)
+	default:
+		runtimeErrorf(lexer.Position{}, "%s: expecting records, got %s", ts.name(), FormatValue(item))
+	}
+	value, _ := r.Lookup(ts.Field)
+	switch v := ValueFromRoze(value).(type) {
+	case IntegerValue:
+		return float64(v)
+	case FloatValue:
+		return float64(v)
+	case StringValue:
+		t, err := time.Parse(time.RFC3339Nano, string(v))
+		if err == nil {
+			return float64(t.UnixNano()) / 1e9
+		}
+	}
+	runtimeErrorf(lexer.Position{}, "%s: field %q is not a timestamp: %s", ts.name(), ts.Field, FormatValue(ValueFromRoze(value)))
+	return 0
+}
+
+// firstStart returns the start of the first window that holds time t, which
+// is after t if no window does.
+func (ts TimeWindowStage) firstStart(t float64) float64 {
+	return (math.Floor((t-ts.Span)/ts.Step) + 1) * ts.Step
+}
+
+// timeValue returns a time as an integer if it is whole.
+func timeValue(t float64) ExecutionResult {
+	if t == math.Trunc(t) && math.Abs(t) < 1<<53 {
+		return IntegerValue(t)
+	}
+	return FloatValue(t)
+}
+
+type timedItem struct {
+	time float64
+	item ExecutionResult
+}
+
+func (ts TimeWindowStage) Stream(ee *ExecutionEnvironment, upstream Stream, done <-chan struct{}) Stream {
+	// items holds the records from the window starting at start on.
+	var items []timedItem
+	var start, last float64
+	started := false
+	var ready []ExecutionResult
+	var end ExecutionResult
+	ended := false
+
+	// flush yields the window starting at start, if it has records, and
+	// moves on to the next.
+	flush := func() {
+		var in []any
+		for _, it := range items {
+			if it.time >= start+ts.Span {
+				break
+			}
+			in = append(in, it.item)
+		}
+		if len(in) > 0 {
+			w := window(timeValue(start), timeValue(start+ts.Span), in)
+			ready = append(ready, call(ee, ts.Aggregate, w))
+		}
+		start += ts.Step
+		for len(items) > 0 && items[0].time < start {
+			items = items[1:]
+			ee.release(1)
+		}
+	}
+
+	return func() (ExecutionResult, bool) {
+		for len(ready) == 0 {
+			if ended {
+				if end != TagComplete {
+					return end, false
+				}
+				for len(items) > 0 {
+					flush()
+				}
+				if len(ready) == 0 {
+					return end, false
+				}
+				break
+			}
+
+			item, ok := upstream()
+			if !ok {
+				end, ended = item, true
+				continue
+			}
+			t := ts.timestamp(item)
+			switch {
+			case !started:
+				start, started = ts.firstStart(t), true
+			case t < last:
+				runtimeErrorf(lexer.Position{}, "%s: records are not in order of %s: %s is before %s", ts.name(), ts.Field, FormatValue(timeValue(t)), FormatValue(timeValue(last)))
+			}
+			last = t
+
+			for t >= start+ts.Span {
+				flush()
+				if len(items) == 0 {
+					// skip the windows with no records.
+					start = max(start, ts.firstStart(t))
+				}
+			}
+			if t >= start {
+				ee.collect(1)
+				items = append(items, timedItem{t, item})
+			}
+		}
+		result := ready[0]
+		ready = ready[1:]
+		return result, true
+	}
+}
@@ -0,0 +1,144 @@
+package lang
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/pdk/rozer"
+)
+
+func formatAll(items []ExecutionResult) string {
+	formatted := make([]string, len(items))
+	for i, item := range items {
+		formatted[i] = FormatValue(item)
+	}
+	return strings.Join(formatted, "\n")
+}
+
+func TestLeadFlushesOnComplete(t *testing.T) {
+	ls := RunningStage{Kind: "lead", Field: "next", N: 2, Value: keyFn(t, "fn(r) {\n    r.id\n}\n")}
+
+	items, end := drain(ls.Stream(NewExecutionEnvironment(), recordStream(4), nil))
+	if end != TagComplete {
+		t.Errorf("stream ended with %v, want %v", end, TagComplete)
+	}
+	want := `record(["id": 1, "price": 0.5, "next": 3])
+record(["id": 2, "price": 1.0, "next": 4])
+record(["id": 3, "price": 1.5, "next": #null])
+record(["id": 4, "price": 2.0, "next": #null])`
+	if got := formatAll(items); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+
+	// the items waiting for those after them are dropped if the stream
+	// breaks.
+	records := recordStream(4)
+	upstream := func() (ExecutionResult, bool) {
+		item, ok := records()
+		if !ok {
+			return TagBreak, false
+		}
+		return item, ok
+	}
+	items, end = drain(ls.Stream(NewExecutionEnvironment(), upstream, nil))
+	if end != TagBreak {
+		t.Errorf("stream ended with %v, want %v", end, TagBreak)
+	}
+	if len(items) != 2 {
+		t.Errorf("got %d items, want 2", len(items))
+	}
+}
+
+func TestCountWindows(t *testing.T) {
+	bounds := keyFn(t, "fn(w) {\n    [w.start, w.end, w.items]\n}\n")
+
+	// a window is yielded as soon as its last item is read.
+	read := 0
+	numbers := countingStream(7)
+	upstream := func() (ExecutionResult, bool) {
+		read++
+		return numbers()
+	}
+	stream := CountWindowStage{Size: 3, Step: 3, Aggregate: bounds}.Stream(NewExecutionEnvironment(), upstream, nil)
+	first, _ := stream()
+	if read != 3 {
+		t.Errorf("read %d items for the first window", read)
+	}
+	if got := FormatValue(first); got != "[0, 3, [1, 2, 3]]" {
+		t.Errorf("first window is %s", got)
+	}
+	items, _ := drain(stream)
+	if got := formatAll(items); got != "[3, 6, [4, 5, 6]]\n[6, 7, [7]]" {
+		t.Errorf("got\n%s", got)
+	}
+
+	for _, test := range []struct {
+		size, step, n int
+		want          string
+	}{
+		{3, 1, 5, "[0, 3, [1, 2, 3]]\n[1, 4, [2, 3, 4]]\n[2, 5, [3, 4, 5]]"},
+		{3, 1, 2, "[0, 2, [1, 2]]"},
+		{2, 3, 8, "[0, 2, [1, 2]]\n[3, 5, [4, 5]]\n[6, 8, [7, 8]]"},
+		{2, 3, 4, "[0, 2, [1, 2]]\n[3, 4, [4]]"},
+		{2, 3, 0, ""},
+	} {
+		cs := CountWindowStage{Size: test.size, Step: test.step, Aggregate: bounds}
+		items, _ := drain(cs.Stream(NewExecutionEnvironment(), countingStream(test.n), nil))
+		if got := formatAll(items); got != test.want {
+			t.Errorf("%s of %d items is\n%s\nwant\n%s", FormatValue(cs), test.n, got, test.want)
+		}
+	}
+}
+
+func timedStream(times ...any) Stream {
+	i := 0
+	return func() (ExecutionResult, bool) {
+		if i == len(times) {
+			return TagComplete, false
+		}
+		i++
+		return RecordValue{rozer.NewObject().Put("id", int64(i)).Put("at", times[i-1])}, true
+	}
+}
+
+func TestTimeWindows(t *testing.T) {
+	bounds := keyFn(t, "fn(w) {\n    [w.start, w.end, w.items[*].id]\n}\n")
+
+	for _, test := range []struct {
+		span, step float64
+		times      []any
+		want       string
+	}{
+		{10, 10, []any{int64(3), int64(9), int64(10), int64(45)}, "[0, 10, [1, 2]]\n[10, 20, [3]]\n[40, 50, [4]]"},
+		{10, 5, []any{int64(3), int64(7), int64(12), int64(40)}, "[-5, 5, [1]]\n[0, 10, [1, 2]]\n[5, 15, [2, 3]]\n[10, 20, [3]]\n[35, 45, [4]]\n[40, 50, [4]]"},
+		{1, 2, []any{0.5, 1.5, 2.25}, "[0, 1, [1]]\n[2, 3, [3]]"},
+		{0.5, 0.5, []any{0.25, 0.75}, "[0, 0.5, [1]]\n[0.5, 1, [2]]"},
+		{60, 60, []any{"2024-01-01T00:00:30Z", "2024-01-01T00:01:00Z", "2024-01-01T01:01:59.5+01:00"}, "[1704067200, 1704067260, [1]]\n[1704067260, 1704067320, [2, 3]]"},
+	} {
+		ts := TimeWindowStage{Field: "at", Span: test.span, Step: test.step, Aggregate: bounds}
+		var items []ExecutionResult
+		if err := runStage(func() {
+			items, _ = drain(ts.Stream(NewExecutionEnvironment(), timedStream(test.times...), nil))
+		}); err != nil {
+			t.Errorf("%s: %v", FormatValue(ts), err)
+			continue
+		}
+		if got := formatAll(items); got != test.want {
+			t.Errorf("%s is\n%s\nwant\n%s", FormatValue(ts), got, test.want)
+		}
+	}
+
+	ts := TimeWindowStage{Field: "at", Span: 10, Step: 10, Aggregate: bounds}
+	err := runStage(func() {
+		drain(ts.Stream(NewExecutionEnvironment(), timedStream(int64(5), int64(3)), nil))
+	})
+	if err == nil || !strings.Contains(err.Error(), "not in order") {
+		t.Errorf("got error %v", err)
+	}
+	err = runStage(func() {
+		drain(ts.Stream(NewExecutionEnvironment(), timedStream("yesterday"), nil))
+	})
+	if err == nil || !strings.Contains(err.Error(), "not a timestamp") {
+		t.Errorf("got error %v", err)
+	}
+}
@@ -0,0 +1,124 @@
+package rozer
+
+import (
+	"maps"
+	"sync"
+)
+
+// Layouts interns the names of decoded objects. Objects decoded with the same
+// Layouts and the same names in the same order share one index of their
+// names, and each holds only its values. Reading many rows of the same shape
+// then takes far less memory than giving each row a map of its own.
+//
+// The shared index is never changed. A Roze copies it before a name is added
+// to it, deleted from it or renamed, so sharing is never seen, other than in
+// the memory used.
+//
+// A Layouts may be used by several decoders at once. It keeps a bounded
+// number of names; once it is full, objects of new shapes are decoded with
+// names of their own.
+type Layouts struct {
+	mu    sync.Mutex
+	root  layout
+	names int
+}
+
+// layout is the index of the names of an object, and the layouts of the
+// objects with one more name. names is never changed once the layout is
+// made; next is guarded by the Layouts.
+type layout struct {
+	names map[string]int
+	next  map[string]*layout
+}
+
+// maxLayoutNames bounds the names kept by a Layouts, summed over every
+// layout, for input whose names are data rather than a shape.
+const maxLayoutNames = 1 << 20
+
+// NewLayouts returns an empty Layouts.
+func NewLayouts() *Layouts {
+	return &Layouts{root: layout{names: map[string]int{}}}
+}
+
+// next returns the layout of l with the name added, or nil if the Layouts is
+// full.
+func (ls *Layouts) next(l *layout, name string) *layout {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+
+	if n, ok := l.next[name]; ok {
+		return n
+	}
+	if ls.names+len(l.names)+1 > maxLayoutNames {
+		return nil
+	}
+	names := maps.Clone(l.names)
+	names[name] = len(names)
+	n := &layout{names: names}
+	if l.next == nil {
+		l.next = make(map[string]*layout)
+	}
+	l.next[name] = n
+	ls.names += len(names)
+	return n
+}
+
+// builder adds the values of an object to a Roze as they are decoded,
+// sharing its names with the objects decoded before it with the same names
+// for as long as it can.
+type builder struct {
+	r       *Roze
+	layouts *Layouts
+	// at is the layout of r, or nil once r has names of its own.
+	at *layout
+}
+
+// newBuilder returns a builder for the object r, which must be empty. The
+// names of r are shared if layouts is not nil.
+func newBuilder(r *Roze, layouts *Layouts) builder {
+	if layouts == nil || len(r.data) > 0 {
+		if r.names == nil {
+			r.names = make(map[string]int)
+		}
+		return builder{r: r}
+	}
+	r.names, r.shared = layouts.root.names, true
+	return builder{r, layouts, &layouts.root}
+}
+
+// put names a value, as Put does.
+func (b *builder) put(name string, value any) {
+	if b.at != nil {
+		if p, ok := b.at.names[name]; ok {
+			b.r.data[p] = value
+			return
+		}
+		if next := b.layouts.next(b.at, name); next != nil {
+			b.at = next
+			b.r.names = next.names
+			b.r.data = append(b.r.data, value)
+			return
+		}
+		b.at = nil
+	}
+	b.r.Put(name, value)
+}
+
+// add names a value, as Add does.
+func (b *builder) add(name string, value any) {
+	if b.at != nil {
+		if _, ok := b.at.names[name]; !ok {
+			b.put(name, value)
+			return
+		}
+		b.at = nil
+	}
+	b.r.Add(name, value)
+}
+
+// append adds a value without a name. The names that follow it are not at
+// the positions of any layout, so are the Roze's own.
+func (b *builder) append(value any) {
+	b.at = nil
+	b.r.Append(value)
+}
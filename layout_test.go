@@ -0,0 +1,196 @@
+package rozer
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// sameNames reports whether two Roze values share their names.
+func sameNames(a, b *Roze) bool {
+	return reflect.ValueOf(a.names).UnsafePointer() == reflect.ValueOf(b.names).UnsafePointer()
+}
+
+func readRows(t *testing.T, d Decoding, in string) []*Roze {
+	t.Helper()
+	ar := d.NewArrayReader(strings.NewReader(in))
+	var rows []*Roze
+	for {
+		r, err := ar.Next()
+		if err == io.EOF {
+			return rows
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		rows = append(rows, r)
+	}
+}
+
+func TestLayouts(t *testing.T) {
+	d := Decoding{Layouts: NewLayouts()}
+	rows := readRows(t, d, `[{"a": 1, "b": {"c": 2}}, {"a": 3, "b": {"c": 4}}, {"b": 5, "a": 6}, {"a": 7, "a": 8, "b": 9}, {}]`)
+
+	if !sameNames(rows[0], rows[1]) || !sameNames(rows[0].Get("b").(*Roze), rows[1].Get("b").(*Roze)) {
+		t.Errorf("rows of the same shape do not share names")
+	}
+	if sameNames(rows[0], rows[2]) {
+		t.Errorf("rows with names in another order share names")
+	}
+	if !sameNames(rows[0], rows[3]) {
+		t.Errorf("a repeated name is not shared")
+	}
+
+	// changing the names of one row leaves the others alone.
+	rows[1].Put("d", 10)
+	rows[0].Rename("a", "z")
+	rows[3].Delete("b")
+	rows[4].Put("e", 11)
+	for i, want := range []string{
+		`{"z":1,"b":{"c":2}}`,
+		`{"a":3,"b":{"c":4},"d":10}`,
+		`{"b":5,"a":6}`,
+		`{"a":8}`,
+		`{"e":11}`,
+	} {
+		if got := marshal(t, rows[i]); got != want {
+			t.Errorf("row %d is %s, want %s", i, got, want)
+		}
+	}
+	if !rows[1].Get("b").(*Roze).Has("c") {
+		t.Errorf("nested names changed")
+	}
+
+	// changing a value does not copy the names.
+	more := readRows(t, d, `[{"a": 1, "b": 2}, {"a": 3, "b": 4}]`)
+	more[0].Put("a", 5)
+	if !sameNames(more[0], more[1]) || !sameNames(more[0], more[0].Clone()) {
+		t.Errorf("names copied without changing")
+	}
+
+	dups := readRows(t, Decoding{KeepDuplicates: true, Layouts: NewLayouts()}, `[{"a": 1, "a": 2}, {"a": 3, "b": 4}]`)
+	if got := marshal(t, dups[0]); got != `{"a":1,"a":2}` {
+		t.Errorf("got %s", got)
+	}
+	if got := marshal(t, dups[1]); got != `{"a":3,"b":4}` {
+		t.Errorf("got %s", got)
+	}
+}
+
+func TestLayoutsFull(t *testing.T) {
+	ls := NewLayouts()
+	ls.names = maxLayoutNames
+	rows := readRows(t, Decoding{Layouts: ls}, `[{"a": 1}, {"a": 2}, {}]`)
+	if sameNames(rows[0], rows[1]) {
+		t.Errorf("a full Layouts shared names")
+	}
+	if got := marshal(t, rows[1]); got != `{"a":2}` {
+		t.Errorf("got %s", got)
+	}
+}
+
+func TestBinaryLayouts(t *testing.T) {
+	for _, f := range formats {
+		var buf bytes.Buffer
+		enc := f.NewEncoder(&buf)
+		for _, r := range []*Roze{
+			NewObject().Put("a", int64(1)).Put("b", int64(2)),
+			NewObject().Put("a", int64(3)).Put("b", int64(4)),
+			New().Put("a", int64(5)).Append(int64(6)).Put("b", int64(7)),
+		} {
+			if err := enc.Encode(r); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		dec := f.NewDecoder(&buf)
+		dec.UseLayouts(NewLayouts())
+		var rows []*Roze
+		for {
+			r, err := dec.Decode()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			rows = append(rows, r)
+		}
+		if !sameNames(rows[0], rows[1]) {
+			t.Errorf("%s: rows of the same shape do not share names", f)
+		}
+		if got := marshal(t, rows[2]); got != `{"a":5,"1":6,"b":7}` {
+			t.Errorf("%s: got %s", f, got)
+		}
+	}
+}
+
+// BenchmarkLayoutsMemory reads a file of a million rows, keeping them all,
+// and reports the memory they take with names of their own and shared.
+func BenchmarkLayoutsMemory(b *testing.B) {
+	name := filepath.Join(b.TempDir(), "rows.json")
+	f, err := os.Create(name)
+	if err != nil {
+		b.Fatal(err)
+	}
+	w := bufio.NewWriter(f)
+	w.WriteString("[\n")
+	const n = 1_000_000
+	for i := range n {
+		if i > 0 {
+			w.WriteString(",\n")
+		}
+		fmt.Fprintf(w, `{"id": %d, "name": "name %d", "price": %d.25, "qty": %d, "active": true, "address": {"city": "c", "zip": "%05d"}}`,
+			i, i, i, i%10, i%100000)
+	}
+	w.WriteString("\n]\n")
+	if err := w.Flush(); err != nil {
+		b.Fatal(err)
+	}
+	f.Close()
+
+	for _, shared := range []bool{false, true} {
+		b.Run(fmt.Sprintf("shared=%v", shared), func(b *testing.B) {
+			for range b.N {
+				in, err := os.Open(name)
+				if err != nil {
+					b.Fatal(err)
+				}
+				d := Decoding{Numbers: NumbersInt64}
+				if shared {
+					d.Layouts = NewLayouts()
+				}
+
+				runtime.GC()
+				var before, after runtime.MemStats
+				runtime.ReadMemStats(&before)
+
+				ar := d.NewArrayReader(bufio.NewReader(in))
+				rows := make([]*Roze, 0, n)
+				for {
+					r, err := ar.Next()
+					if err == io.EOF {
+						break
+					}
+					if err != nil {
+						b.Fatal(err)
+					}
+					rows = append(rows, r)
+				}
+				in.Close()
+
+				runtime.GC()
+				runtime.ReadMemStats(&after)
+				b.ReportMetric(float64(after.HeapAlloc-before.HeapAlloc)/n, "heap-bytes/row")
+				runtime.KeepAlive(rows)
+			}
+		})
+	}
+}
@@ -0,0 +1,336 @@
+package lsp
+
+import (
+	"net/url"
+	"path/filepath"
+	"sort"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+
+	"github.com/alecthomas/participle/v2/lexer"
+	"github.com/pdk/rozer/lang"
+)
+
+// document is an open .roz file and what is known about it from its last
+// parse and compile.
+type document struct {
+	uri  string
+	text string
+
+	lineStarts []int
+	tokens     []lexer.Token
+	names      map[lexer.TokenType]string
+
+	program     *lang.Program
+	typeMap     lang.TypeMap
+	diagnostics []Diagnostic
+}
+
+func newDocument(uri, text string, previous *document) *document {
+	d := &document{
+		uri:        uri,
+		text:       text,
+		lineStarts: []int{0},
+		names:      map[lexer.TokenType]string{},
+		typeMap:    lang.TypeMap{},
+	}
+	for i, c := range text {
+		if c == '\n' {
+			d.lineStarts = append(d.lineStarts, i+1)
+		}
+	}
+	for name, tokenType := range lang.PipelineLexer.Symbols() {
+		d.names[tokenType] = name
+	}
+	d.tokens = lexTokens(text)
+
+	program, diagnostics := lang.ParseAll(filename(uri), []byte(text))
+	if len(diagnostics) > 0 {
+		for _, diagnostic := range diagnostics {
+			d.diagnostics = append(d.diagnostics, d.diagnostic(diagnostic))
+		}
+		// keep answering hover and definition requests from the last good
+		// version while the user is typing. Its positions are those of its
+		// own text, so that is kept too.
+		if previous != nil {
+			d.text = previous.text
+			d.lineStarts = previous.lineStarts
+			d.tokens = previous.tokens
+			d.program = previous.program
+			d.typeMap = previous.typeMap
+		}
+		return d
+	}
+	d.program = program
+
+	// a new loader each time, so that edits to imported files are seen.
+	loader := lang.NewLoader(lang.DefaultLoader.SearchPath...)
+	_, errs := program.CompileWith(d.typeMap, loader)
+	for _, diagnostic := range lang.CompileDiagnostics(errs) {
+		d.diagnostics = append(d.diagnostics, d.diagnostic(diagnostic))
+	}
+
+	return d
+}
+
+// filename returns the path of a file: URI, which imports are resolved
+// relative to, or the URI itself for other schemes.
+func filename(uri string) string {
+	u, err := url.Parse(uri)
+	if err != nil || u.Scheme != "file" {
+		return uri
+	}
+	return filepath.FromSlash(u.Path)
+}
+
+// lexTokens returns the tokens of text, stopping at the first lexing error.
+func lexTokens(text string) []lexer.Token {
+	lex, err := lang.PipelineLexer.Lex("", strings.NewReader(text))
+	if err != nil {
+		return nil
+	}
+	tokens := []lexer.Token{}
+	for {
+		token, err := lex.Next()
+		if err != nil || token.EOF() {
+			return tokens
+		}
+		tokens = append(tokens, token)
+	}
+}
+
+func (d *document) diagnostic(ld lang.Diagnostic) Diagnostic {
+	diagnostic := Diagnostic{
+		Severity: SeverityError,
+		Code:     ld.Code,
+		Source:   "rozer",
+		Message:  ld.Message,
+	}
+	if ld.Hint != "" {
+		diagnostic.Message += "\nhint: " + ld.Hint
+	}
+	if ld.EndPos.Offset > ld.Pos.Offset {
+		diagnostic.Range = Range{d.position(ld.Pos.Offset), d.position(ld.EndPos.Offset)}
+	} else {
+		diagnostic.Range = d.tokenRange(ld.Pos.Offset)
+	}
+	return diagnostic
+}
+
+// position converts a byte offset into an LSP position, which counts UTF-16
+// code units from the start of the line.
+func (d *document) position(offset int) Position {
+	if offset > len(d.text) {
+		offset = len(d.text)
+	}
+	line := sort.Search(len(d.lineStarts), func(i int) bool {
+		return d.lineStarts[i] > offset
+	}) - 1
+	character := 0
+	for _, r := range d.text[d.lineStarts[line]:offset] {
+		character += len(utf16.Encode([]rune{r}))
+	}
+	return Position{line, character}
+}
+
+// offset converts an LSP position into a byte offset.
+func (d *document) offset(pos Position) int {
+	if pos.Line >= len(d.lineStarts) {
+		return len(d.text)
+	}
+	offset := d.lineStarts[pos.Line]
+	for character := 0; character < pos.Character && offset < len(d.text); {
+		r, size := utf8.DecodeRuneInString(d.text[offset:])
+		if r == '\n' {
+			break
+		}
+		character += len(utf16.Encode([]rune{r}))
+		offset += size
+	}
+	return offset
+}
+
+// tokenAt returns the token containing offset, or else the token ending
+// there, as when the cursor is just after an identifier.
+func (d *document) tokenAt(offset int) (lexer.Token, bool) {
+	var before lexer.Token
+	found := false
+	for _, token := range d.tokens {
+		start, end := token.Pos.Offset, token.Pos.Offset+len(token.Value)
+		if start <= offset && offset < end {
+			return token, true
+		}
+		if offset == end {
+			before, found = token, true
+		}
+	}
+	return before, found
+}
+
+// tokenRange returns the range of the token at offset, or of the single
+// character there if there is no token.
+func (d *document) tokenRange(offset int) Range {
+	token, ok := d.tokenAt(offset)
+	if !ok || token.Pos.Offset != offset {
+		return Range{d.position(offset), d.position(offset + 1)}
+	}
+	return Range{d.position(offset), d.position(offset + len(token.Value))}
+}
+
+// identAt returns the identifier token under pos.
+func (d *document) identAt(pos Position) (lexer.Token, bool) {
+	token, ok := d.tokenAt(d.offset(pos))
+	if !ok || d.names[token.Type] != "Ident" {
+		return lexer.Token{}, false
+	}
+	return token, true
+}
+
+// functionName returns the token naming the named function declared at fnPos.
+func (d *document) functionName(fnPos lexer.Position) (lexer.Token, bool) {
+	for i, token := range d.tokens {
+		if token.Pos.Offset == fnPos.Offset && i+1 < len(d.tokens) {
+			return d.tokens[i+1], true
+		}
+	}
+	return lexer.Token{}, false
+}
+
+func (d *document) namedFunctions() []*lang.NamedFunction {
+	functions := []*lang.NamedFunction{}
+	if d.program == nil {
+		return functions
+	}
+	for _, c := range d.program.Commands {
+		if c.NamedFunction != nil && c.NamedFunction.Name != nil {
+			functions = append(functions, c.NamedFunction)
+		}
+	}
+	return functions
+}
+
+// assignedIdent returns the identifier on the left of a := assignment.
+func assignedIdent(a *lang.Assignment) *lang.Base {
+	if a.Operation == nil || a.Operation.Op != ":=" {
+		return nil
+	}
+	p := a.Pipe
+	if len(p.Operations) > 0 || len(p.Logical.Operations) > 0 {
+		return nil
+	}
+	c := p.Logical.Comparison
+	if len(c.Operations) > 0 || c.Series.ToValue != nil || c.Series.FromValue.RightValue != nil {
+		return nil
+	}
+	add := c.Series.FromValue.Addition
+	if len(add.Operations) > 0 || len(add.Multiplication.Operations) > 0 {
+		return nil
+	}
+	base := add.Multiplication.Unary.Base
+	if base == nil || base.Ident == nil || len(base.Members) > 0 {
+		return nil
+	}
+	return base
+}
+
+func signature(nf *lang.NamedFunction) string {
+	return "fn " + *nf.Name + "(" + strings.Join(nf.Params, ", ") + ")"
+}
+
+func (d *document) hover(pos Position) *Hover {
+	token, ok := d.identAt(pos)
+	if !ok {
+		return nil
+	}
+	name := token.Value
+	r := Range{d.position(token.Pos.Offset), d.position(token.Pos.Offset + len(name))}
+
+	text := ""
+	for _, nf := range d.namedFunctions() {
+		if *nf.Name == name {
+			text = signature(nf)
+		}
+	}
+	if text == "" {
+		for _, bf := range lang.Builtins() {
+			if bf.Name == name {
+				text = lang.FormatValue(bf) + " (builtin)"
+			}
+		}
+	}
+	if text == "" {
+		t, ok := d.typeMap[name]
+		if !ok {
+			return nil
+		}
+		text = name + ": " + t.String()
+	}
+
+	return &Hover{
+		Contents: MarkupContent{Kind: "plaintext", Value: text},
+		Range:    &r,
+	}
+}
+
+func (d *document) definition(pos Position) *Location {
+	token, ok := d.identAt(pos)
+	if !ok {
+		return nil
+	}
+	name := token.Value
+
+	for _, nf := range d.namedFunctions() {
+		if *nf.Name != name {
+			continue
+		}
+		nameToken, ok := d.functionName(nf.Pos)
+		if !ok {
+			continue
+		}
+		return d.location(nameToken.Pos.Offset, len(name))
+	}
+
+	var found *lang.Base
+	if d.program != nil {
+		lang.Walk(d.program, func(node any) bool {
+			if a, ok := node.(*lang.Assignment); ok && found == nil {
+				base := assignedIdent(a)
+				if base != nil && *base.Ident == name {
+					found = base
+				}
+			}
+			return found == nil
+		})
+	}
+	if found == nil {
+		return nil
+	}
+	return d.location(found.Pos.Offset, len(name))
+}
+
+func (d *document) location(offset, length int) *Location {
+	return &Location{
+		URI:   d.uri,
+		Range: Range{d.position(offset), d.position(offset + length)},
+	}
+}
+
+func (d *document) completion() []CompletionItem {
+	items := []CompletionItem{}
+	for _, bf := range lang.Builtins() {
+		items = append(items, CompletionItem{
+			Label:  bf.Name,
+			Kind:   CompletionKindFunction,
+			Detail: lang.FormatValue(bf),
+		})
+	}
+	for _, nf := range d.namedFunctions() {
+		items = append(items, CompletionItem{
+			Label:  *nf.Name,
+			Kind:   CompletionKindFunction,
+			Detail: signature(nf),
+		})
+	}
+	return items
+}
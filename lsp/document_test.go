@@ -0,0 +1,174 @@
+package lsp
+
+import (
+	"strings"
+	"testing"
+)
+
+const uri = "file:///tmp/doc.roz"
+
+const text = "fn greet(name) {\n" +
+	"    \"héllo 😀 \" + name\n" +
+	"}\n" +
+	"s := \"😀\"\n" +
+	"g := greet(s)\n" +
+	"p := parallel(2, greet)\n"
+
+func TestPositionAndOffset(t *testing.T) {
+	d := newDocument(uri, text, nil)
+
+	// é is two bytes and one UTF-16 unit, and 😀 four bytes and two units.
+	emoji := strings.Index(text, "😀")
+	name := strings.Index(text, "+ name") + 2
+	for _, tt := range []struct {
+		offset int
+		pos    Position
+	}{
+		{0, Position{0, 0}},
+		{strings.Index(text, "é"), Position{1, 6}},
+		{strings.Index(text, "é") + 2, Position{1, 7}},
+		{emoji, Position{1, 11}},
+		{emoji + 4, Position{1, 13}},
+		{name, Position{1, 18}},
+		{strings.Index(text, "s :="), Position{3, 0}},
+		{len(text), Position{6, 0}},
+	} {
+		if got := d.position(tt.offset); got != tt.pos {
+			t.Errorf("position(%d) = %v, want %v", tt.offset, got, tt.pos)
+		}
+		if got := d.offset(tt.pos); got != tt.offset {
+			t.Errorf("offset(%v) = %d, want %d", tt.pos, got, tt.offset)
+		}
+	}
+
+	// positions inside a character, past the end of a line and past the end
+	// of the text.
+	for _, tt := range []struct {
+		pos    Position
+		offset int
+	}{
+		{Position{1, 12}, emoji + 4},
+		{Position{1, 100}, strings.Index(text, "name\n") + 4},
+		{Position{9, 0}, len(text)},
+	} {
+		if got := d.offset(tt.pos); got != tt.offset {
+			t.Errorf("offset(%v) = %d, want %d", tt.pos, got, tt.offset)
+		}
+	}
+	if got := d.position(len(text) + 10); got != (Position{6, 0}) {
+		t.Errorf("position past the end = %v", got)
+	}
+}
+
+func TestDiagnosticRanges(t *testing.T) {
+	for _, tt := range []struct {
+		text string
+		code string
+		r    Range
+	}{
+		// a compile error spans its statement.
+		{"s := \"😀\"\ns := 1\n", "E105", Range{Position{1, 0}, Position{1, 6}}},
+		// a syntax error covers the token it is at, counted in UTF-16 units.
+		{"x := \"😀\" + )\n", "E001", Range{Position{0, 12}, Position{0, 13}}},
+	} {
+		d := newDocument(uri, tt.text, nil)
+		if len(d.diagnostics) != 1 {
+			t.Errorf("%q: got diagnostics %+v", tt.text, d.diagnostics)
+			continue
+		}
+		got := d.diagnostics[0]
+		if got.Code != tt.code || got.Range != tt.r || got.Severity != SeverityError || got.Source != "rozer" {
+			t.Errorf("%q: got %+v, want %s at %v", tt.text, got, tt.code, tt.r)
+		}
+	}
+	if d := newDocument(uri, text, nil); len(d.diagnostics) != 0 {
+		t.Errorf("got diagnostics %+v", d.diagnostics)
+	}
+}
+
+func TestHover(t *testing.T) {
+	d := newDocument(uri, text, nil)
+	for _, tt := range []struct {
+		pos  Position
+		want string
+		r    Range
+	}{
+		{Position{4, 7}, "fn greet(name)", Range{Position{4, 5}, Position{4, 10}}},
+		// just after an identifier, and at the start of a line.
+		{Position{3, 1}, "s: string", Range{Position{3, 0}, Position{3, 1}}},
+		{Position{3, 0}, "s: string", Range{Position{3, 0}, Position{3, 1}}},
+		{Position{5, 5}, "fn parallel(", Range{Position{5, 5}, Position{5, 13}}},
+	} {
+		h := d.hover(tt.pos)
+		if h == nil {
+			t.Errorf("%v: no hover", tt.pos)
+			continue
+		}
+		if !strings.HasPrefix(h.Contents.Value, tt.want) || h.Range == nil || *h.Range != tt.r {
+			t.Errorf("%v: got %q at %v, want %q at %v", tt.pos, h.Contents.Value, h.Range, tt.want, tt.r)
+		}
+	}
+	if h := d.hover(Position{5, 5}); h != nil && !strings.HasSuffix(h.Contents.Value, "(builtin)") {
+		t.Errorf("builtin hover is %q", h.Contents.Value)
+	}
+	if h := d.hover(Position{2, 0}); h != nil {
+		t.Errorf("hover on a brace is %+v", h)
+	}
+}
+
+func TestDefinition(t *testing.T) {
+	d := newDocument(uri, text, nil)
+	for _, tt := range []struct {
+		pos  Position
+		want Range
+	}{
+		{Position{4, 6}, Range{Position{0, 3}, Position{0, 8}}},
+		{Position{4, 11}, Range{Position{3, 0}, Position{3, 1}}},
+	} {
+		loc := d.definition(tt.pos)
+		if loc == nil || loc.URI != uri || loc.Range != tt.want {
+			t.Errorf("%v: got %+v, want %v", tt.pos, loc, tt.want)
+		}
+	}
+	// builtins and parameters have no definition in the file.
+	for _, pos := range []Position{{5, 5}, {1, 20}} {
+		if loc := d.definition(pos); loc != nil {
+			t.Errorf("%v: got %+v", pos, loc)
+		}
+	}
+}
+
+func TestDocumentThatFailsToParse(t *testing.T) {
+	good := newDocument(uri, text, nil)
+	bad := newDocument(uri, "s := \"😀\" +\n", good)
+	if len(bad.diagnostics) == 0 {
+		t.Fatalf("no diagnostics")
+	}
+	if r := bad.diagnostics[0].Range; r.Start.Line != 1 {
+		t.Errorf("got range %v, want the end of the text", r)
+	}
+
+	// hover and definition are answered from the last version that parsed,
+	// and its text, even when lines have been added since.
+	bad = newDocument(uri, "x := (\n"+text, good)
+	if h := bad.hover(Position{3, 0}); h == nil || h.Contents.Value != "s: string" {
+		t.Errorf("got hover %+v", h)
+	}
+	greet := Range{Position{0, 3}, Position{0, 8}}
+	if loc := bad.definition(Position{4, 6}); loc == nil || loc.Range != greet {
+		t.Errorf("got definition %+v, want %v", loc, greet)
+	}
+	// and so are those of a version after that which does not parse either.
+	worse := newDocument(uri, "x := ((\n"+text, bad)
+	if loc := worse.definition(Position{4, 6}); loc == nil || loc.Range != greet {
+		t.Errorf("got definition %+v, want %v", loc, greet)
+	}
+
+	first := newDocument(uri, "s := \"😀\" +\n", nil)
+	if h := first.hover(Position{0, 0}); h != nil {
+		t.Errorf("got hover %+v", h)
+	}
+	if loc := first.definition(Position{0, 0}); loc != nil {
+		t.Errorf("got definition %+v", loc)
+	}
+}
@@ -0,0 +1,193 @@
+package lsp
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/textproto"
+	"strconv"
+)
+
+// Server is a language server for .roz files, speaking JSON-RPC over a pair
+// of streams, typically stdin and stdout.
+type Server struct {
+	in   *bufio.Reader
+	out  io.Writer
+	docs map[string]*document
+	exit bool
+}
+
+func NewServer(in io.Reader, out io.Writer) *Server {
+	return &Server{
+		in:   bufio.NewReader(in),
+		out:  out,
+		docs: map[string]*document{},
+	}
+}
+
+// Serve handles messages until the client sends exit or closes the input.
+func (s *Server) Serve() error {
+	for !s.exit {
+		body, err := s.read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		var req request
+		err = json.Unmarshal(body, &req)
+		if err != nil {
+			s.reply(nil, nil, &responseError{codeParseError, err.Error()})
+			continue
+		}
+
+		err = s.handle(req)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Server) read() ([]byte, error) {
+	header, err := textproto.NewReader(s.in).ReadMIMEHeader()
+	if err != nil {
+		return nil, err
+	}
+	length, err := strconv.Atoi(header.Get("Content-Length"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid Content-Length: %w", err)
+	}
+	body := make([]byte, length)
+	_, err = io.ReadFull(s.in, body)
+	return body, err
+}
+
+func (s *Server) write(message any) error {
+	body, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(s.out, "Content-Length: %d\r\n\r\n%s", len(body), body)
+	return err
+}
+
+func (s *Server) reply(id *json.RawMessage, result any, rerr *responseError) error {
+	return s.write(response{
+		JSONRPC: "2.0",
+		ID:      id,
+		Result:  result,
+		Error:   rerr,
+	})
+}
+
+func (s *Server) notify(method string, params any) error {
+	return s.write(notification{
+		JSONRPC: "2.0",
+		Method:  method,
+		Params:  params,
+	})
+}
+
+func (s *Server) handle(req request) error {
+	switch req.Method {
+	case "initialize":
+		var result initializeResult
+		result.Capabilities.TextDocumentSync = textDocumentSyncFull
+		result.Capabilities.HoverProvider = true
+		result.Capabilities.DefinitionProvider = true
+		result.ServerInfo.Name = "rozer"
+		return s.reply(req.ID, result, nil)
+	case "initialized":
+		return nil
+	case "shutdown":
+		return s.reply(req.ID, nil, nil)
+	case "exit":
+		s.exit = true
+		return nil
+	case "textDocument/didOpen":
+		var params didOpenParams
+		if s.decode(req, &params) {
+			return s.update(params.TextDocument.URI, params.TextDocument.Text)
+		}
+	case "textDocument/didChange":
+		var params didChangeParams
+		if s.decode(req, &params) && len(params.ContentChanges) > 0 {
+			last := params.ContentChanges[len(params.ContentChanges)-1]
+			return s.update(params.TextDocument.URI, last.Text)
+		}
+	case "textDocument/didClose":
+		var params didCloseParams
+		if s.decode(req, &params) {
+			delete(s.docs, params.TextDocument.URI)
+			return s.notify("textDocument/publishDiagnostics", publishDiagnosticsParams{
+				URI:         params.TextDocument.URI,
+				Diagnostics: []Diagnostic{},
+			})
+		}
+	case "textDocument/hover":
+		var params textDocumentPositionParams
+		if s.decode(req, &params) {
+			var result *Hover
+			if d, ok := s.docs[params.TextDocument.URI]; ok {
+				result = d.hover(params.Position)
+			}
+			return s.reply(req.ID, result, nil)
+		}
+	case "textDocument/definition":
+		var params textDocumentPositionParams
+		if s.decode(req, &params) {
+			var result *Location
+			if d, ok := s.docs[params.TextDocument.URI]; ok {
+				result = d.definition(params.Position)
+			}
+			return s.reply(req.ID, result, nil)
+		}
+	case "textDocument/completion":
+		var params textDocumentPositionParams
+		if s.decode(req, &params) {
+			result := []CompletionItem{}
+			if d, ok := s.docs[params.TextDocument.URI]; ok {
+				result = d.completion()
+			}
+			return s.reply(req.ID, result, nil)
+		}
+	default:
+		if req.ID != nil {
+			return s.reply(req.ID, nil, &responseError{codeMethodNotFound, "method not found: " + req.Method})
+		}
+		log.Printf("lsp: ignoring notification %s", req.Method)
+	}
+	return nil
+}
+
+// decode unmarshals the request parameters, replying with an error to
+// requests whose parameters are invalid.
+func (s *Server) decode(req request, params any) bool {
+	err := json.Unmarshal(req.Params, params)
+	if err == nil {
+		return true
+	}
+	if req.ID != nil {
+		s.reply(req.ID, nil, &responseError{codeInvalidParams, err.Error()})
+	}
+	return false
+}
+
+func (s *Server) update(uri, text string) error {
+	d := newDocument(uri, text, s.docs[uri])
+	s.docs[uri] = d
+
+	diagnostics := d.diagnostics
+	if diagnostics == nil {
+		diagnostics = []Diagnostic{}
+	}
+	return s.notify("textDocument/publishDiagnostics", publishDiagnosticsParams{
+		URI:         uri,
+		Diagnostics: diagnostics,
+	})
+}
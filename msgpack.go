@@ -0,0 +1,209 @@
+package rozer
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"math/big"
+)
+
+type msgpackWriter struct {
+	buf []byte
+}
+
+func (w *msgpackWriter) null() {
+	w.buf = append(w.buf, 0xc0)
+}
+
+func (w *msgpackWriter) boolean(b bool) {
+	if b {
+		w.buf = append(w.buf, 0xc3)
+	} else {
+		w.buf = append(w.buf, 0xc2)
+	}
+}
+
+func (w *msgpackWriter) integer(i int64) {
+	switch {
+	case i >= 0:
+		w.unsigned(uint64(i))
+	case i >= -32:
+		w.buf = append(w.buf, byte(i))
+	case i >= math.MinInt8:
+		w.buf = append(w.buf, 0xd0, byte(i))
+	case i >= math.MinInt16:
+		w.buf = binary.BigEndian.AppendUint16(append(w.buf, 0xd1), uint16(i))
+	case i >= math.MinInt32:
+		w.buf = binary.BigEndian.AppendUint32(append(w.buf, 0xd2), uint32(i))
+	default:
+		w.buf = binary.BigEndian.AppendUint64(append(w.buf, 0xd3), uint64(i))
+	}
+}
+
+func (w *msgpackWriter) unsigned(u uint64) {
+	switch {
+	case u <= 0x7f:
+		w.buf = append(w.buf, byte(u))
+	case u <= math.MaxUint8:
+		w.buf = append(w.buf, 0xcc, byte(u))
+	case u <= math.MaxUint16:
+		w.buf = binary.BigEndian.AppendUint16(append(w.buf, 0xcd), uint16(u))
+	case u <= math.MaxUint32:
+		w.buf = binary.BigEndian.AppendUint32(append(w.buf, 0xce), uint32(u))
+	default:
+		w.buf = binary.BigEndian.AppendUint64(append(w.buf, 0xcf), u)
+	}
+}
+
+// float always writes a float64, so that a float with no fraction is read
+// back as a float.
+func (w *msgpackWriter) float(f float64) {
+	w.buf = binary.BigEndian.AppendUint64(append(w.buf, 0xcb), math.Float64bits(f))
+}
+
+func (w *msgpackWriter) text(s string) {
+	w.header(len(s), 0xa0, 31, 0xd9, 0xda, 0xdb)
+	w.buf = append(w.buf, s...)
+}
+
+func (w *msgpackWriter) array(n int) {
+	w.header(n, 0x90, 15, 0, 0xdc, 0xdd)
+}
+
+func (w *msgpackWriter) object(n int) {
+	w.header(n, 0x80, 15, 0, 0xde, 0xdf)
+}
+
+// header writes the length of a string, array or map: in the fix byte if it
+// is at most fixMax, otherwise after the byte for its size. Arrays and maps
+// have no 8 bit size.
+func (w *msgpackWriter) header(n int, fix byte, fixMax int, size8, size16, size32 byte) {
+	switch {
+	case n <= fixMax:
+		w.buf = append(w.buf, fix|byte(n))
+	case n <= math.MaxUint8 && size8 != 0:
+		w.buf = append(w.buf, size8, byte(n))
+	case n <= math.MaxUint16:
+		w.buf = binary.BigEndian.AppendUint16(append(w.buf, size16), uint16(n))
+	default:
+		w.buf = binary.BigEndian.AppendUint32(append(w.buf, size32), uint32(n))
+	}
+}
+
+func (w *msgpackWriter) bigInt(i *big.Int) error {
+	return fmt.Errorf("msgpack: integer %s does not fit in 64 bits", i)
+}
+
+func (w *msgpackWriter) bytes() []byte {
+	return w.buf
+}
+
+type msgpackReader struct {
+	in byteReader
+}
+
+func (rd *msgpackReader) next() (item, error) {
+	b, err := rd.in.ReadByte()
+	if err != nil {
+		return item{}, err
+	}
+
+	switch {
+	case b <= 0x7f:
+		return item{value: int64(b)}, nil
+	case b >= 0xe0:
+		return item{value: int64(int8(b))}, nil
+	case b&0xf0 == 0x80:
+		return item{kind: itemMap, n: int(b & 0x0f)}, nil
+	case b&0xf0 == 0x90:
+		return item{kind: itemArray, n: int(b & 0x0f)}, nil
+	case b&0xe0 == 0xa0:
+		return rd.text(uint64(b & 0x1f))
+	}
+
+	switch b {
+	case 0xc0:
+		return item{value: nil}, nil
+	case 0xc2:
+		return item{value: false}, nil
+	case 0xc3:
+		return item{value: true}, nil
+	case 0xcc, 0xcd, 0xce, 0xcf:
+		u, err := rd.uint(1 << (b - 0xcc))
+		if err != nil {
+			return item{}, err
+		}
+		if u > math.MaxInt64 {
+			return item{value: new(big.Int).SetUint64(u)}, nil
+		}
+		return item{value: int64(u)}, nil
+	case 0xd0:
+		u, err := rd.uint(1)
+		return item{value: int64(int8(u))}, err
+	case 0xd1:
+		u, err := rd.uint(2)
+		return item{value: int64(int16(u))}, err
+	case 0xd2:
+		u, err := rd.uint(4)
+		return item{value: int64(int32(u))}, err
+	case 0xd3:
+		u, err := rd.uint(8)
+		return item{value: int64(u)}, err
+	case 0xca:
+		u, err := rd.uint(4)
+		return item{value: float64(math.Float32frombits(uint32(u)))}, err
+	case 0xcb:
+		u, err := rd.uint(8)
+		return item{value: math.Float64frombits(u)}, err
+	case 0xd9, 0xc4:
+		return rd.sized(1, rd.text)
+	case 0xda, 0xc5:
+		return rd.sized(2, rd.text)
+	case 0xdb, 0xc6:
+		return rd.sized(4, rd.text)
+	case 0xdc, 0xdd:
+		n, err := rd.uint(2 << (b - 0xdc))
+		return item{kind: itemArray, n: int(n)}, err
+	case 0xde, 0xdf:
+		n, err := rd.uint(2 << (b - 0xde))
+		return item{kind: itemMap, n: int(n)}, err
+	default:
+		return item{}, fmt.Errorf("msgpack: unsupported type 0x%02x", b)
+	}
+}
+
+// end is never needed: every msgpack array and map has a length.
+func (rd *msgpackReader) end() (bool, error) {
+	return false, nil
+}
+
+// uint reads a big endian unsigned integer of size bytes.
+func (rd *msgpackReader) uint(size int) (uint64, error) {
+	return readUint(rd.in, size)
+}
+
+// sized reads a length of size bytes and then what it is the length of.
+func (rd *msgpackReader) sized(size int, read func(uint64) (item, error)) (item, error) {
+	n, err := rd.uint(size)
+	if err != nil {
+		return item{}, err
+	}
+	return read(n)
+}
+
+// text reads a string, or binary data, which is read as a string too.
+func (rd *msgpackReader) text(n uint64) (item, error) {
+	b, err := readFull(rd.in, n)
+	return item{value: string(b)}, err
+}
+
+// readUint reads a big endian unsigned integer of size bytes.
+func readUint(in io.Reader, size int) (uint64, error) {
+	var b [8]byte
+	_, err := io.ReadFull(in, b[8-size:])
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return binary.BigEndian.Uint64(b[:]), err
+}
@@ -0,0 +1,105 @@
+package rozer
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"strings"
+)
+
+// Numbers says how JSON numbers are decoded into a Roze.
+type Numbers int
+
+const (
+	// NumbersPreserve keeps each number as a json.Number holding its original
+	// text, so it is marshaled back exactly as it was written. Use IsInteger
+	// to tell integers from floats.
+	NumbersPreserve Numbers = iota
+	// NumbersInt64 decodes integers that fit as int64, and all other numbers
+	// as float64.
+	NumbersInt64
+	// NumbersFloat64 decodes every number as float64, as encoding/json does.
+	// Integers beyond 2^53 lose precision.
+	NumbersFloat64
+	// NumbersBig decodes integers as *big.Int and all other numbers as
+	// *big.Float, so no precision is lost.
+	NumbersBig
+)
+
+func (n Numbers) String() string {
+	switch n {
+	case NumbersPreserve:
+		return "preserve"
+	case NumbersInt64:
+		return "int64"
+	case NumbersFloat64:
+		return "float64"
+	case NumbersBig:
+		return "big"
+	default:
+		return fmt.Sprintf("Numbers(%d)", int(n))
+	}
+}
+
+// Unmarshal decodes a JSON object or array into a new Roze, converting the
+// numbers in it as numbers says. UnmarshalJSON is the same as Unmarshal with
+// NumbersPreserve.
+func Unmarshal(data []byte, numbers Numbers) (*Roze, error) {
+	return Decoding{Numbers: numbers}.Unmarshal(data)
+}
+
+// IsInteger reports whether a number as written in JSON is an integer: it has
+// no fraction or exponent.
+func IsInteger(n json.Number) bool {
+	return !strings.ContainsAny(string(n), ".eE")
+}
+
+// number converts a number token as numbers says.
+func (numbers Numbers) number(n json.Number) (any, error) {
+	switch numbers {
+	case NumbersPreserve:
+		return n, nil
+	case NumbersInt64:
+		if IsInteger(n) {
+			i, err := n.Int64()
+			if err == nil {
+				return i, nil
+			}
+		}
+		return n.Float64()
+	case NumbersFloat64:
+		return n.Float64()
+	case NumbersBig:
+		if IsInteger(n) {
+			i, ok := new(big.Int).SetString(string(n), 10)
+			if !ok {
+				return nil, fmt.Errorf("invalid JSON integer: %s", n)
+			}
+			return i, nil
+		}
+		// over 3.33 bits per decimal digit keeps every digit written.
+		prec := max(uint(len(n))*4, 64)
+		f, _, err := big.ParseFloat(string(n), 10, prec, big.ToNearestEven)
+		if err != nil {
+			return nil, fmt.Errorf("invalid JSON number: %s: %w", n, err)
+		}
+		return f, nil
+	default:
+		return nil, fmt.Errorf("unknown number conversion %s", numbers)
+	}
+}
+
+// marshalNumber returns the JSON for a number decoded with NumbersBig.
+// *big.Float would otherwise be marshaled as a string.
+func marshalNumber(f *big.Float) []byte {
+	return []byte(f.Text('g', -1))
+}
+
+// value converts a simple value token, leaving all but numbers as they are.
+func (numbers Numbers) value(token json.Token) (any, error) {
+	n, ok := token.(json.Number)
+	if !ok {
+		return token, nil
+	}
+	return numbers.number(n)
+}
@@ -0,0 +1,296 @@
+package rozer
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// StepKind is the kind of a PathStep.
+type StepKind int
+
+const (
+	// StepName selects the value with a name.
+	StepName StepKind = iota
+	// StepIndex selects the value at a position, counting from the end if
+	// the index is negative.
+	StepIndex
+	// StepWildcard selects every value.
+	StepWildcard
+)
+
+// PathStep is one step of a Path.
+type PathStep struct {
+	Kind  StepKind
+	Name  string
+	Index int
+}
+
+// Field returns a step selecting the value with the name.
+func Field(name string) PathStep {
+	return PathStep{Kind: StepName, Name: name}
+}
+
+// Index returns a step selecting the value at position i.
+func Index(i int) PathStep {
+	return PathStep{Kind: StepIndex, Index: i}
+}
+
+// Wildcard returns a step selecting every value.
+func Wildcard() PathStep {
+	return PathStep{Kind: StepWildcard}
+}
+
+// Path addresses values nested in a Roze, such as person.address.city,
+// items[2].name or items[*].id.
+type Path []PathStep
+
+// ParsePath parses a path. A path is a series of steps: names, each after a
+// dot except the first, indexes such as [2] or [-1], and wildcards, [*].
+// Names that are not simple can be quoted, as in ["line items"]. A path may
+// start with $, as in $.items[*].
+func ParsePath(s string) (Path, error) {
+	rest := strings.TrimPrefix(s, "$")
+	dotted := rest == s
+
+	path := Path{}
+	for rest != "" {
+		switch {
+		case rest[0] == '.' || (dotted && rest[0] != '['):
+			if rest[0] == '.' {
+				rest = rest[1:]
+			}
+			dotted = false
+			end := strings.IndexAny(rest, ".[]")
+			if end < 0 {
+				end = len(rest)
+			}
+			if end == 0 {
+				return nil, fmt.Errorf("path %q has an empty name", s)
+			}
+			path = append(path, Field(rest[:end]))
+			rest = rest[end:]
+		case strings.HasPrefix(rest, "[*]"):
+			path = append(path, Wildcard())
+			rest = rest[3:]
+		case strings.HasPrefix(rest, `["`):
+			end := strings.Index(rest, `"]`)
+			if end < 0 {
+				return nil, fmt.Errorf("path %q has an unclosed [\"", s)
+			}
+			name, err := strconv.Unquote(rest[1 : end+1])
+			if err != nil {
+				return nil, fmt.Errorf("path %q has an invalid name: %w", s, err)
+			}
+			path = append(path, Field(name))
+			rest = rest[end+2:]
+		case rest[0] == '[':
+			end := strings.IndexByte(rest, ']')
+			if end < 0 {
+				return nil, fmt.Errorf("path %q has an unclosed [", s)
+			}
+			i, err := strconv.Atoi(rest[1:end])
+			if err != nil {
+				return nil, fmt.Errorf("path %q has an invalid index %q", s, rest[1:end])
+			}
+			path = append(path, Index(i))
+			rest = rest[end+1:]
+		default:
+			return nil, fmt.Errorf("path %q: unexpected %q", s, rest)
+		}
+	}
+	return path, nil
+}
+
+// String returns the path as ParsePath reads it.
+func (p Path) String() string {
+	var b strings.Builder
+	for i, step := range p {
+		switch step.Kind {
+		case StepName:
+			if step.Name == "" || strings.ContainsAny(step.Name, `.[]"$ `) {
+				b.WriteString("[" + strconv.Quote(step.Name) + "]")
+				continue
+			}
+			if i > 0 {
+				b.WriteByte('.')
+			}
+			b.WriteString(step.Name)
+		case StepIndex:
+			b.WriteString("[" + strconv.Itoa(step.Index) + "]")
+		case StepWildcard:
+			b.WriteString("[*]")
+		}
+	}
+	return b.String()
+}
+
+// HasWildcard reports whether the path has a wildcard step.
+func (p Path) HasWildcard() bool {
+	for _, step := range p {
+		if step.Kind == StepWildcard {
+			return true
+		}
+	}
+	return false
+}
+
+// Get returns the value at the path in r, and whether there is one. For a
+// path with wildcards it returns a Roze array of every value matched, which
+// may be empty.
+func (p Path) Get(r *Roze) (any, bool) {
+	if !p.HasWildcard() {
+		return get(r, p)
+	}
+	matches := New()
+	collect(r, p, matches)
+	return matches, true
+}
+
+func get(value any, p Path) (any, bool) {
+	for _, step := range p {
+		r, ok := value.(*Roze)
+		if !ok || r == nil {
+			return nil, false
+		}
+		switch step.Kind {
+		case StepName:
+			value, ok = r.Lookup(step.Name)
+		case StepIndex:
+			i, inRange := r.position(step.Index)
+			if !inRange {
+				return nil, false
+			}
+			value, ok = r.data[i], true
+		}
+		if !ok {
+			return nil, false
+		}
+	}
+	return value, true
+}
+
+// collect appends to matches each value at the path in value.
+func collect(value any, p Path, matches *Roze) {
+	for i, step := range p {
+		if step.Kind != StepWildcard {
+			continue
+		}
+		parent, ok := get(value, p[:i])
+		if !ok {
+			return
+		}
+		r, ok := parent.(*Roze)
+		if !ok || r == nil {
+			return
+		}
+		for _, item := range r.data {
+			collect(item, p[i+1:], matches)
+		}
+		return
+	}
+
+	value, ok := get(value, p)
+	if ok {
+		matches.Append(value)
+	}
+}
+
+// position returns the position of index i, counting from the end if i is
+// negative, and whether it is in range.
+func (r *Roze) position(i int) (int, bool) {
+	if i < 0 {
+		i = len(r.data) + i
+	}
+	return i, i >= 0 && i < len(r.data)
+}
+
+// Set sets the value at the path in r. Missing Roze values on the way are
+// created, and setting an index beyond the end extends the Roze with nils.
+// A wildcard sets the value in every value it matches.
+func (p Path) Set(r *Roze, value any) error {
+	if len(p) == 0 {
+		return fmt.Errorf("cannot set an empty path")
+	}
+	return set(r, p, 0, value)
+}
+
+func set(r *Roze, p Path, at int, value any) error {
+	step := p[at]
+	last := at == len(p)-1
+
+	if step.Kind == StepWildcard {
+		for i := range r.data {
+			err := setAt(r, i, p, at, value, last)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	if step.Kind == StepName {
+		if last {
+			r.Put(step.Name, value)
+			return nil
+		}
+		child, ok := r.Lookup(step.Name)
+		if !ok || child == nil {
+			child = New()
+			r.Put(step.Name, child)
+		}
+		next, ok := child.(*Roze)
+		if !ok {
+			return fmt.Errorf("%s is %T, not a Roze", p[:at+1], child)
+		}
+		return set(next, p, at+1, value)
+	}
+
+	i := step.Index
+	if i < 0 {
+		i = len(r.data) + i
+		if i < 0 {
+			return fmt.Errorf("%s is out of range", p[:at+1])
+		}
+	}
+	return setAt(r, i, p, at, value, last)
+}
+
+// setAt sets the value at position i of r, or at the rest of the path in the
+// value at position i.
+func setAt(r *Roze, i int, p Path, at int, value any, last bool) error {
+	if last {
+		r.Set(i, value)
+		return nil
+	}
+	child := r.At(i)
+	if i >= len(r.data) || child == nil {
+		child = New()
+		r.Set(i, child)
+	}
+	next, ok := child.(*Roze)
+	if !ok {
+		return fmt.Errorf("%s is %T, not a Roze", p[:at+1], child)
+	}
+	return set(next, p, at+1, value)
+}
+
+// Path returns the value at the path in r, as Path.Get does, and whether
+// there is one. It returns false if the path is not valid; use ParsePath to
+// find out why.
+func (r *Roze) Path(path string) (any, bool) {
+	p, err := ParsePath(path)
+	if err != nil {
+		return nil, false
+	}
+	return p.Get(r)
+}
+
+// SetPath sets the value at the path in r, as Path.Set does.
+func (r *Roze) SetPath(path string, value any) error {
+	p, err := ParsePath(path)
+	if err != nil {
+		return err
+	}
+	return p.Set(r, value)
+}
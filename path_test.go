@@ -0,0 +1,110 @@
+package rozer
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestParsePath(t *testing.T) {
+	tests := []struct {
+		in, want string
+		err      bool
+	}{
+		{"person.address.city", "person.address.city", false},
+		{"items[2].name", "items[2].name", false},
+		{"items[*].id", "items[*].id", false},
+		{"$.items[-1]", "items[-1]", false},
+		{`data["line items"][0]`, `data["line items"][0]`, false},
+		{"[0].a", "[0].a", false},
+		{"", "", false},
+		{"a..b", "", true},
+		{"a[x]", "", true},
+		{"a[1", "", true},
+		{"a]", "", true},
+	}
+	for _, tt := range tests {
+		p, err := ParsePath(tt.in)
+		if (err != nil) != tt.err {
+			t.Errorf("%q: got error %v", tt.in, err)
+			continue
+		}
+		if err == nil && p.String() != tt.want {
+			t.Errorf("%q: got %q, want %q", tt.in, p, tt.want)
+		}
+	}
+}
+
+func TestPathGet(t *testing.T) {
+	r, err := Unmarshal([]byte(`{
+		"person": {"address": {"city": "Cityville"}},
+		"items": [{"id": 1, "name": "one"}, {"id": 2}, {"name": "three"}, 4]
+	}`), NumbersInt64)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		path string
+		want string
+		ok   bool
+	}{
+		{"person.address.city", `"Cityville"`, true},
+		{"items[0].name", `"one"`, true},
+		{"items[-1]", `4`, true},
+		{"items[*].id", `[1,2]`, true},
+		{"items[*].name", `["one","three"]`, true},
+		{"nothing[*].id", `[]`, true},
+		{"items[1].name", ``, false},
+		{"items[9]", ``, false},
+		{"person.address.city.zip", ``, false},
+		{"a..b", ``, false},
+	}
+	for _, tt := range tests {
+		got, ok := r.Path(tt.path)
+		if ok != tt.ok {
+			t.Errorf("%s: got ok %v", tt.path, ok)
+			continue
+		}
+		if !ok {
+			continue
+		}
+		b, _ := json.Marshal(got)
+		if string(b) != tt.want {
+			t.Errorf("%s: got %s, want %s", tt.path, b, tt.want)
+		}
+	}
+}
+
+func TestPathSet(t *testing.T) {
+	r := New()
+	for _, set := range []struct {
+		path  string
+		value any
+	}{
+		{"person.address.city", "Cityville"},
+		{"person.name", "Bob"},
+		{"items[2].name", "three"},
+		{"items[0]", 1},
+		{"items[-1].id", 3},
+		{"tags[*]", "x"},
+	} {
+		if err := r.SetPath(set.path, set.value); err != nil {
+			t.Fatalf("%s: %v", set.path, err)
+		}
+	}
+
+	want := `{"person":{"address":{"city":"Cityville"},"name":"Bob"},"items":[1,null,{"name":"three","id":3}],"tags":[]}`
+	if got := marshal(t, r); got != want {
+		t.Errorf("got  %s\nwant %s", got, want)
+	}
+
+	if err := r.SetPath("items[*].flag", true); err == nil {
+		t.Errorf("setting a field of 1 did not fail")
+	}
+	if err := r.SetPath("items[-9]", 0); err == nil {
+		t.Errorf("setting index -9 did not fail")
+	}
+	if err := r.SetPath("person.name.first", "B"); err == nil || err.Error() != "person.name is string, not a Roze" {
+		t.Errorf("got error %v", err)
+	}
+}
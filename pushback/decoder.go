@@ -3,7 +3,6 @@ package pushback
 import (
 	"encoding/json"
 	"io"
-	"log"
 )
 
 type Decoder struct {
@@ -17,6 +16,12 @@ func NewDecoder(r io.Reader) *Decoder {
 	}
 }
 
+// UseNumber makes the Decoder return numbers as json.Number rather than
+// float64.
+func (d *Decoder) UseNumber() {
+	d.decoder.UseNumber()
+}
+
 func (d *Decoder) Token() (json.Token, error) {
 	if len(d.buf) > 0 {
 		t := d.buf[0]
@@ -28,5 +33,4 @@ func (d *Decoder) Token() (json.Token, error) {
 
 func (d *Decoder) Pushback(t json.Token) {
 	d.buf = append(d.buf, t)
-	log.Printf("pushback: %v (%d)", t, len(d.buf))
 }
@@ -1,14 +1,50 @@
 package rozer
 
+import (
+	"iter"
+	"maps"
+	"math/big"
+	"reflect"
+	"slices"
+)
+
+// Roze is an ordered list of values, some or all of which may be named. A
+// Roze with every value named is an object, one with no names an array, and
+// one with some of each is mixed.
 type Roze struct {
 	data  []any
 	names map[string]int
+	// shadowed holds the entries added by Add with a name already in use.
+	// names refers to the last entry with a name, so these are the earlier
+	// ones, in position order.
+	shadowed []entry
+	// shared reports that names is shared with other Roze values, through
+	// Layouts, and must be copied before it is changed.
+	shared bool
+}
+
+// entry is a named position.
+type entry struct {
+	name string
+	pos  int
 }
 
+// New returns an empty Roze. It is an array until a value is named.
 func New() *Roze {
 	return &Roze{}
 }
 
+// NewObject returns an empty Roze that is an object, and so marshals to JSON
+// as {} rather than [].
+func NewObject() *Roze {
+	return &Roze{names: map[string]int{}}
+}
+
+// IsArray reports whether no value of the Roze has ever been named.
+func (r *Roze) IsArray() bool {
+	return r.names == nil
+}
+
 func (r *Roze) Len() int {
 	return len(r.data)
 }
@@ -27,22 +63,75 @@ func (r *Roze) Put(name string, value any) *Roze {
 		r.data[p] = value
 		return r
 	}
+	r.own()
 	r.names[name] = len(r.data)
 	r.data = append(r.data, value)
 	return r
 }
 
+// Add appends a named value, like Put, but if the name is already in use it
+// keeps the earlier value too. Get then returns the last value with the name,
+// and the Roze marshals to JSON with the name repeated.
+func (r *Roze) Add(name string, value any) *Roze {
+	if p, ok := r.names[name]; ok {
+		r.own()
+		r.shadowed = append(r.shadowed, entry{name, p})
+		delete(r.names, name)
+	}
+	return r.Put(name, value)
+}
+
+// own makes the names of r its own, copying them if they are shared.
+func (r *Roze) own() {
+	if r.shared {
+		r.names = maps.Clone(r.names)
+		r.shared = false
+	}
+}
+
+// nameAt returns the name of each position, and whether it has one.
+func (r *Roze) nameAt() ([]string, []bool) {
+	names := make([]string, len(r.data))
+	named := make([]bool, len(r.data))
+	for name, p := range r.names {
+		names[p], named[p] = name, true
+	}
+	for _, e := range r.shadowed {
+		names[e.pos], named[e.pos] = e.name, true
+	}
+	return names, named
+}
+
+// Get returns the value with the name, or nil if there is none.
 func (r *Roze) Get(name string) any {
-	return r.data[r.names[name]]
+	value, _ := r.Lookup(name)
+	return value
 }
 
-func (r *Roze) At(i int) any {
-	if i >= len(r.data) {
-		return nil
+// Lookup returns the value with the name, and whether there is one.
+func (r *Roze) Lookup(name string) (any, bool) {
+	p, ok := r.names[name]
+	if !ok {
+		return nil, false
 	}
+	return r.data[p], true
+}
+
+// Has reports whether the Roze has a value with the name.
+func (r *Roze) Has(name string) bool {
+	_, ok := r.names[name]
+	return ok
+}
+
+// At returns the value at position i, counting from the end if i is
+// negative, or nil if there is no such position.
+func (r *Roze) At(i int) any {
 	if i < 0 {
 		i = len(r.data) + i
 	}
+	if i < 0 || i >= len(r.data) {
+		return nil
+	}
 	return r.data[i]
 }
 
@@ -53,3 +142,219 @@ func (r *Roze) Set(i int, value any) *Roze {
 	r.data[i] = value
 	return r
 }
+
+// Delete removes every value with the name. The values after them move up.
+func (r *Roze) Delete(name string) *Roze {
+	return r.remove(func(n string, named bool, _ int) bool {
+		return named && n == name
+	})
+}
+
+// DeleteAt removes the value at position i, counting from the end if i is
+// negative. The values after it move up.
+func (r *Roze) DeleteAt(i int) *Roze {
+	if i < 0 {
+		i = len(r.data) + i
+	}
+	return r.remove(func(_ string, _ bool, p int) bool {
+		return p == i
+	})
+}
+
+// remove removes the positions drop reports true for, and renumbers the
+// names of the rest.
+func (r *Roze) remove(drop func(name string, named bool, p int) bool) *Roze {
+	names, named := r.nameAt()
+	r.own()
+
+	kept := r.data[:0]
+	var shadowed []entry
+	for p, value := range r.data {
+		if drop(names[p], named[p], p) {
+			if named[p] && r.names[names[p]] == p {
+				delete(r.names, names[p])
+			}
+			continue
+		}
+		if named[p] {
+			if r.names[names[p]] == p {
+				r.names[names[p]] = len(kept)
+			} else {
+				shadowed = append(shadowed, entry{names[p], len(kept)})
+			}
+		}
+		kept = append(kept, value)
+	}
+	clear(r.data[len(kept):])
+	r.data = kept
+
+	// if the last value with a name was removed, the one before it is now
+	// the value with the name.
+	r.shadowed = nil
+	for i := len(shadowed) - 1; i >= 0; i-- {
+		e := shadowed[i]
+		if _, ok := r.names[e.name]; !ok {
+			r.names[e.name] = e.pos
+			continue
+		}
+		r.shadowed = append(r.shadowed, e)
+	}
+	slices.Reverse(r.shadowed)
+	return r
+}
+
+// Rename gives the values named from the name to instead, keeping their
+// positions. It reports false, and changes nothing, if there is no value
+// named from or a value is already named to.
+func (r *Roze) Rename(from, to string) bool {
+	p, ok := r.names[from]
+	if !ok || r.Has(to) {
+		return false
+	}
+	r.own()
+	delete(r.names, from)
+	r.names[to] = p
+	for i := range r.shadowed {
+		if r.shadowed[i].name == from {
+			r.shadowed[i].name = to
+		}
+	}
+	return true
+}
+
+// Keys returns the names of the named values, in order. A name given to
+// several values with Add appears once for each.
+func (r *Roze) Keys() []string {
+	names, named := r.nameAt()
+	keys := make([]string, 0, len(r.names)+len(r.shadowed))
+	for p, name := range names {
+		if named[p] {
+			keys = append(keys, name)
+		}
+	}
+	return keys
+}
+
+// NameAt returns the name of the value at position i, and whether it has
+// one.
+func (r *Roze) NameAt(i int) (string, bool) {
+	for name, p := range r.names {
+		if p == i {
+			return name, true
+		}
+	}
+	for _, e := range r.shadowed {
+		if e.pos == i {
+			return e.name, true
+		}
+	}
+	return "", false
+}
+
+// Each calls fn with the position, name and value of each value in order,
+// until fn returns false. The name of a value without one is "".
+func (r *Roze) Each(fn func(i int, name string, value any) bool) {
+	names, _ := r.nameAt()
+	for i, value := range r.data {
+		if !fn(i, names[i], value) {
+			return
+		}
+	}
+}
+
+// All returns an iterator over the names and values in order, for use with
+// range. The name of a value without one is "".
+func (r *Roze) All() iter.Seq2[string, any] {
+	return func(yield func(string, any) bool) {
+		r.Each(func(_ int, name string, value any) bool {
+			return yield(name, value)
+		})
+	}
+}
+
+// Values returns an iterator over the positions and values in order, for use
+// with range.
+func (r *Roze) Values() iter.Seq2[int, any] {
+	return func(yield func(int, any) bool) {
+		for i, value := range r.data {
+			if !yield(i, value) {
+				return
+			}
+		}
+	}
+}
+
+// Clone returns a deep copy of the Roze. Nested Roze values and big numbers
+// are copied too, so changing the copy never changes the original. Names
+// shared through Layouts stay shared.
+func (r *Roze) Clone() *Roze {
+	c := &Roze{
+		data:     make([]any, len(r.data)),
+		shadowed: slices.Clone(r.shadowed),
+	}
+	if r.shared {
+		c.names, c.shared = r.names, true
+	} else if r.names != nil {
+		c.names = maps.Clone(r.names)
+	}
+	for i, value := range r.data {
+		c.data[i] = cloneValue(value)
+	}
+	return c
+}
+
+func cloneValue(value any) any {
+	switch v := value.(type) {
+	case *Roze:
+		if v == nil {
+			return v
+		}
+		return v.Clone()
+	case *big.Int:
+		return new(big.Int).Set(v)
+	case *big.Float:
+		return new(big.Float).Copy(v)
+	default:
+		return value
+	}
+}
+
+// Equal reports whether two Roze values have the same values, with the same
+// names, in the same order. Nested Roze values and big numbers are compared
+// by content. An object is never equal to an array, even if both are empty.
+func (r *Roze) Equal(other *Roze) bool {
+	if r == nil || other == nil {
+		return r == other
+	}
+	if len(r.data) != len(other.data) || (r.names == nil) != (other.names == nil) {
+		return false
+	}
+
+	names, named := r.nameAt()
+	otherNames, otherNamed := other.nameAt()
+	for i := range r.data {
+		if named[i] != otherNamed[i] || names[i] != otherNames[i] {
+			return false
+		}
+		if !equalValue(r.data[i], other.data[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func equalValue(a, b any) bool {
+	switch a := a.(type) {
+	case *Roze:
+		b, ok := b.(*Roze)
+		return ok && a.Equal(b)
+	case *big.Int:
+		b, ok := b.(*big.Int)
+		return ok && a.Cmp(b) == 0
+	case *big.Float:
+		b, ok := b.(*big.Float)
+		return ok && a.Cmp(b) == 0
+	default:
+		return reflect.DeepEqual(a, b)
+	}
+}
@@ -0,0 +1,175 @@
+package rozer
+
+import (
+	"encoding/json"
+	"math/big"
+	"slices"
+	"testing"
+)
+
+func marshal(t *testing.T, r *Roze) string {
+	t.Helper()
+	b, err := json.Marshal(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+func TestGetMissing(t *testing.T) {
+	r := New()
+	if got := r.Get("x"); got != nil {
+		t.Errorf("empty: Get(x) = %v", got)
+	}
+	r.Put("a", 1)
+	if got := r.Get("x"); got != nil {
+		t.Errorf("Get(x) = %v, want nil rather than the value at 0", got)
+	}
+	if _, ok := r.Lookup("x"); ok || !r.Has("a") || r.Has("x") {
+		t.Errorf("Lookup or Has wrong")
+	}
+	if got := r.At(-5); got != nil {
+		t.Errorf("At(-5) = %v", got)
+	}
+}
+
+func TestDeleteAndRename(t *testing.T) {
+	r := New().Put("a", 1).Put("b", 2).Put("c", 3).Put("d", 4)
+
+	r.Delete("b")
+	if got := marshal(t, r); got != `{"a":1,"c":3,"d":4}` {
+		t.Errorf("Delete: got %s", got)
+	}
+	if r.Get("d") != 4 || r.At(1) != 3 {
+		t.Errorf("Delete did not renumber: d=%v, [1]=%v", r.Get("d"), r.At(1))
+	}
+
+	r.DeleteAt(-1)
+	if got := marshal(t, r); got != `{"a":1,"c":3}` || r.Has("d") {
+		t.Errorf("DeleteAt: got %s", got)
+	}
+
+	if !r.Rename("a", "z") || r.Rename("z", "c") || r.Rename("missing", "y") {
+		t.Errorf("Rename reported wrongly")
+	}
+	if got := marshal(t, r); got != `{"z":1,"c":3}` {
+		t.Errorf("Rename: got %s", got)
+	}
+	if !slices.Equal(r.Keys(), []string{"z", "c"}) {
+		t.Errorf("Keys: got %v", r.Keys())
+	}
+}
+
+func TestDeleteDuplicates(t *testing.T) {
+	r := New().Add("a", 1).Put("b", 2).Add("a", 3).Add("a", 4)
+
+	r.DeleteAt(3)
+	if r.Get("a") != 3 {
+		t.Errorf("after deleting the last a, Get(a) = %v, want 3", r.Get("a"))
+	}
+	if got := marshal(t, r); got != `{"a":1,"b":2,"a":3}` {
+		t.Errorf("DeleteAt: got %s", got)
+	}
+
+	r.Delete("a")
+	if got := marshal(t, r); got != `{"b":2}` || r.Has("a") {
+		t.Errorf("Delete: got %s", got)
+	}
+}
+
+func TestIterate(t *testing.T) {
+	r := New().Append("x").Put("a", 1).Add("a", 2)
+
+	var names []string
+	var values []any
+	for name, value := range r.All() {
+		names = append(names, name)
+		values = append(values, value)
+	}
+	if !slices.Equal(names, []string{"", "a", "a"}) || !slices.Equal(values, []any{"x", 1, 2}) {
+		t.Errorf("All: got %v %v", names, values)
+	}
+
+	count := 0
+	for i := range r.Values() {
+		if i == 1 {
+			break
+		}
+		count++
+	}
+	if count != 1 {
+		t.Errorf("Values: break did not stop the iteration")
+	}
+
+	if name, ok := r.NameAt(0); ok || name != "" {
+		t.Errorf("NameAt(0) = %q, %v", name, ok)
+	}
+	if name, ok := r.NameAt(1); !ok || name != "a" {
+		t.Errorf("NameAt(1) = %q, %v", name, ok)
+	}
+}
+
+func TestCloneAndEqual(t *testing.T) {
+	r, err := Decoding{Numbers: NumbersBig}.Unmarshal([]byte(`{"a":{"b":[1,2.5]},"c":"d"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := r.Clone()
+	if !r.Equal(c) || !c.Equal(r) {
+		t.Fatalf("clone is not equal")
+	}
+
+	nested, _ := c.GetRoze("a")
+	list, _ := nested.GetRoze("b")
+	list.At(0).(*big.Int).SetInt64(7)
+	list.Append(3)
+	if r.Equal(c) {
+		t.Errorf("changing the clone changed the original: %s", marshal(t, r))
+	}
+	if got := marshal(t, r); got != `{"a":{"b":[1,2.5]},"c":"d"}` {
+		t.Errorf("original: got %s", got)
+	}
+
+	if New().Equal(New().Put("a", 1).Delete("a")) {
+		t.Errorf("an empty array equals an empty object")
+	}
+	if New().Put("a", 1).Equal(New().Put("b", 1)) {
+		t.Errorf("different names are equal")
+	}
+}
+
+func TestTypedGetters(t *testing.T) {
+	r, err := Unmarshal([]byte(`{"s":"x","b":true,"i":9007199254740993,"f":2.5,"whole":2.0,"o":{}}`), NumbersPreserve)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if s, ok := r.GetString("s"); !ok || s != "x" {
+		t.Errorf("GetString(s) = %q, %v", s, ok)
+	}
+	if _, ok := r.GetString("b"); ok {
+		t.Errorf("GetString(b) ok")
+	}
+	if b, ok := r.GetBool("b"); !ok || !b {
+		t.Errorf("GetBool(b) = %v, %v", b, ok)
+	}
+	if i, ok := r.GetInt("i"); !ok || i != 9007199254740993 {
+		t.Errorf("GetInt(i) = %v, %v", i, ok)
+	}
+	if i, ok := r.GetInt("whole"); !ok || i != 2 {
+		t.Errorf("GetInt(whole) = %v, %v", i, ok)
+	}
+	if _, ok := r.GetInt("f"); ok {
+		t.Errorf("GetInt(f) ok")
+	}
+	if f, ok := r.GetFloat("f"); !ok || f != 2.5 {
+		t.Errorf("GetFloat(f) = %v, %v", f, ok)
+	}
+	if _, ok := r.GetFloat("missing"); ok {
+		t.Errorf("GetFloat(missing) ok")
+	}
+	if o, ok := r.GetRoze("o"); !ok || o.Len() != 0 {
+		t.Errorf("GetRoze(o) = %v, %v", o, ok)
+	}
+}
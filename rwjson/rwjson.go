@@ -1,7 +1,11 @@
 package main
 
 import (
+	"bufio"
 	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
 	"io"
 	"log"
 	"os"
@@ -10,6 +14,26 @@ import (
 )
 
 func main() {
+	path := flag.String("path", "", "stream the elements of the array at this path, such as $[*] or $.items[*], one per line")
+	infer := flag.Int("infer", 0, "with -path, write the JSON Schema of the first n elements instead of the elements")
+	schemaFile := flag.String("schema", "", "with -path, report each element that does not match this JSON Schema")
+	flag.Parse()
+
+	if *path != "" {
+		var schema *rozer.Schema
+		if *schemaFile != "" {
+			var err error
+			schema, err = readSchema(*schemaFile)
+			if err != nil {
+				log.Fatal(err)
+			}
+		}
+		err := stream(*path, *infer, schema)
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
 
 	input, err := io.ReadAll(os.Stdin)
 	if err != nil {
@@ -34,3 +58,77 @@ func main() {
 	os.Stdout.Write(output)
 	os.Stdout.WriteString("\n")
 }
+
+// readSchema reads a JSON Schema from a file.
+func readSchema(name string) (*rozer.Schema, error) {
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	schema := &rozer.Schema{}
+	err = json.Unmarshal(data, schema)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	return schema, nil
+}
+
+// stream writes each element of the array at path as a line of JSON, without
+// reading the whole input into memory. If infer is positive it writes the
+// schema of the first infer elements instead. If schema is not nil each
+// element is checked against it, and each violation is logged.
+func stream(path string, infer int, schema *rozer.Schema) error {
+	ar, err := rozer.NewPathReader(bufio.NewReader(os.Stdin), path, rozer.NumbersPreserve)
+	if err != nil {
+		return err
+	}
+
+	out := bufio.NewWriter(os.Stdout)
+	defer out.Flush()
+
+	inferred := &rozer.Schema{}
+	failed := 0
+	for row := 1; ; row++ {
+		r, err := ar.Next()
+		if errors.Is(err, io.EOF) || (err == nil && infer > 0 && row > infer) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		if schema != nil {
+			violations := schema.Validate(row, r)
+			for _, v := range violations {
+				log.Print(v)
+			}
+			if len(violations) > 0 {
+				failed++
+			}
+		}
+		if infer > 0 {
+			inferred.Observe(r)
+			continue
+		}
+
+		line, err := json.Marshal(r)
+		if err != nil {
+			return err
+		}
+		out.Write(line)
+		out.WriteByte('\n')
+	}
+
+	if infer > 0 {
+		output, err := json.MarshalIndent(inferred, "", "  ")
+		if err != nil {
+			return err
+		}
+		out.Write(output)
+		out.WriteByte('\n')
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d elements do not match the schema", failed)
+	}
+	return nil
+}
@@ -0,0 +1,416 @@
+package rozer
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"slices"
+	"strings"
+)
+
+// Type is a set of JSON types, as found in the values a Schema describes.
+type Type uint
+
+const (
+	TypeNull Type = 1 << iota
+	TypeBool
+	TypeInteger
+	TypeNumber
+	TypeString
+	TypeObject
+	TypeArray
+)
+
+// typeNames are the JSON Schema names of the types, in the order of their
+// bits.
+var typeNames = []string{"null", "boolean", "integer", "number", "string", "object", "array"}
+
+// TypeOf returns the type of a value held in a Roze, or 0 if it is not a JSON
+// value. A Roze is an array if it has no names, and an object otherwise.
+func TypeOf(value any) Type {
+	switch v := value.(type) {
+	case nil:
+		return TypeNull
+	case bool:
+		return TypeBool
+	case int, int64, *big.Int:
+		return TypeInteger
+	case float64, *big.Float:
+		return TypeNumber
+	case json.Number:
+		if IsInteger(v) {
+			return TypeInteger
+		}
+		return TypeNumber
+	case string:
+		return TypeString
+	case *Roze:
+		if v == nil {
+			return TypeNull
+		}
+		if v.IsArray() {
+			return TypeArray
+		}
+		return TypeObject
+	default:
+		return 0
+	}
+}
+
+// names returns the JSON Schema names of the types in t. Integers are a kind
+// of number, so integer is left out if number is in t.
+func (t Type) names() []string {
+	var names []string
+	for i, name := range typeNames {
+		bit := Type(1) << i
+		if t&bit == 0 || (bit == TypeInteger && t&TypeNumber != 0) {
+			continue
+		}
+		names = append(names, name)
+	}
+	return names
+}
+
+func (t Type) String() string {
+	if t == 0 {
+		return "any"
+	}
+	return strings.Join(t.names(), " or ")
+}
+
+func parseType(name string) (Type, error) {
+	i := slices.Index(typeNames, name)
+	if i < 0 {
+		return 0, fmt.Errorf("unknown type %q", name)
+	}
+	return Type(1) << i, nil
+}
+
+// Schema describes the values found at one place in a set of rows: their
+// types, whether they may be null, and, for objects and arrays, what they
+// hold. The zero Schema allows any value.
+type Schema struct {
+	// Type is the set of types allowed, or 0 if any type is.
+	Type Type
+	// Properties are the fields of objects, in the order they were first
+	// seen.
+	Properties []*Property
+	// AdditionalProperties allows objects to have fields not in Properties.
+	AdditionalProperties bool
+	// Items describes the items of arrays, or is nil if any item is allowed.
+	Items *Schema
+}
+
+// Property is a field of the objects a Schema describes.
+type Property struct {
+	Name string
+	// Required is true if every object has the field.
+	Required bool
+	Schema   *Schema
+}
+
+// InferSchema returns a schema that describes every one of rows, and so
+// probably the rows that follow them.
+func InferSchema(rows ...*Roze) *Schema {
+	s := &Schema{}
+	for _, r := range rows {
+		s.Observe(r)
+	}
+	return s
+}
+
+// Observe widens the schema to describe value too. A field missing from any
+// object observed is not required. The values without names in a mixed Roze
+// are not part of its schema.
+func (s *Schema) Observe(value any) {
+	t := TypeOf(value)
+	seenObject := s.Type&TypeObject != 0
+	s.Type |= t
+
+	switch t {
+	case TypeObject:
+		r := value.(*Roze)
+		found := 0
+		for _, p := range s.Properties {
+			v, ok := r.Lookup(p.Name)
+			if !ok {
+				p.Required = false
+				continue
+			}
+			found++
+			p.Schema.Observe(v)
+		}
+		if found == len(r.names) {
+			return
+		}
+		// new fields, in the order of the row.
+		names, named := r.nameAt()
+		for i, name := range names {
+			if !named[i] || r.names[name] != i || s.Property(name) != nil {
+				continue
+			}
+			p := &Property{Name: name, Required: !seenObject, Schema: &Schema{}}
+			p.Schema.Observe(r.data[i])
+			s.Properties = append(s.Properties, p)
+		}
+	case TypeArray:
+		r := value.(*Roze)
+		for _, item := range r.data {
+			if s.Items == nil {
+				s.Items = &Schema{}
+			}
+			s.Items.Observe(item)
+		}
+	}
+}
+
+// Property returns the property with the name, or nil if there is none.
+func (s *Schema) Property(name string) *Property {
+	for _, p := range s.Properties {
+		if p.Name == name {
+			return p
+		}
+	}
+	return nil
+}
+
+// Nullable reports whether the schema allows null.
+func (s *Schema) Nullable() bool {
+	return s.Type == 0 || s.Type&TypeNull != 0
+}
+
+// Violation is a way a row does not match a Schema.
+type Violation struct {
+	// Row is the row number given to Validate.
+	Row int
+	// Path is where in the row the violation is.
+	Path Path
+	Msg  string
+}
+
+func (v Violation) Error() string {
+	if len(v.Path) == 0 {
+		return fmt.Sprintf("row %d: %s", v.Row, v.Msg)
+	}
+	return fmt.Sprintf("row %d: %s %s", v.Row, v.Path, v.Msg)
+}
+
+// Validate returns each way row does not match the schema, numbered as row
+// number row. A row matches if it has only the types the schema allows, has
+// every required field, and, unless the schema allows additional
+// properties, has no others. An integer matches a number, and a number with
+// no fraction matches an integer.
+func (s *Schema) Validate(row int, r *Roze) []Violation {
+	var violations []Violation
+	s.check(r, nil, func(path Path, format string, args ...any) {
+		violations = append(violations, Violation{row, slices.Clone(path), fmt.Sprintf(format, args...)})
+	})
+	return violations
+}
+
+func (s *Schema) check(value any, path Path, report func(Path, string, ...any)) {
+	t := TypeOf(value)
+	if t == 0 {
+		report(path, "has unsupported type %T", value)
+		return
+	}
+	if !s.allows(t, value) {
+		report(path, "is %s, want %s", t, s.Type)
+		return
+	}
+
+	switch {
+	case t == TypeObject && s.Type != 0:
+		r := value.(*Roze)
+		found := 0
+		for _, p := range s.Properties {
+			v, ok := r.Lookup(p.Name)
+			if !ok {
+				if p.Required {
+					report(append(path, Field(p.Name)), "is missing")
+				}
+				continue
+			}
+			found++
+			p.Schema.check(v, append(path, Field(p.Name)), report)
+		}
+		if s.AdditionalProperties || found == len(r.names) {
+			return
+		}
+		names, named := r.nameAt()
+		for i, name := range names {
+			if named[i] && r.names[name] == i && s.Property(name) == nil {
+				report(append(path, Field(name)), "is not in the schema")
+			}
+		}
+	case t == TypeArray && s.Items != nil:
+		r := value.(*Roze)
+		for i, item := range r.data {
+			s.Items.check(item, append(path, Index(i)), report)
+		}
+	}
+}
+
+// allows reports whether the schema allows a value of type t.
+func (s *Schema) allows(t Type, value any) bool {
+	switch {
+	case s.Type == 0 || s.Type&t != 0:
+		return true
+	case t == TypeInteger:
+		return s.Type&TypeNumber != 0
+	case t == TypeNumber && s.Type&TypeInteger != 0:
+		_, ok := toInt(value)
+		return ok
+	default:
+		return false
+	}
+}
+
+// MarshalJSON writes the schema as a JSON Schema document.
+func (s *Schema) MarshalJSON() ([]byte, error) {
+	doc := NewObject()
+	doc.Put("$schema", "https://json-schema.org/draft/2020-12/schema")
+	s.describe(doc)
+	return json.Marshal(doc)
+}
+
+// describe puts the JSON Schema keywords for the schema in doc.
+func (s *Schema) describe(doc *Roze) {
+	switch names := s.Type.names(); len(names) {
+	case 0:
+	case 1:
+		doc.Put("type", names[0])
+	default:
+		types := New()
+		for _, name := range names {
+			types.Append(name)
+		}
+		doc.Put("type", types)
+	}
+
+	if s.Type&TypeObject != 0 {
+		properties := NewObject()
+		required := New()
+		for _, p := range s.Properties {
+			property := NewObject()
+			p.Schema.describe(property)
+			properties.Put(p.Name, property)
+			if p.Required {
+				required.Append(p.Name)
+			}
+		}
+		doc.Put("properties", properties)
+		if required.Len() > 0 {
+			doc.Put("required", required)
+		}
+		doc.Put("additionalProperties", s.AdditionalProperties)
+	}
+
+	if s.Items != nil {
+		items := NewObject()
+		s.Items.describe(items)
+		doc.Put("items", items)
+	}
+}
+
+// UnmarshalJSON reads a JSON Schema document, such as MarshalJSON writes. Only
+// the keywords type, properties, required, additionalProperties and items
+// are used; others are ignored. Objects allow additional properties unless
+// additionalProperties is false.
+func (s *Schema) UnmarshalJSON(data []byte) error {
+	doc, err := Unmarshal(data, NumbersPreserve)
+	if err != nil {
+		return err
+	}
+	if doc.IsArray() {
+		return fmt.Errorf("schema must be an object")
+	}
+	*s = Schema{}
+	return s.read(doc, nil)
+}
+
+// read sets the schema from the JSON Schema keywords in doc, which is at path
+// in the document.
+func (s *Schema) read(doc *Roze, path Path) error {
+	fail := func(format string, args ...any) error {
+		where := "$"
+		if len(path) > 0 {
+			where += "." + path.String()
+		}
+		return fmt.Errorf("schema at %s: %s", where, fmt.Sprintf(format, args...))
+	}
+
+	s.AdditionalProperties = true
+	switch t := doc.Get("type").(type) {
+	case nil:
+	case string:
+		parsed, err := parseType(t)
+		if err != nil {
+			return fail("%s", err)
+		}
+		s.Type = parsed
+	case *Roze:
+		for _, name := range t.Values() {
+			name, ok := name.(string)
+			if !ok {
+				return fail("type must be a string or a list of strings")
+			}
+			parsed, err := parseType(name)
+			if err != nil {
+				return fail("%s", err)
+			}
+			s.Type |= parsed
+		}
+	default:
+		return fail("type must be a string or a list of strings")
+	}
+
+	if properties, ok := doc.GetRoze("properties"); ok {
+		for name, value := range properties.All() {
+			property, ok := value.(*Roze)
+			if !ok || property.IsArray() {
+				return fail("property %q must be an object", name)
+			}
+			p := &Property{Name: name, Schema: &Schema{}}
+			err := p.Schema.read(property, append(path, Field("properties"), Field(name)))
+			if err != nil {
+				return err
+			}
+			s.Properties = append(s.Properties, p)
+		}
+	}
+
+	if required, ok := doc.GetRoze("required"); ok {
+		for _, name := range required.Values() {
+			name, ok := name.(string)
+			if !ok {
+				return fail("required must be a list of strings")
+			}
+			p := s.Property(name)
+			if p == nil {
+				// required but otherwise unconstrained.
+				p = &Property{Name: name, Schema: &Schema{}}
+				s.Properties = append(s.Properties, p)
+			}
+			p.Required = true
+		}
+	}
+
+	if additional, ok := doc.Lookup("additionalProperties"); ok {
+		allowed, ok := additional.(bool)
+		if !ok {
+			return fail("additionalProperties must be true or false")
+		}
+		s.AdditionalProperties = allowed
+	}
+
+	if items, ok := doc.Lookup("items"); ok {
+		r, ok := items.(*Roze)
+		if !ok || r.IsArray() {
+			return fail("items must be an object")
+		}
+		s.Items = &Schema{}
+		return s.Items.read(r, append(path, Field("items")))
+	}
+	return nil
+}
@@ -0,0 +1,124 @@
+package rozer
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func rows(t *testing.T, lines ...string) []*Roze {
+	t.Helper()
+	var rows []*Roze
+	for _, line := range lines {
+		r, err := Unmarshal([]byte(line), NumbersPreserve)
+		if err != nil {
+			t.Fatalf("%s: %v", line, err)
+		}
+		rows = append(rows, r)
+	}
+	return rows
+}
+
+func TestInferSchema(t *testing.T) {
+	s := InferSchema(rows(t,
+		`{"id": 1, "name": "one", "price": 2, "tags": ["a"], "address": {"city": "X"}}`,
+		`{"id": 2, "name": null, "price": 2.5, "tags": [], "address": {"city": "Y", "zip": "1"}}`,
+		`{"id": 3, "name": "three", "price": 3, "extra": true}`,
+	)...)
+
+	b, err := json.Marshal(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"$schema":"https://json-schema.org/draft/2020-12/schema","type":"object",` +
+		`"properties":{` +
+		`"id":{"type":"integer"},` +
+		`"name":{"type":["null","string"]},` +
+		`"price":{"type":"number"},` +
+		`"tags":{"type":"array","items":{"type":"string"}},` +
+		`"address":{"type":"object","properties":{"city":{"type":"string"},"zip":{"type":"string"}},"required":["city"],"additionalProperties":false},` +
+		`"extra":{"type":"boolean"}` +
+		`},"required":["id","name","price"],"additionalProperties":false}`
+	if string(b) != want {
+		t.Errorf("got  %s\nwant %s", b, want)
+	}
+
+	if !s.Property("name").Schema.Nullable() || s.Property("id").Schema.Nullable() {
+		t.Errorf("wrong nullability")
+	}
+
+	var read Schema
+	if err := json.Unmarshal(b, &read); err != nil {
+		t.Fatal(err)
+	}
+	again, err := json.Marshal(&read)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(again) != want {
+		t.Errorf("round trip got %s", again)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	s := InferSchema(rows(t,
+		`{"id": 1, "price": 2.5, "items": [{"sku": "a", "qty": 1}], "note": null}`,
+		`{"id": 2, "price": 3, "items": [], "note": "x"}`,
+	)...)
+
+	var got []string
+	for i, r := range rows(t,
+		`{"id": 3, "price": 4, "items": [{"sku": "b", "qty": 2}], "note": "ok"}`,
+		`{"id": 4.0, "price": 1e3, "items": [], "note": null}`,
+		`{"id": "5", "items": [{"sku": 1, "qty": 2.5}, {"sku": "c", "qty": 1, "x": 0}], "note": 1, "more": true}`,
+		`[1, 2]`,
+	) {
+		for _, v := range s.Validate(i+1, r) {
+			got = append(got, v.Error())
+		}
+	}
+
+	want := []string{
+		`row 3: id is string, want integer`,
+		`row 3: price is missing`,
+		`row 3: items[0].sku is integer, want string`,
+		`row 3: items[0].qty is number, want integer`,
+		`row 3: items[1].x is not in the schema`,
+		`row 3: note is integer, want null or string`,
+		`row 3: more is not in the schema`,
+		`row 4: is array, want object`,
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("got\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestReadSchema(t *testing.T) {
+	var s Schema
+	err := json.Unmarshal([]byte(`{
+		"type": "object",
+		"properties": {"a": {"type": "integer"}},
+		"required": ["a", "b"]
+	}`), &s)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// additional properties are allowed unless the schema says not.
+	r := rows(t, `{"a": 1, "c": 2}`)[0]
+	violations := s.Validate(1, r)
+	if len(violations) != 1 || violations[0].Error() != "row 1: b is missing" {
+		t.Errorf("got %v", violations)
+	}
+
+	for _, doc := range []string{
+		`{"type": "thing"}`,
+		`{"properties": {"a": 1}}`,
+		`{"items": {"type": ["string", 2]}}`,
+		`[]`,
+	} {
+		if err := json.Unmarshal([]byte(doc), &s); err == nil {
+			t.Errorf("%s: no error", doc)
+		}
+	}
+}
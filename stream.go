@@ -0,0 +1,206 @@
+package rozer
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/pdk/rozer/pushback"
+)
+
+// ArrayReader reads the elements of a JSON array one at a time, so that an
+// array too big to hold in memory can be processed element by element. Each
+// element must be an object or an array.
+type ArrayReader struct {
+	dec      *pushback.Decoder
+	decoding Decoding
+	path     []string
+	opened   bool
+	done     bool
+	index    int
+}
+
+// NewArrayReader returns an ArrayReader for the elements of the top level
+// JSON array read from r.
+func NewArrayReader(r io.Reader, numbers Numbers) *ArrayReader {
+	return Decoding{Numbers: numbers}.NewArrayReader(r)
+}
+
+// NewArrayReader returns an ArrayReader for the elements of the top level
+// JSON array read from r, decoded as d says.
+func (d Decoding) NewArrayReader(r io.Reader) *ArrayReader {
+	dec := pushback.NewDecoder(r)
+	dec.UseNumber()
+	return &ArrayReader{dec: dec, decoding: d}
+}
+
+// NewPathReader returns an ArrayReader for the elements of the array at path
+// in the JSON document read from r. A path is $ followed by the keys of the
+// objects leading to the array, each written as .key or ["key"], and ends in
+// [*], such as $.items[*] or $.data["line items"][*]. The path $[*] is the top
+// level array.
+func NewPathReader(r io.Reader, path string, numbers Numbers) (*ArrayReader, error) {
+	return Decoding{Numbers: numbers}.NewPathReader(r, path)
+}
+
+// NewPathReader returns an ArrayReader for the elements of the array at path
+// in the JSON document read from r, decoded as d says.
+func (d Decoding) NewPathReader(r io.Reader, path string) (*ArrayReader, error) {
+	keys, err := parsePath(path)
+	if err != nil {
+		return nil, err
+	}
+	ar := d.NewArrayReader(r)
+	ar.path = keys
+	return ar, nil
+}
+
+// parsePath returns the names of the objects leading to the array at path.
+func parsePath(path string) ([]string, error) {
+	if !strings.HasPrefix(path, "$") {
+		return nil, fmt.Errorf("path %q must start with $", path)
+	}
+	p, err := ParsePath(path)
+	if err != nil {
+		return nil, err
+	}
+	if len(p) == 0 || p[len(p)-1].Kind != StepWildcard {
+		return nil, fmt.Errorf("path %q must end with [*]", path)
+	}
+
+	keys := []string{}
+	for _, step := range p[:len(p)-1] {
+		if step.Kind != StepName {
+			return nil, fmt.Errorf("path %q: only names may come before [*]", path)
+		}
+		keys = append(keys, step.Name)
+	}
+	return keys, nil
+}
+
+// Next returns the next element of the array. It returns io.EOF after the
+// last element. Anything following the array in the document is not read.
+func (ar *ArrayReader) Next() (*Roze, error) {
+	if ar.done {
+		return nil, io.EOF
+	}
+	if !ar.opened {
+		err := ar.open()
+		if err != nil {
+			return nil, err
+		}
+		ar.opened = true
+	}
+
+	next, err := ar.dec.Token()
+	if err != nil {
+		return nil, err
+	}
+
+	delim, ok := next.(json.Delim)
+	if !ok {
+		return nil, fmt.Errorf("element %d: expect JSON object or array, found %T: %v", ar.index, next, next)
+	}
+	switch delim {
+	case ']':
+		ar.done = true
+		return nil, io.EOF
+	case '{', '[':
+		ar.dec.Pushback(next)
+		value := &Roze{}
+		err = value.parseObjectOrArray(ar.dec, ar.decoding)
+		if err != nil {
+			return nil, fmt.Errorf("element %d: %w", ar.index, err)
+		}
+		ar.index++
+		return value, nil
+	default:
+		return nil, fmt.Errorf("element %d: unexpected JSON token: %v", ar.index, next)
+	}
+}
+
+// open reads up to the first element of the array at the reader's path.
+func (ar *ArrayReader) open() error {
+	for i, key := range ar.path {
+		err := expectDelim(ar.dec, '{', ar.path[:i])
+		if err != nil {
+			return err
+		}
+		err = ar.findKey(key, ar.path[:i+1])
+		if err != nil {
+			return err
+		}
+	}
+	return expectDelim(ar.dec, '[', ar.path)
+}
+
+// findKey reads the members of an object up to the value of key, skipping
+// the values of other keys.
+func (ar *ArrayReader) findKey(key string, at []string) error {
+	for {
+		next, err := ar.dec.Token()
+		if err != nil {
+			return err
+		}
+		if next == json.Delim('}') {
+			return fmt.Errorf("%s not found", pathString(at))
+		}
+		if next == key {
+			return nil
+		}
+		err = skipValue(ar.dec)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func expectDelim(dec *pushback.Decoder, delim json.Delim, at []string) error {
+	next, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	if next != delim {
+		kind := "object"
+		if delim == '[' {
+			kind = "array"
+		}
+		return fmt.Errorf("%s is not an %s", pathString(at), kind)
+	}
+	return nil
+}
+
+// skipValue reads past one value, however deeply nested.
+func skipValue(dec *pushback.Decoder) error {
+	depth := 0
+	for {
+		next, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		switch next {
+		case json.Delim('{'), json.Delim('['):
+			depth++
+		case json.Delim('}'), json.Delim(']'):
+			depth--
+		}
+		if depth == 0 {
+			return nil
+		}
+	}
+}
+
+func pathString(keys []string) string {
+	p := Path{}
+	for _, key := range keys {
+		p = append(p, Field(key))
+	}
+	if len(p) == 0 {
+		return "$"
+	}
+	if p[0].Kind == StepName && !strings.HasPrefix(p.String(), "[") {
+		return "$." + p.String()
+	}
+	return "$" + p.String()
+}
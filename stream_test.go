@@ -0,0 +1,86 @@
+package rozer
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"strings"
+	"testing"
+)
+
+// readAll returns the elements read by ar, marshaled as JSON.
+func readAll(t *testing.T, ar *ArrayReader) ([]string, error) {
+	t.Helper()
+	got := []string{}
+	for {
+		r, err := ar.Next()
+		if errors.Is(err, io.EOF) {
+			return got, nil
+		}
+		if err != nil {
+			return got, err
+		}
+		b, err := json.Marshal(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, string(b))
+	}
+}
+
+func TestArrayReader(t *testing.T) {
+	f, err := os.Open("rwjson/example.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	got, err := readAll(t, NewArrayReader(f, NumbersPreserve))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		`{"name":"Alice","age":25,"city":"Wonderland"}`,
+		`[{"id":2,"name":"Item 2"},{"id":1,"name":"Item 1"},{"id":3,"name":"Item 3"}]`,
+		`{"person":{"name":"Bob","age":30,"address":{"street":"123 Main St","city":"Cityville"}}}`,
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("got\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestPathReader(t *testing.T) {
+	doc := `{"meta": {"items": "not these"}, "skip": [[{}], 1], "data": {"line items": [{"id": 1}, [2], {"id": 3}]}, "after": 1}`
+
+	tests := []struct {
+		path string
+		want []string
+		err  string
+	}{
+		{`$.data["line items"][*]`, []string{`{"id":1}`, `[2]`, `{"id":3}`}, ""},
+		{`$.skip[*]`, []string{`[{}]`}, `element 1: expect JSON object or array, found json.Number: 1`},
+		{`$.meta.items[*]`, []string{}, `$.meta.items is not an array`},
+		{`$.data.missing[*]`, []string{}, `$.data.missing not found`},
+		{`$[*]`, []string{}, `$ is not an array`},
+		{`$.data`, nil, `path "$.data" must end with [*]`},
+		{`data[*]`, nil, `path "data[*]" must start with $`},
+	}
+
+	for _, tt := range tests {
+		ar, err := NewPathReader(strings.NewReader(doc), tt.path, NumbersPreserve)
+		if err != nil {
+			if err.Error() != tt.err {
+				t.Errorf("%s: got error %v, want %s", tt.path, err, tt.err)
+			}
+			continue
+		}
+		got, err := readAll(t, ar)
+		if strings.Join(got, " ") != strings.Join(tt.want, " ") {
+			t.Errorf("%s: got %v, want %v", tt.path, got, tt.want)
+		}
+		if (err == nil && tt.err != "") || (err != nil && err.Error() != tt.err) {
+			t.Errorf("%s: got error %v, want %q", tt.path, err, tt.err)
+		}
+	}
+}